@@ -0,0 +1,414 @@
+package core
+
+import "fmt"
+
+//Banked is implemented by devices backed by several banks of memory, only
+//one of which is visible on the bus at a time.
+type Banked interface {
+	//Bank returns bank n, whether or not it is currently selected
+	Bank(n int) (SystemBus, error)
+}
+
+//BankedBus is implemented by buses that can access banks that are not
+//currently mapped in. See MappedBus.ReadBank.
+type BankedBus interface {
+	ReadBank(bank int, addr uint16) (uint8, error)
+	WriteBank(bank int, addr uint16, val uint8) error
+}
+
+//linkedForker is implemented by devices that refer to other devices, like
+//bank controllers. fork returns the fork of a referenced device, so that the
+//fork of a controller drives the forks of its banks.
+type linkedForker interface {
+	forkLinked(fork func(SystemBus) SystemBus) SystemBus
+}
+
+//forkDevice returns a fork of device if it implements Forker or
+//linkedForker, and device itself otherwise
+func forkDevice(device SystemBus, fork func(SystemBus) SystemBus) SystemBus {
+	switch d := device.(type) {
+	case linkedForker:
+		return d.forkLinked(fork)
+	case Forker:
+		return d.Fork()
+	}
+	return device
+}
+
+//newForkSet returns a function that forks devices, forking each of them only
+//once no matter how many times it is called for the same device
+func newForkSet() func(SystemBus) SystemBus {
+	forked := make(map[SystemBus]SystemBus)
+	var fork func(SystemBus) SystemBus
+	fork = func(device SystemBus) SystemBus {
+		f, ok := forked[device]
+		if !ok {
+			f = forkDevice(device, fork)
+			forked[device] = f
+		}
+		return f
+	}
+	return fork
+}
+
+//forkAlone forks a device on its own. Devices it refers to are forked along
+//with it.
+func forkAlone(device SystemBus) SystemBus {
+	return newForkSet()(device)
+}
+
+//BankedMemory is a window onto one of several banks. Reads and writes go to
+//the selected bank. Banks can be any devices, usually RAM or ROM of the same
+//size as the window. It counts bank switches, so that a BlockCache notices
+//when the code in the window changes.
+type BankedMemory struct {
+	banks    []SystemBus
+	current  int
+	switches uint64 //number of times another bank was selected
+}
+
+//NewBankedMemory returns a window onto banks with bank 0 selected
+func NewBankedMemory(banks ...SystemBus) *BankedMemory {
+	return &BankedMemory{banks: banks}
+}
+
+//NewBankedRAM returns a window onto n banks of size bytes of RAM
+func NewBankedRAM(n, size int) *BankedMemory {
+	banks := make([]SystemBus, n)
+	for i := range banks {
+		banks[i] = NewRAM(size)
+	}
+	return NewBankedMemory(banks...)
+}
+
+//NewBankedROM returns a window onto image split into banks of size bytes
+func NewBankedROM(image []uint8, size int, policy ROMWritePolicy) *BankedMemory {
+	return NewBankedMemory(SplitROM(image, size, policy)...)
+}
+
+//SplitROM splits image into ROM banks of size bytes. The last bank is padded
+//with zeroes. Use it to map fixed banks next to a switchable window.
+func SplitROM(image []uint8, size int, policy ROMWritePolicy) []SystemBus {
+	var banks []SystemBus
+	for start := 0; start < len(image); start += size {
+		bank := make([]uint8, size)
+		copy(bank, image[start:])
+		banks = append(banks, NewROM(bank, policy))
+	}
+	return banks
+}
+
+//Banks returns the number of banks
+func (mem *BankedMemory) Banks() int {
+	return len(mem.banks)
+}
+
+//Current returns the selected bank
+func (mem *BankedMemory) Current() int {
+	return mem.current
+}
+
+//Select makes bank n visible in the window
+func (mem *BankedMemory) Select(n int) error {
+	if n < 0 || n >= len(mem.banks) {
+		return fmt.Errorf("bank %d does not exist, there are %d banks", n, len(mem.banks))
+	}
+	mem.switchTo(n)
+	return nil
+}
+
+//selectWrapped selects bank n modulo the number of banks, like hardware that
+//ignores the high bits of the bank number
+func (mem *BankedMemory) selectWrapped(n int) {
+	if len(mem.banks) > 0 {
+		mem.switchTo(n % len(mem.banks))
+	}
+}
+
+//switchTo selects bank n and counts the switch if it was not selected already
+func (mem *BankedMemory) switchTo(n int) {
+	if n != mem.current {
+		mem.current = n
+		mem.switches++
+	}
+}
+
+//BankSwitches returns the number of times another bank was selected
+func (mem *BankedMemory) BankSwitches() uint64 {
+	return mem.switches
+}
+
+func (mem *BankedMemory) Bank(n int) (SystemBus, error) {
+	if n < 0 || n >= len(mem.banks) {
+		return nil, fmt.Errorf("bank %d does not exist, there are %d banks", n, len(mem.banks))
+	}
+	return mem.banks[n], nil
+}
+
+func (mem *BankedMemory) Read(addr uint16) uint8 {
+	return mem.banks[mem.current].Read(addr)
+}
+
+func (mem *BankedMemory) Write(addr uint16, val uint8) error {
+	return mem.banks[mem.current].Write(addr, val)
+}
+
+func (mem *BankedMemory) Fork() SystemBus {
+	return forkAlone(mem)
+}
+
+//CheckFork returns an error if a bank can not be forked
+func (mem *BankedMemory) CheckFork() error {
+	for i, bank := range mem.banks {
+		if err := checkFork(bank); err != nil {
+			return fmt.Errorf("bank %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (mem *BankedMemory) forkLinked(fork func(SystemBus) SystemBus) SystemBus {
+	f := &BankedMemory{banks: make([]SystemBus, len(mem.banks)), current: mem.current}
+	for i, bank := range mem.banks {
+		f.banks[i] = fork(bank)
+	}
+	return f
+}
+
+//BankRegister is a control register that selects the bank of one or more
+//banked windows. The value written is masked with Mask and selects the bank
+//in every window, modulo the number of banks. Reads return the last value
+//written. Map it next to a BankedMemory and a fixed ROM bank to get the
+//common fixed plus switchable windows scheme.
+type BankRegister struct {
+	Mask    uint8
+	windows []*BankedMemory
+	value   uint8
+}
+
+//NewBankRegister returns a register selecting banks of windows
+func NewBankRegister(mask uint8, windows ...*BankedMemory) *BankRegister {
+	return &BankRegister{Mask: mask, windows: windows}
+}
+
+func (reg *BankRegister) Read(addr uint16) uint8 {
+	return reg.value
+}
+
+func (reg *BankRegister) Write(addr uint16, val uint8) error {
+	reg.value = val
+	for _, w := range reg.windows {
+		w.selectWrapped(int(val & reg.Mask))
+	}
+	return nil
+}
+
+//BankSwitches returns the number of bank switches in the windows of the
+//register
+func (reg *BankRegister) BankSwitches() uint64 {
+	return countSwitches(reg.windows)
+}
+
+func (reg *BankRegister) Fork() SystemBus {
+	return forkAlone(reg)
+}
+
+func (reg *BankRegister) CheckFork() error {
+	for _, w := range reg.windows {
+		if err := w.CheckFork(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (reg *BankRegister) forkLinked(fork func(SystemBus) SystemBus) SystemBus {
+	f := &BankRegister{Mask: reg.Mask, value: reg.value}
+	for _, w := range reg.windows {
+		f.windows = append(f.windows, fork(w).(*BankedMemory))
+	}
+	return f
+}
+
+//BankLatch is a banked window that latches the bank number on every write
+//to it, the way discrete logic mappers on ROM cartridges work. Reads go to
+//the selected bank. The value written is masked with Mask and never reaches
+//the banks.
+type BankLatch struct {
+	Mask   uint8
+	window *BankedMemory
+}
+
+//NewBankLatch returns a latch selecting banks of window
+func NewBankLatch(mask uint8, window *BankedMemory) *BankLatch {
+	return &BankLatch{Mask: mask, window: window}
+}
+
+func (latch *BankLatch) Read(addr uint16) uint8 {
+	return latch.window.Read(addr)
+}
+
+func (latch *BankLatch) Write(addr uint16, val uint8) error {
+	latch.window.selectWrapped(int(val & latch.Mask))
+	return nil
+}
+
+func (latch *BankLatch) Bank(n int) (SystemBus, error) {
+	return latch.window.Bank(n)
+}
+
+func (latch *BankLatch) BankSwitches() uint64 {
+	return latch.window.BankSwitches()
+}
+
+func (latch *BankLatch) Fork() SystemBus {
+	return forkAlone(latch)
+}
+
+func (latch *BankLatch) CheckFork() error {
+	return latch.window.CheckFork()
+}
+
+func (latch *BankLatch) forkLinked(fork func(SystemBus) SystemBus) SystemBus {
+	return &BankLatch{Mask: latch.Mask, window: fork(latch.window).(*BankedMemory)}
+}
+
+//ProcessorPort is the 6510 on-chip I/O port. Address 0 is the data
+//direction register and address 1 is the data register. Pins set as inputs
+//read Inputs, which are pulled high by default. Windows driven by the port
+//select their bank from the pins whenever they change.
+type ProcessorPort struct {
+	ddr     uint8
+	data    uint8
+	Inputs  uint8
+	drivers []portDriver
+}
+
+type portDriver struct {
+	window *BankedMemory
+	bankOf func(pins uint8) int
+}
+
+func NewProcessorPort() *ProcessorPort {
+	return &ProcessorPort{Inputs: 0xff}
+}
+
+//Drive makes the port select the bank of window. bankOf returns the bank for
+//the state of the port pins.
+func (port *ProcessorPort) Drive(window *BankedMemory, bankOf func(pins uint8) int) {
+	port.drivers = append(port.drivers, portDriver{window: window, bankOf: bankOf})
+	window.selectWrapped(bankOf(port.Pins()))
+}
+
+//Pins returns the state of the port pins
+func (port *ProcessorPort) Pins() uint8 {
+	return port.data&port.ddr | port.Inputs&^port.ddr
+}
+
+func (port *ProcessorPort) Read(addr uint16) uint8 {
+	if addr&1 == 0 {
+		return port.ddr
+	}
+	return port.Pins()
+}
+
+func (port *ProcessorPort) Write(addr uint16, val uint8) error {
+	if addr&1 == 0 {
+		port.ddr = val
+	} else {
+		port.data = val
+	}
+	pins := port.Pins()
+	for _, d := range port.drivers {
+		d.window.selectWrapped(d.bankOf(pins))
+	}
+	return nil
+}
+
+//BankSwitches returns the number of bank switches in the windows driven by
+//the port
+func (port *ProcessorPort) BankSwitches() uint64 {
+	var switches uint64
+	for _, d := range port.drivers {
+		switches += d.window.BankSwitches()
+	}
+	return switches
+}
+
+func (port *ProcessorPort) Fork() SystemBus {
+	return forkAlone(port)
+}
+
+func (port *ProcessorPort) CheckFork() error {
+	for _, d := range port.drivers {
+		if err := d.window.CheckFork(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (port *ProcessorPort) forkLinked(fork func(SystemBus) SystemBus) SystemBus {
+	f := &ProcessorPort{ddr: port.ddr, data: port.data, Inputs: port.Inputs}
+	for _, d := range port.drivers {
+		f.drivers = append(f.drivers, portDriver{window: fork(d.window).(*BankedMemory), bankOf: d.bankOf})
+	}
+	return f
+}
+
+//countSwitches returns the total number of bank switches in windows
+func countSwitches(windows []*BankedMemory) uint64 {
+	var switches uint64
+	for _, w := range windows {
+		switches += w.BankSwitches()
+	}
+	return switches
+}
+
+//BankSwitches returns the total number of bank switches reported by the
+//mapped devices that implement BankSwitcher. A window counted both on its own
+//and through its controller is counted twice, which still changes the total
+//on every switch.
+func (bus *MappedBus) BankSwitches() uint64 {
+	var switches uint64
+	for _, s := range bus.switchers {
+		switches += s.BankSwitches()
+	}
+	return switches
+}
+
+//ReadBank reads addr as if bank were selected in the banked device addr is
+//mapped to, without changing the selected bank.
+func (bus *MappedBus) ReadBank(bank int, addr uint16) (uint8, error) {
+	device, offset, err := bus.bankAt(bank, addr)
+	if err != nil {
+		return 0, err
+	}
+	return device.Read(offset), nil
+}
+
+//WriteBank writes to addr as if bank were selected in the banked device addr
+//is mapped to, without changing the selected bank.
+func (bus *MappedBus) WriteBank(bank int, addr uint16, val uint8) error {
+	device, offset, err := bus.bankAt(bank, addr)
+	if err != nil {
+		return err
+	}
+	return device.Write(offset, val)
+}
+
+func (bus *MappedBus) bankAt(bank int, addr uint16) (SystemBus, uint16, error) {
+	r := bus.Lookup(addr)
+	if r == nil {
+		return nil, 0, fmt.Errorf("address %#04x is not mapped", addr)
+	}
+	banked, ok := r.Device.(Banked)
+	if !ok {
+		return nil, 0, fmt.Errorf("address %#04x is mapped to %s, which has no banks", addr, r.Name)
+	}
+	device, err := banked.Bank(bank)
+	if err != nil {
+		return nil, 0, err
+	}
+	return device, r.offset(addr), nil
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+//bankedMachine builds a MappedBus with RAM below 8000 and two banks of RAM
+//holding code0 and code1 in a window at 8000-BFFF. Writing to 6000 selects
+//the bank.
+func bankedMachine(code0, code1 []uint8) func() (SystemBus, func() []*memoryPage) {
+	return func() (SystemBus, func() []*memoryPage) {
+		ram := NewRAM(0x8000)
+		window := NewBankedRAM(2, 0x4000)
+		for n, code := range [][]uint8{code0, code1} {
+			bank, _ := window.Bank(n)
+			bank.(*RAM).Load(0x0000, code)
+		}
+		bus := NewMappedBus()
+		bus.Map("ram", 0x0000, 0x7fff, ram)
+		bus.Map("window", 0x8000, 0xbfff, window)
+		bus.Map("bank", 0x6000, 0x6000, NewBankRegister(0x01, window))
+		return bus, func() []*memoryPage {
+			pages := append([]*memoryPage(nil), ram.pages...)
+			for n := 0; n < window.Banks(); n++ {
+				bank, _ := window.Bank(n)
+				pages = append(pages, bank.(*RAM).pages...)
+			}
+			return pages
+		}
+	}
+}
+
+//windowOf returns the banked window at 8000 of a bankedMachine
+func windowOf(cpu *CPU) *BankedMemory {
+	bus := cpu.Bus
+	if cached, ok := bus.(*blockCacheBus); ok {
+		bus = cached.SystemBus
+	}
+	return bus.(*MappedBus).Lookup(0x8000).Device.(*BankedMemory)
+}
+
+func TestBlockCacheBankSwitching(t *testing.T) {
+	//both banks switch to the other one in the middle of a block, and the
+	//instructions after the switch differ between them
+	code0 := []uint8{
+		0xa2, 0x00, //LDX #$00
+		0xa9, 0x01, //LDA #$01
+		0x8d, 0x00, 0x60, //STA $6000
+		0xa2, 0x0a, //LDX #$0A
+		0xe8, //INX
+	}
+	code1 := []uint8{
+		0xa2, 0x00, //LDX #$00
+		0xa9, 0x00, //LDA #$00
+		0x8d, 0x00, 0x60, //STA $6000
+		0xa2, 0x1a, //LDX #$1A
+		0xe8, //INX
+	}
+	registers := *NewCPURegisters()
+	registers.ProgramCounter = 0x8000
+	l := newLockstep(t, registers, bankedMachine(code0, code1))
+	for pass, want := range []uint8{0x1b, 0x0b, 0x1b} {
+		l.run(5)
+		if x := l.cached.Registers.X; x != want {
+			t.Fatalf("pass %d: X is %#02x, want %#02x", pass, x, want)
+		}
+		l.both(func(cpu *CPU) { cpu.Registers.ProgramCounter = 0x8000 })
+	}
+	//banks selected by the host between runs are noticed too
+	for _, bank := range []int{0, 1, 0} {
+		l.both(func(cpu *CPU) {
+			windowOf(cpu).Select(bank)
+			cpu.Registers.ProgramCounter = 0x8007
+		})
+		l.run(2)
+		want := [][]uint8{code0, code1}[bank][8] + 1
+		if x := l.cached.Registers.X; x != want {
+			t.Fatalf("bank %d: X is %#02x, want %#02x", bank, x, want)
+		}
+	}
+}
+
+func TestBankSwitchesAreCounted(t *testing.T) {
+	window := NewBankedRAM(4, 0x100)
+	latched := NewBankedRAM(4, 0x100)
+	driven := NewBankedRAM(2, 0x100)
+	port := NewProcessorPort()
+	bus := NewMappedBus()
+	bus.Map("port", 0x0000, 0x0001, port)
+	bus.Map("register", 0x0002, 0x0002, NewBankRegister(0x03, window))
+	bus.Map("latch", 0x0100, 0x01ff, NewBankLatch(0x03, latched))
+	port.Drive(driven, func(pins uint8) int { return int(pins & 0x01) })
+	//middleware reports the switches of the bus it wraps
+	logged := NewLoggingBus(bus, io.Discard)
+	writes := []struct {
+		addr     uint16
+		val      uint8
+		switched bool
+	}{
+		{0x0002, 0x01, true},  //register selects bank 1
+		{0x0002, 0x05, false}, //bank 1 again after masking
+		{0x0150, 0x02, true},  //latch selects bank 2
+		{0x0150, 0x02, false},
+		{0x0001, 0x01, false}, //port pin 0 is still an input pulled high
+		{0x0000, 0x01, false}, //port pin 0 becomes an output, still high
+		{0x0001, 0x00, true},  //port pin 0 goes low
+	}
+	for _, w := range writes {
+		before := logged.BankSwitches()
+		logged.Write(w.addr, w.val)
+		if switched := logged.BankSwitches() != before; switched != w.switched {
+			t.Errorf("write %#02x to %#04x: switched %v, want %v", w.val, w.addr, switched, w.switched)
+		}
+	}
+}
+
+//bankTimer is a window that switches to bank 1 once the cpu ran for a number
+//of cycles, without the cpu writing to the bus. The timer is checked whenever
+//the window is accessed or its bank switches are counted.
+type bankTimer struct {
+	*BankedMemory
+	cpu *CPU
+	at  uint64
+}
+
+func (timer *bankTimer) update() {
+	if timer.cpu != nil && timer.cpu.Cycles() >= timer.at {
+		timer.Select(1)
+	}
+}
+
+func (timer *bankTimer) Read(addr uint16) uint8 {
+	timer.update()
+	return timer.BankedMemory.Read(addr)
+}
+
+func (timer *bankTimer) BankSwitches() uint64 {
+	timer.update()
+	return timer.BankedMemory.BankSwitches()
+}
+
+func TestBlockCacheTimedBankSwitch(t *testing.T) {
+	//a long block in each bank, counting in X in bank 0 and in Y in bank 1
+	code0 := append(bytes.Repeat([]uint8{0xe8}, 20), 0x6c, 0x00, 0x80) //INX, JMP $8000
+	code1 := append(bytes.Repeat([]uint8{0xc8}, 20), 0x6c, 0x00, 0x80) //INY, JMP $8000
+	newBus := func() (SystemBus, func() []*memoryPage) {
+		window := NewBankedRAM(2, 0x100)
+		for n, code := range [][]uint8{code0, code1} {
+			bank, _ := window.Bank(n)
+			bank.(*RAM).Load(0x0000, code)
+		}
+		bus := NewMappedBus()
+		bus.Map("window", 0x8000, 0x80ff, &bankTimer{BankedMemory: window, at: 25})
+		return bus, func() []*memoryPage {
+			return nil
+		}
+	}
+	registers := *NewCPURegisters()
+	registers.ProgramCounter = 0x8000
+	l := newLockstep(t, registers, newBus)
+	for _, cpu := range []*CPU{l.reference, l.cached} {
+		bus := cpu.Bus
+		if cached, ok := bus.(*blockCacheBus); ok {
+			bus = cached.SystemBus
+		}
+		bus.(*MappedBus).Lookup(0x8000).Device.(*bankTimer).cpu = cpu
+	}
+	//the timer expires in the middle of the first block
+	l.run(100)
+	if x := l.cached.Registers.X; x != 13 {
+		t.Fatalf("X is %d, want 13 increments before the timer expired", x)
+	}
+}
@@ -0,0 +1,216 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+)
+
+//BatchJob is the initial state of one machine run by RunBatch.
+type BatchJob struct {
+	//Registers the cpu starts with
+	Registers CPURegisters
+	//Bus the job runs on. It must not be shared with any other job or used by
+	//the caller while the batch is running. If Bus is nil, a new BasicBus is
+	//created and Memory is loaded into it.
+	Bus SystemBus
+	//Memory image loaded at address 0x0000 of a new BasicBus when Bus is nil.
+	//Memory is only read, so jobs may share the same image.
+	Memory []uint8
+}
+
+//StopReason tells why RunBatch stopped a job.
+type StopReason int
+
+const (
+	//StopCondition -> the batch stop condition returned true
+	StopCondition StopReason = iota
+	//StopInstructionLimit -> the job executed the maximum number of instructions
+	StopInstructionLimit
+	//StopSTP -> the cpu executed STP
+	StopSTP
+	//StopWAI -> the cpu executed WAI. Batches never send interrupts, so the job
+	//would wait forever.
+	StopWAI
+	//StopCanceled -> the batch context was canceled
+	StopCanceled
+	//StopError -> the bus returned an error
+	StopError
+)
+
+func (reason StopReason) String() string {
+	switch reason {
+	case StopCondition:
+		return "stop condition"
+	case StopInstructionLimit:
+		return "instruction limit"
+	case StopSTP:
+		return "STP"
+	case StopWAI:
+		return "WAI"
+	case StopCanceled:
+		return "canceled"
+	case StopError:
+		return "bus error"
+	default:
+		return fmt.Sprintf("StopReason(%d)", int(reason))
+	}
+}
+
+//BatchOptions control how RunBatch runs its jobs.
+type BatchOptions struct {
+	//Workers is the number of goroutines running jobs. Defaults to GOMAXPROCS.
+	Workers int
+	//MaxInstructions executed by each job. Zero means no limit.
+	MaxInstructions int
+	//Stop is called every CheckInterval instructions. The job stops when it
+	//returns true. Stop is called concurrently from different workers, each
+	//time with a different cpu.
+	Stop func(cpu *CPU) bool
+	//CheckInterval is the number of instructions executed between checks of
+	//Stop and of the context. Defaults to 1.
+	CheckInterval int
+	//BlockCache runs the jobs with a BlockCache instead of CPU.Execute. This
+	//pays off with larger check intervals.
+	BlockCache bool
+}
+
+//BatchResult is the final state of one job.
+type BatchResult struct {
+	//CPU in its final state. CPU.Bus holds the final memory.
+	CPU *CPU
+	//Instructions executed by the job
+	Instructions int
+	Reason       StopReason
+	//Err is set when Reason is StopError
+	Err error
+}
+
+//RunBatch runs every job on its own CPU until it stops, using a bounded pool
+//of worker goroutines. Results are returned in the same order as jobs. Jobs
+//that have not started when ctx is canceled are returned with StopCanceled
+//and their initial state. A job that panics with anything but a bus error,
+//for example a runtime error in a bus or in the stop condition, makes RunBatch
+//panic with the same value once the other jobs are done.
+func RunBatch(ctx context.Context, jobs []BatchJob, opts BatchOptions) []BatchResult {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	results := make([]BatchResult, len(jobs))
+	next := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		panicked interface{} //first panic of a job
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				func() {
+					//a panic would end the whole program if it was not
+					//passed to the goroutine that called RunBatch
+					defer func() {
+						if r := recover(); r != nil {
+							mu.Lock()
+							if panicked == nil {
+								panicked = r
+							}
+							mu.Unlock()
+						}
+					}()
+					results[i] = runBatchJob(ctx, &jobs[i], &opts)
+				}()
+			}
+		}()
+	}
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	if panicked != nil {
+		panic(panicked)
+	}
+	return results
+}
+
+func newBatchCPU(job *BatchJob) *CPU {
+	bus := job.Bus
+	if bus == nil {
+		basic := NewBasicBus()
+		basic.Load(0x0000, job.Memory)
+		bus = basic
+	}
+	registers := job.Registers
+	return NewCPU(bus, &registers)
+}
+
+func runBatchJob(ctx context.Context, job *BatchJob, opts *BatchOptions) (result BatchResult) {
+	cpu := newBatchCPU(job)
+	result.CPU = cpu
+	defer func() {
+		//cpu.write panics when the bus returns an error
+		if r := recover(); r != nil {
+			err, ok := r.(error)
+			if _, isRuntime := r.(runtime.Error); !ok || isRuntime {
+				panic(r)
+			}
+			result.Reason = StopError
+			result.Err = err
+		}
+	}()
+	limit := opts.MaxInstructions
+	if limit <= 0 {
+		limit = math.MaxInt
+	}
+	interval := opts.CheckInterval
+	if interval <= 0 {
+		interval = 1
+	}
+	var cache *BlockCache
+	if opts.BlockCache {
+		cache = NewBlockCache(cpu)
+		defer cache.Close()
+	}
+	for {
+		if ctx.Err() != nil {
+			result.Reason = StopCanceled
+			return
+		}
+		if result.Instructions >= limit {
+			result.Reason = StopInstructionLimit
+			return
+		}
+		chunk := interval
+		if chunk > limit-result.Instructions {
+			chunk = limit - result.Instructions
+		}
+		if cache != nil {
+			result.Instructions += cache.Run(chunk)
+		} else {
+			for n := 0; n < chunk && !cpu.stopped && !cpu.waiting; n++ {
+				cpu.Execute()
+				result.Instructions++
+			}
+		}
+		switch {
+		case cpu.stopped:
+			result.Reason = StopSTP
+			return
+		case cpu.waiting:
+			result.Reason = StopWAI
+			return
+		case opts.Stop != nil && opts.Stop(cpu):
+			result.Reason = StopCondition
+			return
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestRunBatchBusError(t *testing.T) {
+	bus := NewBasicBus()
+	bus.Load(0x0000, []uint8{0x8d, 0x00, 0x03}) //STA $0300
+	jobs := []BatchJob{{Registers: *NewCPURegisters(), Bus: NewFaultingBus(bus, 0x0300)}}
+	results := RunBatch(context.Background(), jobs, BatchOptions{MaxInstructions: 10})
+	if results[0].Reason != StopError {
+		t.Fatalf("job stopped because of %v, want %v", results[0].Reason, StopError)
+	}
+	var fault *BusFault
+	if !errors.As(results[0].Err, &fault) || fault.Access.Address != 0x0300 {
+		t.Fatalf("job failed with %v, want a bus fault at 0300", results[0].Err)
+	}
+}
+
+func TestRunBatchRuntimeErrorPanics(t *testing.T) {
+	jobs := make([]BatchJob, 4)
+	var missing *CPURegisters
+	defer func() {
+		r := recover()
+		if _, ok := r.(runtime.Error); !ok {
+			t.Fatalf("RunBatch panicked with %v, want a runtime error", r)
+		}
+	}()
+	RunBatch(context.Background(), jobs, BatchOptions{
+		Workers:         2,
+		MaxInstructions: 10,
+		Stop: func(cpu *CPU) bool {
+			return missing.Accumulator == 0
+		},
+	})
+	t.Fatal("RunBatch returned after a runtime error")
+}
+
+//inxImage is memory filled with INX, so X counts the executed instructions
+var inxImage = bytes.Repeat([]uint8{0xe8}, MaxBusSize)
+
+func TestRunBatchResultOrder(t *testing.T) {
+	jobs := make([]BatchJob, 16)
+	for i := range jobs {
+		//LDA #i, a number of NOPs that differs between jobs, STP
+		image := append([]uint8{0xa9, uint8(i)}, bytes.Repeat([]uint8{0xea}, (len(jobs)-i)*50)...)
+		jobs[i] = BatchJob{Registers: *NewCPURegisters(), Memory: append(image, 0xdb)}
+	}
+	results := RunBatch(context.Background(), jobs, BatchOptions{Workers: 4})
+	for i, result := range results {
+		if result.Reason != StopSTP || result.CPU.Registers.Accumulator != uint8(i) {
+			t.Fatalf("result %d stopped because of %v with A=%d, want job %d to stop at STP",
+				i, result.Reason, result.CPU.Registers.Accumulator, i)
+		}
+		if want := 2 + (len(jobs)-i)*50; result.Instructions != want {
+			t.Fatalf("result %d executed %d instructions, want %d", i, result.Instructions, want)
+		}
+	}
+}
+
+func TestRunBatchStop(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         BatchOptions
+		reason       StopReason
+		instructions int
+	}{
+		{"condition", BatchOptions{}, StopCondition, 10},
+		{"condition checked every 4", BatchOptions{CheckInterval: 4}, StopCondition, 12},
+		{"limit", BatchOptions{MaxInstructions: 7}, StopInstructionLimit, 7},
+		{"limit between checks", BatchOptions{MaxInstructions: 7, CheckInterval: 4}, StopInstructionLimit, 7},
+		{"condition with a cache", BatchOptions{CheckInterval: 4, BlockCache: true}, StopCondition, 12},
+		{"limit with a cache", BatchOptions{MaxInstructions: 7, CheckInterval: 4, BlockCache: true}, StopInstructionLimit, 7},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.opts.Stop = func(cpu *CPU) bool {
+				return cpu.Registers.X >= 10
+			}
+			jobs := []BatchJob{{Registers: *NewCPURegisters(), Memory: inxImage}}
+			result := RunBatch(context.Background(), jobs, test.opts)[0]
+			if result.Reason != test.reason || result.Instructions != test.instructions {
+				t.Fatalf("job stopped because of %v after %d instructions, want %v after %d",
+					result.Reason, result.Instructions, test.reason, test.instructions)
+			}
+			if x := int(result.CPU.Registers.X); x != test.instructions {
+				t.Fatalf("X is %d after %d instructions", x, test.instructions)
+			}
+			if _, ok := result.CPU.Bus.(*BasicBus); !ok {
+				t.Fatalf("result bus is %T, want the job's BasicBus", result.CPU.Bus)
+			}
+		})
+	}
+}
+
+func TestRunBatchSTPAndWAI(t *testing.T) {
+	jobs := []BatchJob{
+		{Registers: *NewCPURegisters(), Memory: []uint8{0xe8, 0xdb}}, //INX, STP
+		{Registers: *NewCPURegisters(), Memory: []uint8{0xe8, 0xcb}}, //INX, WAI
+	}
+	for _, cache := range []bool{false, true} {
+		results := RunBatch(context.Background(), jobs, BatchOptions{CheckInterval: 16, BlockCache: cache})
+		for i, reason := range []StopReason{StopSTP, StopWAI} {
+			if results[i].Reason != reason || results[i].Instructions != 2 {
+				t.Errorf("cache %v: job %d stopped because of %v after %d instructions, want %v after 2",
+					cache, i, results[i].Reason, results[i].Instructions, reason)
+			}
+		}
+	}
+}
+
+func TestRunBatchCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	jobs := []BatchJob{{Registers: *NewCPURegisters(), Memory: inxImage}}
+	result := RunBatch(ctx, jobs, BatchOptions{})[0]
+	if result.Reason != StopCanceled || result.Instructions != 0 || result.CPU.Registers.X != 0 {
+		t.Fatalf("job of a canceled batch stopped because of %v after %d instructions",
+			result.Reason, result.Instructions)
+	}
+
+	//canceling stops running jobs at their next check
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	jobs = make([]BatchJob, 4)
+	for i := range jobs {
+		jobs[i] = BatchJob{Registers: *NewCPURegisters(), Memory: inxImage}
+	}
+	results := RunBatch(ctx, jobs, BatchOptions{
+		Workers:       2,
+		CheckInterval: 8,
+		Stop: func(cpu *CPU) bool {
+			if cpu.Instructions() >= 80 {
+				cancel()
+			}
+			return false
+		},
+	})
+	for i, result := range results {
+		if result.Reason != StopCanceled {
+			t.Fatalf("job %d stopped because of %v, want %v", i, result.Reason, StopCanceled)
+		}
+		if result.Instructions > 80 {
+			t.Fatalf("job %d ran for %d instructions after the batch was canceled", i, result.Instructions)
+		}
+	}
+}
+
+func TestStopReasonString(t *testing.T) {
+	if s := StopWAI.String(); s != "WAI" {
+		t.Fatalf("StopWAI is %q", s)
+	}
+	if s := StopReason(42).String(); s != "StopReason(42)" {
+		t.Fatalf("unknown reason is %q", s)
+	}
+}
@@ -0,0 +1,669 @@
+package core
+
+//maxBlockLength limits the number of instructions decoded into one block
+const maxBlockLength = 64
+
+//BlockCache is an optional execution engine. It decodes code into basic
+//blocks the first time it is executed and runs the predecoded instructions
+//on subsequent visits, which saves fetching and decoding every opcode again.
+//
+//Every write that goes through the cpu or through cpu.Bus invalidates the
+//blocks covering the written address, and all blocks are dropped when a bus
+//that implements BankSwitcher switches banks, so self-modifying and bank
+//switched code behave exactly as they do with CPU.Execute. Bank switches are
+//looked for after every instruction, so banks switched by timers or other
+//devices are noticed as well. Code is fetched
+//from the bus only when it is decoded, so code should not be executed from
+//devices whose reads have side effects.
+type BlockCache struct {
+	cpu      *CPU
+	bus      *blockCacheBus
+	blocks   [MaxBusSize]*block //blocks indexed by their start address
+	pages    [256][]*block      //blocks overlapping each 256 byte page
+	touched  bool               //set when a block is invalidated
+	switcher BankSwitcher       //wrapped bus when it switches banks, nil otherwise
+	switches uint64             //bank switches seen so far
+}
+
+//BankSwitcher is implemented by buses whose contents can change without being
+//written to, because they map different banks of memory in. BankSwitches
+//returns the number of bank switches since the bus was created. A BlockCache
+//drops all cached blocks whenever the count changes.
+type BankSwitcher interface {
+	BankSwitches() uint64
+}
+
+type block struct {
+	start        uint16
+	end          uint16 //address of the last byte of the block
+	valid        bool
+	instructions []decodedInstruction
+}
+
+type decodedInstruction struct {
+	execute   func(*CPU, *decodedInstruction)
+	operation func(*CPU)
+	opcode    uint8
+	waits     uint64 //wait states inserted while fetching the instruction
+	address   uint16 //address of the opcode
+	next      uint16 //address of the following instruction
+	arg       uint16 //operand bytes that follow the opcode
+}
+
+//blockCacheBus wraps the cpu bus so that writes made by the host or other
+//devices invalidate cached blocks too.
+type blockCacheBus struct {
+	SystemBus
+	cache *BlockCache
+}
+
+func (bus *blockCacheBus) Write(addr uint16, val uint8) error {
+	bus.cache.invalidate(addr)
+	err := bus.SystemBus.Write(addr, val)
+	bus.cache.checkBanks()
+	return err
+}
+
+//NewBlockCache returns a block cache executing code on cpu. cpu.Bus is
+//wrapped so that all writes made through it are seen by the cache.
+func NewBlockCache(cpu *CPU) *BlockCache {
+	c := &BlockCache{cpu: cpu}
+	c.attach()
+	return c
+}
+
+//attach wraps cpu.Bus. If the bus was swapped since the last call, all
+//cached blocks are dropped, since they were decoded from another bus.
+func (c *BlockCache) attach() {
+	if c.bus != nil && c.cpu.Bus == SystemBus(c.bus) {
+		return
+	}
+	c.Flush()
+	c.bus = &blockCacheBus{c.cpu.Bus, c}
+	c.cpu.Bus = c.bus
+	c.switcher, _ = c.bus.SystemBus.(BankSwitcher)
+	if c.switcher != nil {
+		c.switches = c.switcher.BankSwitches()
+	}
+}
+
+//Close restores cpu.Bus to the bus wrapped by the cache. The cache must not
+//be used afterwards.
+func (c *BlockCache) Close() {
+	if c.bus != nil && c.cpu.Bus == SystemBus(c.bus) {
+		c.cpu.Bus = c.bus.SystemBus
+	}
+	c.Flush()
+	c.bus = nil
+}
+
+//Flush drops all cached blocks.
+func (c *BlockCache) Flush() {
+	for i := range c.pages {
+		for _, b := range c.pages[i] {
+			b.valid = false
+			c.blocks[b.start] = nil
+		}
+		c.pages[i] = nil
+	}
+	c.touched = true
+}
+
+//checkBanks drops all cached blocks if the bus switched banks since the last
+//check
+func (c *BlockCache) checkBanks() {
+	if c.switcher == nil {
+		return
+	}
+	if switches := c.switcher.BankSwitches(); switches != c.switches {
+		c.switches = switches
+		c.Flush()
+	}
+}
+
+func (c *BlockCache) invalidate(addr uint16) {
+	page := c.pages[addr>>8]
+	if len(page) == 0 {
+		return
+	}
+	kept := page[:0]
+	for _, b := range page {
+		if !b.valid {
+			continue
+		}
+		if addr >= b.start && addr <= b.end {
+			b.valid = false
+			c.blocks[b.start] = nil
+			c.touched = true
+			continue
+		}
+		kept = append(kept, b)
+	}
+	for i := len(kept); i < len(page); i++ {
+		page[i] = nil
+	}
+	c.pages[addr>>8] = kept
+}
+
+//Run executes up to n instructions and returns the number of instructions
+//actually executed. It returns early if the cpu is stopped or waiting for an
+//interrupt.
+func (c *BlockCache) Run(n int) int {
+	cpu := c.cpu
+	c.attach()
+	c.checkBanks()
+	cpu.syncBus()
+	executed := 0
+	for executed < n && !cpu.stopped && !cpu.waiting {
+		b := c.blocks[cpu.Registers.ProgramCounter]
+		if b == nil {
+			b = c.decode(cpu.Registers.ProgramCounter)
+		}
+		instructions := b.instructions
+		if len(instructions) > n-executed {
+			instructions = instructions[:n-executed]
+		}
+		//only the last instruction of a block may stop the cpu or jump,
+		//so the block runs to its end unless it gets invalidated
+		c.touched = false
+		for i := range instructions {
+			in := &instructions[i]
+			waits := cpu.waitStates()
+			in.execute(cpu, in)
+			cpu.operand = 0x00
+			cpu.instructions++
+			cpu.cycles += in.waits
+			cpu.addCycles(cpu.instructionCycles(in.opcode), waits)
+			executed++
+			if c.switcher != nil {
+				c.checkBanks()
+			}
+			if c.touched {
+				break
+			}
+		}
+	}
+	return executed
+}
+
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+Predecoded addressing mode handlers
+
+These mirror the addressing mode handlers in instructions.go, but take the
+operand bytes from the decoded instruction instead of reading them from the
+bus again.
+~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+
+//executeJump runs the instruction through the regular dispatcher
+func executeJump(cpu *CPU, in *decodedInstruction) {
+	cpu.Registers.ProgramCounter = in.address + 1
+	cpu.dispatch(in.opcode)
+}
+
+func executeImplied(cpu *CPU, in *decodedInstruction) {
+	cpu.Registers.ProgramCounter = in.next
+	if in.operation != nil {
+		in.operation(cpu)
+	}
+}
+
+func executeAbs(cpu *CPU, in *decodedInstruction) {
+	cpu.Registers.ProgramCounter = in.next
+	cpu.operandAddress = in.arg
+	cpu.operand = cpu.read(cpu.operandAddress)
+	in.operation(cpu)
+}
+
+func executeAix(cpu *CPU, in *decodedInstruction) {
+	cpu.Registers.ProgramCounter = in.next
+	cpu.operandAddress = in.arg + uint16(cpu.Registers.X)
+	cpu.pageCrossed = (cpu.operandAddress^in.arg)&0xff00 != 0
+	cpu.operand = cpu.read(cpu.operandAddress)
+	in.operation(cpu)
+}
+
+func executeAiy(cpu *CPU, in *decodedInstruction) {
+	cpu.Registers.ProgramCounter = in.next
+	cpu.operandAddress = in.arg + uint16(cpu.Registers.Y)
+	cpu.pageCrossed = (cpu.operandAddress^in.arg)&0xff00 != 0
+	cpu.operand = cpu.read(cpu.operandAddress)
+	in.operation(cpu)
+}
+
+func executeImm(cpu *CPU, in *decodedInstruction) {
+	cpu.Registers.ProgramCounter = in.next
+	cpu.operandAddress = in.address + 1
+	cpu.operand = uint8(in.arg)
+	in.operation(cpu)
+}
+
+func executeZpx(cpu *CPU, in *decodedInstruction) {
+	cpu.Registers.ProgramCounter = in.next
+	cpu.operandAddress = in.arg + uint16(cpu.Registers.X)
+	cpu.operand = cpu.read(cpu.operandAddress)
+	in.operation(cpu)
+}
+
+func executeZpy(cpu *CPU, in *decodedInstruction) {
+	cpu.Registers.ProgramCounter = in.next
+	cpu.operandAddress = in.arg + uint16(cpu.Registers.Y)
+	cpu.operand = cpu.read(cpu.operandAddress)
+	in.operation(cpu)
+}
+
+func executeZpii(cpu *CPU, in *decodedInstruction) {
+	cpu.Registers.ProgramCounter = in.next
+	indirectAddress := uint16(cpu.read(in.arg + uint16(cpu.Registers.X)))
+	cpu.operandAddress = uint16(cpu.read(indirectAddress))
+	cpu.operandAddress += uint16(cpu.read(indirectAddress+1)) << 8
+	cpu.operand = cpu.read(cpu.operandAddress)
+	in.operation(cpu)
+}
+
+func executeZpi(cpu *CPU, in *decodedInstruction) {
+	cpu.Registers.ProgramCounter = in.next
+	cpu.operandAddress = uint16(cpu.read(in.arg))
+	cpu.operandAddress += uint16(cpu.read(in.arg+1)) << 8
+	cpu.operand = cpu.read(cpu.operandAddress)
+	in.operation(cpu)
+}
+
+func executeZpiy(cpu *CPU, in *decodedInstruction) {
+	cpu.Registers.ProgramCounter = in.next
+	indirectBaseAddress := in.arg + uint16(cpu.Registers.Y)
+	cpu.operandAddress = uint16(cpu.read(indirectBaseAddress))
+	cpu.operandAddress += uint16(cpu.read(indirectBaseAddress+1)) << 8
+	cpu.operand = cpu.read(cpu.operandAddress)
+	in.operation(cpu)
+}
+
+//decode builds the block starting at addr. A block ends after an instruction
+//that may change the program counter, after maxBlockLength instructions or
+//at the end of the address space.
+func (c *BlockCache) decode(addr uint16) *block {
+	b := &block{start: addr, valid: true}
+	pc := addr
+	for len(b.instructions) < maxBlockLength {
+		waits := c.cpu.waitStates()
+		opcode := c.cpu.read(pc)
+		opcodeWaits := c.cpu.waitStates()
+		info := decodeTable[opcode]
+		in := decodedInstruction{
+			execute:   info.mode.executor(),
+			operation: info.operation,
+			opcode:    opcode,
+			address:   pc,
+		}
+		jump := info.jump
+		size := info.mode.size()
+		if int(pc)+int(size) > 0xffff {
+			//the instruction wraps around the address space,
+			//let the regular dispatcher handle it
+			jump = true
+			size = 0xffff - pc
+		}
+		if jump {
+			in.execute = executeJump
+		}
+		switch info.mode.size() {
+		case 1:
+			in.arg = uint16(c.cpu.read(pc + 1))
+		case 2:
+			in.arg = uint16(c.cpu.read(pc+1)) | uint16(c.cpu.read(pc+2))<<8
+		}
+		//cached instructions are not fetched again, so the wait states of
+		//the fetch are added every time they run. Jumps are run by the
+		//dispatcher, which fetches their operands again.
+		in.waits = opcodeWaits - waits
+		if !jump {
+			in.waits = c.cpu.waitStates() - waits
+		}
+		in.next = pc + 1 + size
+		b.instructions = append(b.instructions, in)
+		b.end = pc + size
+		if jump || b.end == 0xffff {
+			break
+		}
+		pc = in.next
+	}
+	c.blocks[addr] = b
+	for page := int(b.start >> 8); page <= int(b.end>>8); page++ {
+		c.pages[page] = append(c.pages[page], b)
+	}
+	return b
+}
+
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+Decoder lookup table
+
+NOTES: this lookup table was generated automatically from the dispatch switch.
+Each array element corresponds to one CPU opcode. Instructions flagged as jumps
+may change the program counter and always end a block.
+~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+
+type addressingMode uint8
+
+const (
+	modeImplied addressingMode = iota
+	modeAbs
+	modeAi
+	modeAii
+	modeAix
+	modeAiy
+	modeImm
+	modeZp
+	modeZpi
+	modeZpii
+	modeZpiy
+	modeZpx
+	modeZpy
+)
+
+//size returns the number of operand bytes the addressing mode consumes
+func (mode addressingMode) size() uint16 {
+	switch mode {
+	case modeAbs, modeAi, modeAii, modeAix, modeAiy:
+		return 2
+	case modeImm, modeZp, modeZpi, modeZpii, modeZpiy, modeZpx, modeZpy:
+		return 1
+	default:
+		return 0
+	}
+}
+
+//executor returns the predecoded handler for the addressing mode. Modes
+//that change the program counter are always run through executeJump.
+func (mode addressingMode) executor() func(*CPU, *decodedInstruction) {
+	switch mode {
+	case modeAbs:
+		return executeAbs
+	case modeAix:
+		return executeAix
+	case modeAiy:
+		return executeAiy
+	case modeImm:
+		return executeImm
+	case modeZp:
+		//a zero page address is an absolute address with a zero high byte
+		return executeAbs
+	case modeZpx:
+		return executeZpx
+	case modeZpy:
+		return executeZpy
+	case modeZpii:
+		return executeZpii
+	case modeZpi:
+		return executeZpi
+	case modeZpiy:
+		return executeZpiy
+	case modeImplied:
+		return executeImplied
+	default:
+		return executeJump
+	}
+}
+
+type decodeInfo struct {
+	mode      addressingMode
+	operation func(*CPU)
+	jump      bool
+}
+
+var decodeTable = [256]decodeInfo{
+	{modeImplied, (*CPU).brk, true},   //0
+	{modeZpii, (*CPU).ora, false},     //1
+	{modeImplied, nil, false},         //2
+	{modeImplied, nil, false},         //3
+	{modeZp, (*CPU).tsb, false},       //4
+	{modeZp, (*CPU).ora, false},       //5
+	{modeZp, (*CPU).asl, false},       //6
+	{modeZp, (*CPU).rmb0, false},      //7
+	{modeImplied, (*CPU).php, false},  //8
+	{modeImm, (*CPU).ora, false},      //9
+	{modeImplied, (*CPU).asla, false}, //10
+	{modeImplied, nil, false},         //11
+	{modeAbs, (*CPU).tsb, false},      //12
+	{modeAbs, (*CPU).ora, false},      //13
+	{modeAbs, (*CPU).asl, false},      //14
+	{modeZp, (*CPU).bbr0, true},       //15
+	{modeImplied, (*CPU).bpl, true},   //16
+	{modeZpiy, (*CPU).ora, false},     //17
+	{modeZpi, (*CPU).ora, false},      //18
+	{modeImplied, nil, false},         //19
+	{modeZp, (*CPU).trb, false},       //20
+	{modeZpx, (*CPU).ora, false},      //21
+	{modeZpx, (*CPU).asl, false},      //22
+	{modeZp, (*CPU).rmb1, false},      //23
+	{modeImplied, (*CPU).clc, false},  //24
+	{modeAiy, (*CPU).ora, false},      //25
+	{modeImplied, (*CPU).inca, false}, //26
+	{modeImplied, nil, false},         //27
+	{modeAbs, (*CPU).trb, false},      //28
+	{modeAix, (*CPU).ora, false},      //29
+	{modeAix, (*CPU).asl, false},      //30
+	{modeZp, (*CPU).bbr1, true},       //31
+	{modeAbs, (*CPU).jsr, true},       //32
+	{modeZpii, (*CPU).and, false},     //33
+	{modeImplied, nil, false},         //34
+	{modeImplied, nil, false},         //35
+	{modeZp, (*CPU).bit, false},       //36
+	{modeZp, (*CPU).and, false},       //37
+	{modeZp, (*CPU).rol, false},       //38
+	{modeZp, (*CPU).rmb2, false},      //39
+	{modeImplied, (*CPU).plp, false},  //40
+	{modeImm, (*CPU).and, false},      //41
+	{modeImplied, (*CPU).rola, false}, //42
+	{modeImplied, nil, false},         //43
+	{modeAbs, (*CPU).bit, false},      //44
+	{modeAbs, (*CPU).and, false},      //45
+	{modeAbs, (*CPU).rol, false},      //46
+	{modeZp, (*CPU).bbr2, true},       //47
+	{modeImplied, (*CPU).bmi, true},   //48
+	{modeZpiy, (*CPU).and, false},     //49
+	{modeZp, (*CPU).and, false},       //50
+	{modeImplied, nil, false},         //51
+	{modeZpx, (*CPU).bit, false},      //52
+	{modeZpx, (*CPU).and, false},      //53
+	{modeZpx, (*CPU).rol, false},      //54
+	{modeZp, (*CPU).rmb3, false},      //55
+	{modeImplied, (*CPU).sec, false},  //56
+	{modeAiy, (*CPU).and, false},      //57
+	{modeImplied, (*CPU).deca, false}, //58
+	{modeImplied, nil, false},         //59
+	{modeAix, (*CPU).bit, false},      //60
+	{modeAix, (*CPU).and, false},      //61
+	{modeAix, (*CPU).rol, false},      //62
+	{modeZp, (*CPU).bbr3, true},       //63
+	{modeImplied, (*CPU).rti, true},   //64
+	{modeZpii, (*CPU).eor, false},     //65
+	{modeImplied, nil, false},         //66
+	{modeImplied, nil, false},         //67
+	{modeImplied, nil, false},         //68
+	{modeZp, (*CPU).eor, false},       //69
+	{modeZp, (*CPU).lsr, false},       //70
+	{modeZp, (*CPU).rmb4, false},      //71
+	{modeImplied, (*CPU).pha, false},  //72
+	{modeImm, (*CPU).eor, false},      //73
+	{modeImplied, (*CPU).lsra, false}, //74
+	{modeImplied, nil, false},         //75
+	{modeAbs, (*CPU).jmp, true},       //76
+	{modeAbs, (*CPU).eor, false},      //77
+	{modeAbs, (*CPU).lsr, false},      //78
+	{modeAbs, (*CPU).bbr4, true},      //79
+	{modeImplied, (*CPU).bvc, true},   //80
+	{modeZpiy, (*CPU).eor, false},     //81
+	{modeZpi, (*CPU).eor, false},      //82
+	{modeImplied, nil, false},         //83
+	{modeImplied, nil, false},         //84
+	{modeZpx, (*CPU).eor, false},      //85
+	{modeZpx, (*CPU).lsr, false},      //86
+	{modeZp, (*CPU).rmb5, false},      //87
+	{modeImplied, (*CPU).cli, false},  //88
+	{modeAiy, (*CPU).eor, false},      //89
+	{modeImplied, (*CPU).phy, false},  //90
+	{modeImplied, nil, false},         //91
+	{modeImplied, nil, false},         //92
+	{modeAix, (*CPU).eor, false},      //93
+	{modeAix, (*CPU).lsr, false},      //94
+	{modeZp, (*CPU).bbr5, true},       //95
+	{modeImplied, (*CPU).rts, true},   //96
+	{modeZpii, (*CPU).adc, false},     //97
+	{modeImplied, nil, false},         //98
+	{modeImplied, nil, false},         //99
+	{modeZp, (*CPU).stz, false},       //100
+	{modeZp, (*CPU).adc, false},       //101
+	{modeZp, (*CPU).ror, false},       //102
+	{modeZp, (*CPU).rmb6, false},      //103
+	{modeImplied, (*CPU).pla, false},  //104
+	{modeImm, (*CPU).adc, false},      //105
+	{modeImplied, (*CPU).rora, false}, //106
+	{modeImplied, nil, false},         //107
+	{modeAi, nil, true},               //108
+	{modeAbs, (*CPU).adc, false},      //109
+	{modeAbs, (*CPU).ror, false},      //110
+	{modeZp, (*CPU).bbr6, true},       //111
+	{modeImplied, (*CPU).bvs, true},   //112
+	{modeZpiy, (*CPU).adc, false},     //113
+	{modeZpi, (*CPU).adc, false},      //114
+	{modeImplied, nil, false},         //115
+	{modeZpx, (*CPU).stz, false},      //116
+	{modeZpx, (*CPU).adc, false},      //117
+	{modeZpx, (*CPU).ror, false},      //118
+	{modeZp, (*CPU).rmb7, false},      //119
+	{modeImplied, (*CPU).sei, false},  //120
+	{modeAiy, (*CPU).adc, false},      //121
+	{modeImplied, (*CPU).ply, false},  //122
+	{modeImplied, nil, false},         //123
+	{modeAii, (*CPU).jmp, true},       //124
+	{modeAix, (*CPU).adc, false},      //125
+	{modeAix, (*CPU).ror, false},      //126
+	{modeZp, (*CPU).bbr7, true},       //127
+	{modeImplied, (*CPU).pcr, true},   //128
+	{modeZpii, (*CPU).sta, false},     //129
+	{modeImplied, nil, false},         //130
+	{modeImplied, nil, false},         //131
+	{modeZp, (*CPU).sty, false},       //132
+	{modeZp, (*CPU).sta, false},       //133
+	{modeZp, (*CPU).stx, false},       //134
+	{modeZp, (*CPU).smb0, false},      //135
+	{modeImplied, (*CPU).dey, false},  //136
+	{modeImm, (*CPU).bit, false},      //137
+	{modeImplied, (*CPU).txa, false},  //138
+	{modeImplied, nil, false},         //139
+	{modeAbs, (*CPU).sty, false},      //140
+	{modeAbs, (*CPU).sta, false},      //141
+	{modeAbs, (*CPU).stx, false},      //142
+	{modeZp, (*CPU).bbs0, true},       //143
+	{modeImplied, (*CPU).bcc, true},   //144
+	{modeZpiy, (*CPU).sta, false},     //145
+	{modeZpi, (*CPU).sta, false},      //146
+	{modeImplied, nil, false},         //147
+	{modeZpx, (*CPU).sty, false},      //148
+	{modeZpx, (*CPU).sta, false},      //149
+	{modeZpy, (*CPU).stx, false},      //150
+	{modeZp, (*CPU).smb1, false},      //151
+	{modeImplied, (*CPU).tya, false},  //152
+	{modeAiy, (*CPU).sta, false},      //153
+	{modeImplied, (*CPU).txs, false},  //154
+	{modeImplied, nil, false},         //155
+	{modeAbs, (*CPU).stz, false},      //156
+	{modeAix, (*CPU).sta, false},      //157
+	{modeAix, (*CPU).stz, false},      //158
+	{modeZp, (*CPU).bbs1, true},       //159
+	{modeImm, (*CPU).ldy, false},      //160
+	{modeZpii, (*CPU).lda, false},     //161
+	{modeImm, (*CPU).ldx, false},      //162
+	{modeImplied, nil, false},         //163
+	{modeZp, (*CPU).ldy, false},       //164
+	{modeZp, (*CPU).lda, false},       //165
+	{modeZp, (*CPU).ldx, false},       //166
+	{modeZp, (*CPU).smb2, false},      //167
+	{modeImplied, (*CPU).tay, false},  //168
+	{modeImm, (*CPU).lda, false},      //169
+	{modeImplied, (*CPU).tax, false},  //170
+	{modeImplied, nil, false},         //171
+	{modeImplied, (*CPU).ldy, false},  //172
+	{modeAbs, (*CPU).lda, false},      //173
+	{modeAbs, (*CPU).ldx, false},      //174
+	{modeZp, (*CPU).bbs2, true},       //175
+	{modeImplied, (*CPU).bcs, true},   //176
+	{modeZpiy, (*CPU).lda, false},     //177
+	{modeZpi, (*CPU).lda, false},      //178
+	{modeImplied, nil, false},         //179
+	{modeZpx, (*CPU).ldy, false},      //180
+	{modeZpx, (*CPU).lda, false},      //181
+	{modeZpy, (*CPU).ldx, false},      //182
+	{modeZp, (*CPU).smb3, false},      //183
+	{modeImplied, (*CPU).clv, false},  //184
+	{modeAiy, (*CPU).lda, false},      //185
+	{modeImplied, (*CPU).tsx, false},  //186
+	{modeImplied, nil, false},         //187
+	{modeAix, (*CPU).ldy, false},      //188
+	{modeAix, (*CPU).lda, false},      //189
+	{modeAiy, (*CPU).ldx, false},      //190
+	{modeZp, (*CPU).bbs3, true},       //191
+	{modeImm, (*CPU).cpy, false},      //192
+	{modeZpii, (*CPU).cmp, false},     //193
+	{modeImplied, nil, false},         //194
+	{modeImplied, nil, false},         //195
+	{modeZp, (*CPU).cpy, false},       //196
+	{modeZp, (*CPU).cmp, false},       //197
+	{modeZp, (*CPU).dec, false},       //198
+	{modeZp, (*CPU).smb4, false},      //199
+	{modeImplied, (*CPU).iny, false},  //200
+	{modeImm, (*CPU).cmp, false},      //201
+	{modeImplied, (*CPU).dex, false},  //202
+	{modeImplied, (*CPU).wai, true},   //203
+	{modeAbs, (*CPU).cpy, false},      //204
+	{modeAbs, (*CPU).cmp, false},      //205
+	{modeAbs, (*CPU).dec, false},      //206
+	{modeZp, (*CPU).bbs4, true},       //207
+	{modeImplied, (*CPU).bne, true},   //208
+	{modeZpiy, (*CPU).cmp, false},     //209
+	{modeZpi, (*CPU).cmp, false},      //210
+	{modeImplied, nil, false},         //211
+	{modeImplied, nil, false},         //212
+	{modeZpx, (*CPU).cmp, false},      //213
+	{modeZpx, (*CPU).dec, false},      //214
+	{modeZp, (*CPU).smb5, false},      //215
+	{modeImplied, (*CPU).cld, false},  //216
+	{modeAiy, (*CPU).cmp, false},      //217
+	{modeImplied, (*CPU).phx, false},  //218
+	{modeImplied, (*CPU).stp, true},   //219
+	{modeImplied, nil, false},         //220
+	{modeAix, (*CPU).cmp, false},      //221
+	{modeAix, (*CPU).dec, false},      //222
+	{modeZp, (*CPU).bbs5, true},       //223
+	{modeImm, (*CPU).cpx, false},      //224
+	{modeZpii, (*CPU).sbc, false},     //225
+	{modeImplied, nil, false},         //226
+	{modeImplied, nil, false},         //227
+	{modeZp, (*CPU).cpx, false},       //228
+	{modeZp, (*CPU).sbc, false},       //229
+	{modeZp, (*CPU).inc, false},       //230
+	{modeZp, (*CPU).smb6, false},      //231
+	{modeImplied, (*CPU).inx, false},  //232
+	{modeImm, (*CPU).sbc, false},      //233
+	{modeImplied, nil, false},         //234
+	{modeImplied, nil, false},         //235
+	{modeAbs, (*CPU).cpx, false},      //236
+	{modeAbs, (*CPU).sbc, false},      //237
+	{modeAbs, (*CPU).inc, false},      //238
+	{modeZp, (*CPU).bbs6, true},       //239
+	{modeImplied, (*CPU).beq, true},   //240
+	{modeZpiy, (*CPU).sbc, false},     //241
+	{modeZpi, (*CPU).sbc, false},      //242
+	{modeImplied, nil, false},         //243
+	{modeImplied, nil, false},         //244
+	{modeZpx, (*CPU).sbc, false},      //245
+	{modeZpx, (*CPU).inc, false},      //246
+	{modeZp, (*CPU).smb7, false},      //247
+	{modeImplied, (*CPU).sed, false},  //248
+	{modeAiy, (*CPU).sbc, false},      //249
+	{modeImplied, (*CPU).plx, false},  //250
+	{modeImplied, nil, false},         //251
+	{modeImplied, nil, false},         //252
+	{modeAix, (*CPU).sbc, false},      //253
+	{modeAix, (*CPU).inc, false},      //254
+	{modeZp, (*CPU).bbs7, true},       //255
+}
@@ -0,0 +1,254 @@
+package core
+
+import (
+	"math/rand"
+	"testing"
+)
+
+//lockstep runs a cpu with Execute and another one from a BlockCache on two
+//identical machines, so that their states can be compared after every step
+type lockstep struct {
+	t         *testing.T
+	reference *CPU
+	cached    *CPU
+	cache     *BlockCache
+	//memory of the reference and of the cached machine
+	referenceMemory func() []*memoryPage
+	cachedMemory    func() []*memoryPage
+	steps           int
+}
+
+//newLockstep builds two machines with newBus. newBus returns the bus and a
+//function returning the pages of its memory.
+func newLockstep(t *testing.T, registers CPURegisters, newBus func() (SystemBus, func() []*memoryPage)) *lockstep {
+	l := &lockstep{t: t}
+	var bus SystemBus
+	bus, l.referenceMemory = newBus()
+	referenceRegisters := registers
+	l.reference = NewCPU(bus, &referenceRegisters)
+	bus, l.cachedMemory = newBus()
+	cachedRegisters := registers
+	l.cached = NewCPU(bus, &cachedRegisters)
+	l.cache = NewBlockCache(l.cached)
+	return l
+}
+
+//step executes one instruction on both machines and compares them
+func (l *lockstep) step() {
+	l.t.Helper()
+	l.reference.Execute()
+	l.cache.Run(1)
+	l.steps++
+	l.compare()
+}
+
+//run lets the cache run up to n instructions in one go, executes as many
+//instructions on the reference and compares both machines
+func (l *lockstep) run(n int) {
+	l.t.Helper()
+	executed := l.cache.Run(n)
+	for i := 0; i < executed; i++ {
+		l.reference.Execute()
+	}
+	l.steps += executed
+	l.compare()
+}
+
+//both calls f on both cpus and compares them afterwards
+func (l *lockstep) both(f func(cpu *CPU)) {
+	l.t.Helper()
+	f(l.reference)
+	f(l.cached)
+	l.compare()
+}
+
+func (l *lockstep) compare() {
+	l.t.Helper()
+	ref, cached := l.reference, l.cached
+	if *ref.Registers != *cached.Registers {
+		l.t.Fatalf("step %d: registers %+v, want %+v", l.steps, *cached.Registers, *ref.Registers)
+	}
+	if ref.Cycles() != cached.Cycles() {
+		l.t.Fatalf("step %d: %d cycles, want %d", l.steps, cached.Cycles(), ref.Cycles())
+	}
+	if ref.Instructions() != cached.Instructions() {
+		l.t.Fatalf("step %d: %d instructions, want %d", l.steps, cached.Instructions(), ref.Instructions())
+	}
+	if ref.Waiting() != cached.Waiting() || ref.Stopped() != cached.Stopped() {
+		l.t.Fatalf("step %d: waiting %v stopped %v, want waiting %v stopped %v",
+			l.steps, cached.Waiting(), cached.Stopped(), ref.Waiting(), ref.Stopped())
+	}
+	refMemory, cachedMemory := l.referenceMemory(), l.cachedMemory()
+	for n := range refMemory {
+		if *refMemory[n] == *cachedMemory[n] {
+			continue
+		}
+		for offset := range refMemory[n] {
+			if refMemory[n][offset] != cachedMemory[n][offset] {
+				l.t.Fatalf("step %d: memory at %#04x is %#02x, want %#02x",
+					l.steps, n*pageSize+offset, cachedMemory[n][offset], refMemory[n][offset])
+			}
+		}
+	}
+}
+
+//basicBusMachine returns a function building BasicBus machines loaded with
+//image
+func basicBusMachine(image []uint8) func() (SystemBus, func() []*memoryPage) {
+	return func() (SystemBus, func() []*memoryPage) {
+		bus := NewBasicBus()
+		bus.Load(0x0000, image)
+		return bus, func() []*memoryPage {
+			return bus.pages[:]
+		}
+	}
+}
+
+//mappedBusMachine returns a function building MappedBus machines loaded with
+//image, with wait states on the upper half of the address space
+func mappedBusMachine(image []uint8) func() (SystemBus, func() []*memoryPage) {
+	return func() (SystemBus, func() []*memoryPage) {
+		low, high := NewRAM(0x8000), NewRAM(0x8000)
+		low.Load(0x0000, image[:0x8000])
+		high.Load(0x0000, image[0x8000:])
+		bus := NewMappedBus()
+		bus.Map("low", 0x0000, 0x7fff, low)
+		bus.MapRegion(Region{Name: "high", Start: 0x8000, End: 0xffff, Device: high, WaitStates: 1})
+		return bus, func() []*memoryPage {
+			return append(append([]*memoryPage(nil), low.pages...), high.pages...)
+		}
+	}
+}
+
+func TestBlockCacheSelfModifyingCode(t *testing.T) {
+	image := make([]uint8, MaxBusSize)
+	copy(image[0x0200:], []uint8{
+		0xa9, 0x00, //LDA #$00
+		0xee, 0x01, 0x02, //INC $0201, the operand of LDA
+		0xa2, 0x10, //LDX #$10
+		0x8e, 0x06, 0x02, //STX $0206, the operand of LDX, which already ran
+		0xa0, 0x00, //LDY #$00
+	})
+	registers := *NewCPURegisters()
+	registers.ProgramCounter = 0x0200
+	l := newLockstep(t, registers, basicBusMachine(image))
+	for pass := 0; pass < 3; pass++ {
+		for i := 0; i < 5; i++ {
+			l.step()
+		}
+		if l.cached.Registers.Accumulator != uint8(pass) {
+			t.Fatalf("pass %d: A is %#02x, the cache ran stale code", pass, l.cached.Registers.Accumulator)
+		}
+		l.both(func(cpu *CPU) { cpu.Registers.ProgramCounter = 0x0200 })
+	}
+	//the whole program runs as one block once it stops changing
+	l.cached.Bus.Write(0x0203, 0x00) //INC $0200 modifies the opcode of LDA instead
+	l.reference.Bus.Write(0x0203, 0x00)
+	l.run(5)
+	l.both(func(cpu *CPU) { cpu.Registers.ProgramCounter = 0x0200 })
+	l.run(5)
+	if l.cached.Bus.Read(0x0200) != 0xab {
+		t.Fatalf("opcode at 0200 is %#02x, want ab", l.cached.Bus.Read(0x0200))
+	}
+}
+
+func TestBlockCacheInterrupts(t *testing.T) {
+	image := make([]uint8, MaxBusSize)
+	copy(image[0x0200:], []uint8{
+		0x58, //CLI
+		0xe8, //INX
+		0xe8, //INX
+		0xe8, //INX
+		0xcb, //WAI
+		0xc8, //INY
+		0xea, //NOP
+		0xea, //NOP
+		0xf8, //SED
+		0xea, //NOP
+		0xea, //NOP
+	})
+	copy(image[0x0300:], []uint8{
+		0x48, //PHA
+		0x68, //PLA
+		0x40, //RTI
+	})
+	image[0xfffa], image[0xfffb] = 0x00, 0x03
+	image[0xfffe], image[0xffff] = 0x00, 0x03
+	registers := *NewCPURegisters()
+	registers.ProgramCounter = 0x0200
+	l := newLockstep(t, registers, basicBusMachine(image))
+	l.step()
+	l.step()
+	//interrupt in the middle of a block that was decoded already
+	l.both((*CPU).Interrupt)
+	if l.cached.Registers.ProgramCounter != 0x0300 {
+		t.Fatalf("interrupt went to %#04x, want 0300", l.cached.Registers.ProgramCounter)
+	}
+	for i := 0; i < 3; i++ {
+		l.step()
+	}
+	l.run(10)
+	if !l.cached.Waiting() {
+		t.Fatal("cpu does not wait after WAI")
+	}
+	l.both((*CPU).Interrupt)
+	l.run(3)
+	l.both((*CPU).NMInterrupt)
+	l.run(100)
+	l.both((*CPU).Reset)
+}
+
+//TestBlockCacheLockstepRandom runs random memory images, which execute every
+//opcode, modify their own code and write to the stack and vectors, and sends
+//interrupts, resets and host writes at random points
+func TestBlockCacheLockstepRandom(t *testing.T) {
+	machines := []struct {
+		name  string
+		build func([]uint8) func() (SystemBus, func() []*memoryPage)
+	}{
+		{"BasicBus", basicBusMachine},
+		{"MappedBus", mappedBusMachine},
+	}
+	for _, m := range machines {
+		t.Run(m.name, func(t *testing.T) {
+			for seed := int64(1); seed <= 8; seed++ {
+				rng := rand.New(rand.NewSource(seed))
+				image := make([]uint8, MaxBusSize)
+				rng.Read(image)
+				registers := *NewCPURegisters()
+				registers.ProgramCounter = uint16(rng.Intn(MaxBusSize))
+				l := newLockstep(t, registers, m.build(image))
+				for i := 0; i < 5000; i++ {
+					switch event := rng.Intn(100); {
+					case event < 2:
+						l.both((*CPU).Interrupt)
+					case event < 3:
+						l.both((*CPU).NMInterrupt)
+					case event < 5:
+						addr, val := uint16(rng.Intn(MaxBusSize)), uint8(rng.Intn(256))
+						l.both(func(cpu *CPU) { cpu.Bus.Write(addr, val) })
+					case l.cached.Stopped() || l.cached.Waiting():
+						l.both((*CPU).Reset)
+					case event < 50:
+						l.run(1 + rng.Intn(maxBlockLength*2))
+					default:
+						l.step()
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBlockCacheBasicBus(b *testing.B) {
+	bus := NewBasicBus()
+	bus.Load(0x0000, benchmarkImage())
+	cpu := NewCPU(bus, NewCPURegisters())
+	cache := NewBlockCache(cpu)
+	start := cpu.Cycles()
+	b.ReportAllocs()
+	b.ResetTimer()
+	cache.Run(b.N)
+	b.StopTimer()
+	reportMHz(b, cpu.Cycles()-start)
+}
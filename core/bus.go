@@ -1,5 +1,7 @@
 package core
 
+import "fmt"
+
 //Maximum addressable range for the wdc65c02
 const MaxBusSize int = 1024 * 64
 
@@ -12,23 +14,98 @@ type SystemBus interface {
 	Write(addr uint16, val uint8) error
 }
 
+//Forker is implemented by buses that can be forked. Fork returns a copy of
+//the bus that evolves independently from the original. Forks may share
+//unmodified state with the original, but writes to one of them must never be
+//visible to the other, so that both can be used from different goroutines.
+type Forker interface {
+	Fork() SystemBus
+}
+
+//ForkChecker is implemented by Forkers built from other buses or devices,
+//like MappedBus and middleware. Their forks share the parts that can not be
+//forked with the original. CheckFork returns an error naming the first such
+//part, and nil if the forks are independent.
+type ForkChecker interface {
+	CheckFork() error
+}
+
+//checkFork returns an error if bus can not be forked into an independent copy
+func checkFork(bus SystemBus) error {
+	if _, ok := bus.(Forker); !ok {
+		return fmt.Errorf("%T can not be forked", bus)
+	}
+	if checker, ok := bus.(ForkChecker); ok {
+		return checker.CheckFork()
+	}
+	return nil
+}
+
+//pageSize is the granularity at which BasicBus memory is shared between forks
+const pageSize = 256
+
+type memoryPage [pageSize]uint8
+
+//zeroPage backs every page of a new BasicBus until it is written to. It is
+//never written to itself.
+var zeroPage = new(memoryPage)
+
 //BasicBus represents the simplest Bus that could be used with the wdc65c02 cpu.
-//The only device connected is 64K of RAM.
+//The only device connected is 64K of RAM. The memory is split into pages that
+//are shared with forks of the bus and copied on the first write.
 type BasicBus struct {
-	memory []uint8
+	pages [MaxBusSize / pageSize]*memoryPage
+	owned [MaxBusSize / pageSize]bool //page is not shared and can be written in place
 }
 
 func NewBasicBus() *BasicBus {
-	return &BasicBus{
-		make([]uint8, MaxBusSize),
+	bus := &BasicBus{}
+	for i := range bus.pages {
+		bus.pages[i] = zeroPage
 	}
+	return bus
 }
 
 func (bus *BasicBus) Read(addr uint16) uint8 {
-	return bus.memory[addr]
+	return bus.pages[addr>>8][addr&0xff]
 }
 
 func (bus *BasicBus) Write(addr uint16, val uint8) error {
-	bus.memory[addr] = val
+	bus.writablePage(addr >> 8)[addr&0xff] = val
 	return nil
 }
+
+//writablePage returns page n, copying it first if it is shared
+func (bus *BasicBus) writablePage(n uint16) *memoryPage {
+	if !bus.owned[n] {
+		page := *bus.pages[n]
+		bus.pages[n] = &page
+		bus.owned[n] = true
+	}
+	return bus.pages[n]
+}
+
+//Load copies data to the bus memory starting at addr. Data that does not fit
+//below the end of the address space is ignored.
+func (bus *BasicBus) Load(addr uint16, data []uint8) {
+	for len(data) > 0 {
+		offset := int(addr & 0xff)
+		n := copy(bus.writablePage(addr >> 8)[offset:], data)
+		data = data[n:]
+		if int(addr)+n > 0xffff {
+			break
+		}
+		addr += uint16(n)
+	}
+}
+
+//Fork returns a copy of the bus. Both buses share all pages until one of
+//them writes to a page. Fork must not be called concurrently with other
+//accesses to the bus.
+func (bus *BasicBus) Fork() SystemBus {
+	fork := &BasicBus{pages: bus.pages}
+	for i := range bus.owned {
+		bus.owned[i] = false
+	}
+	return fork
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBasicBusFork(t *testing.T) {
+	bus := NewBasicBus()
+	bus.Load(0x0200, []uint8{0x11, 0x22})
+	fork := bus.Fork().(*BasicBus)
+	if fork.Read(0x0200) != 0x11 || fork.Read(0x0201) != 0x22 {
+		t.Fatal("fork does not see memory written before it was made")
+	}
+	writes := []struct {
+		name  string
+		bus   *BasicBus
+		other *BasicBus
+		addr  uint16
+	}{
+		{"original, shared page", bus, fork, 0x0200},
+		{"fork, shared page", fork, bus, 0x0201},
+		{"original, zero page", bus, fork, 0x8000},
+		{"fork, zero page", fork, bus, 0x9000},
+	}
+	for _, w := range writes {
+		before := w.other.Read(w.addr)
+		w.bus.Write(w.addr, 0x42)
+		if w.bus.Read(w.addr) != 0x42 {
+			t.Errorf("%s: write is lost", w.name)
+		}
+		if w.other.Read(w.addr) != before {
+			t.Errorf("%s: write is visible on the other side", w.name)
+		}
+	}
+	//pages written after a fork are shared again by the next one
+	second := fork.Fork().(*BasicBus)
+	fork.Load(0x0200, []uint8{0x33})
+	if second.Read(0x0200) != 0x11 || bus.Read(0x0200) != 0x42 {
+		t.Error("load into a fork is visible in other buses")
+	}
+	for i, val := range zeroPage {
+		if val != 0 {
+			t.Fatalf("zeroPage was written at %#02x", i)
+		}
+	}
+}
+
+func TestCPUForkCopiesState(t *testing.T) {
+	bus := NewBasicBus()
+	bus.Load(0x0000, []uint8{0xe8, 0xe8}) //INX, INX
+	cpu := NewCPU(bus, NewCPURegisters())
+	cache := NewBlockCache(cpu)
+	cache.Run(1)
+	fork, err := cpu.Fork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fork.Bus.(*BasicBus); !ok {
+		t.Fatalf("fork of a cached cpu runs on %T, want a BasicBus", fork.Bus)
+	}
+	fork.Execute()
+	if fork.Registers.X != 2 || cpu.Registers.X != 1 {
+		t.Fatalf("X is %d in the fork and %d in the original, want 2 and 1", fork.Registers.X, cpu.Registers.X)
+	}
+	if fork.Cycles() == cpu.Cycles() || fork.Instructions() != 2 {
+		t.Fatal("fork did not carry on from the cycles and instructions of the original")
+	}
+
+	_, err = NewCPU(&sharedDevice{}, NewCPURegisters()).Fork()
+	if err == nil || !strings.Contains(err.Error(), "sharedDevice") {
+		t.Fatalf("fork of a bus without Fork returned %v, want an error naming it", err)
+	}
+}
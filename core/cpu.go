@@ -1,5 +1,7 @@
 package core
 
+import "fmt"
+
 //CPURegisters - 6502  registers
 type CPURegisters struct {
 	Accumulator    uint8
@@ -23,16 +25,55 @@ func NewCPURegisters() *CPURegisters {
 	return &r
 }
 
+//Register names accepted by CPURegisters.Set
+const (
+	RegisterA  = "a"
+	RegisterX  = "x"
+	RegisterY  = "y"
+	RegisterP  = "p"
+	RegisterSP = "sp"
+	RegisterPC = "pc"
+)
+
+//Set sets the register called name to val. See the Register constants for
+//valid names. 8 bit registers are set to the low byte of val.
+func (registers *CPURegisters) Set(name string, val uint16) error {
+	switch name {
+	case RegisterA:
+		registers.Accumulator = uint8(val)
+	case RegisterX:
+		registers.X = uint8(val)
+	case RegisterY:
+		registers.Y = uint8(val)
+	case RegisterP:
+		registers.Status = uint8(val)
+	case RegisterSP:
+		registers.StackPointer = uint8(val)
+	case RegisterPC:
+		registers.ProgramCounter = val
+	default:
+		return fmt.Errorf("unknown register %q", name)
+	}
+	return nil
+}
+
 //CPU represents the state of a 65c02.
 type CPU struct {
 	Registers      *CPURegisters
-	Bus            SystemBus //system Bus
-	operand        uint8     //operand for the current instruction
-	operandAddress uint16    //address of the operand for the current instruction
-	waiting        bool      //WAI instruction flag
-	stopped        bool      //STP instruction flag
-	handlingNMI    bool      //indicates whether the cpu is currently handling an NMI
-	nmiQueue       int       //NMIs that occurred while handling other NMIs will increment this counter
+	Bus            SystemBus   //system Bus
+	operand        uint8       //operand for the current instruction
+	operandAddress uint16      //address of the operand for the current instruction
+	waiting        bool        //WAI instruction flag
+	stopped        bool        //STP instruction flag
+	handlingNMI    bool        //indicates whether the cpu is currently handling an NMI
+	nmiQueue       int         //NMIs that occurred while handling other NMIs will increment this counter
+	basic          *BasicBus   //Bus when it is a *BasicBus, nil otherwise
+	blocks         *BlockCache //block cache wrapping Bus, if any
+	instructions   uint64      //number of instructions executed so far
+	cycles         uint64      //number of clock cycles run so far
+	extraCycles    uint8       //cycles the current instruction takes on top of its base count
+	pageCrossed    bool        //an indexed address of the current instruction crossed a page
+	waits          WaitStater  //Bus when it inserts wait states, nil otherwise
 }
 
 //NewCPU returns an initialized CPU
@@ -53,39 +94,83 @@ func NewCPU(bus SystemBus, registers *CPURegisters) *CPU {
 //Execute one instruction
 func (cpu *CPU) Execute() {
 	if !cpu.stopped && !cpu.waiting {
+		cpu.syncBus()
+		waits := cpu.waitStates()
 		opcode := cpu.read(cpu.Registers.ProgramCounter)
 		cpu.Registers.ProgramCounter++
-		instr := instructionTable[opcode]
-		if instr.addressing != nil {
-			instr.addressing(cpu)
-		}
-		if instr.operation != nil {
-			instr.operation(cpu)
-		}
+		cpu.dispatch(opcode)
+		//every addressing mode sets operandAddress, but 0xAC (LDY) has none
+		//and relies on the operand being cleared between instructions
 		cpu.operand = 0x00
-		cpu.operandAddress = 0x0000
+		cpu.instructions++
+		cpu.addCycles(cpu.instructionCycles(opcode), waits)
+	}
+}
+
+//Instructions returns the number of instructions executed by the cpu
+func (cpu *CPU) Instructions() uint64 {
+	return cpu.instructions
+}
+
+//Waiting reports whether the cpu executed WAI and is waiting for an interrupt
+func (cpu *CPU) Waiting() bool {
+	return cpu.waiting
+}
+
+//Stopped reports whether the cpu executed STP and is waiting for a reset
+func (cpu *CPU) Stopped() bool {
+	return cpu.stopped
+}
+
+//Fork returns a copy of the cpu running on a fork of its bus. The bus must
+//implement Forker, and if it implements ForkChecker, every part of it must be
+//forkable too, so that the copy never shares mutable state with the original.
+//If the bus is wrapped by a BlockCache, the bus under the cache is forked and
+//the copy runs without a cache.
+func (cpu *CPU) Fork() (*CPU, error) {
+	bus := cpu.Bus
+	if cached, ok := bus.(*blockCacheBus); ok {
+		bus = cached.SystemBus
+	}
+	if err := checkFork(bus); err != nil {
+		return nil, fmt.Errorf("can not fork the bus: %w", err)
 	}
+	fork := *cpu
+	registers := *cpu.Registers
+	fork.Registers = &registers
+	fork.Bus = bus.(Forker).Fork()
+	fork.basic = nil
+	fork.blocks = nil
+	fork.waits = nil
+	return &fork, nil
 }
 
-func (cpu *CPU) interrupt(vectorLowByte uint16) {
-	pch := uint8((cpu.Registers.ProgramCounter & 0xff) >> 8)
+//interrupt pushes the program counter and the status and jumps through the
+//vector at addr
+func (cpu *CPU) interrupt(addr uint16) {
+	cpu.syncBus()
+	waits := cpu.waitStates()
+	pch := uint8(cpu.Registers.ProgramCounter >> 8)
 	pcl := uint8(cpu.Registers.ProgramCounter & 0xff)
 	cpu.pushStack(pch)
 	cpu.pushStack(pcl)
-	cpu.pushStack(cpu.Registers.Status)
-	cpu.Registers.ProgramCounter = vectorLowByte
-	cpu.abs()
-	cpu.Registers.ProgramCounter = cpu.operandAddress
+	cpu.pushStack(cpu.Registers.Status &^ BreakBit)
+	cpu.setStatusBit(InterruptDisableBit, true)
+	cpu.setStatusBit(DecimalBit, false)
+	cpu.Registers.ProgramCounter = cpu.readVector(addr)
+	cpu.addCycles(interruptCycles, waits)
 }
 
-//Interrupt sends a maskable hardware interrupt
+//Interrupt sends a maskable hardware interrupt. Like on the W65C02S, an
+//interrupt ends WAI even while interrupts are disabled, in which case the
+//cpu carries on with the next instruction.
 func (cpu *CPU) Interrupt() {
-	if !cpu.stopped && !cpu.testStatusBit(InterruptDisableBit) {
-		if cpu.waiting {
-			cpu.waiting = false
-		}
-		cpu.setStatusBit(InterruptDisableBit, true)
-		cpu.interrupt(vectorBRKL)
+	if cpu.stopped {
+		return
+	}
+	cpu.waiting = false
+	if !cpu.testStatusBit(InterruptDisableBit) {
+		cpu.interrupt(vectorIRQ)
 	}
 }
 
@@ -100,7 +185,7 @@ func (cpu *CPU) NMInterrupt() {
 		if cpu.nmiQueue > 0 {
 			cpu.nmiQueue--
 		}
-		cpu.interrupt(vectorNMIBL)
+		cpu.interrupt(vectorNMI)
 	}
 }
 
@@ -111,7 +196,8 @@ func (cpu *CPU) Reset() {
 	cpu.stopped = false
 	cpu.handlingNMI = false
 	cpu.nmiQueue = 0
-	cpu.Registers.ProgramCounter = vectorRESBL
-	cpu.abs()
-	cpu.Registers.ProgramCounter = cpu.operandAddress
+	cpu.syncBus()
+	waits := cpu.waitStates()
+	cpu.Registers.ProgramCounter = cpu.readVector(vectorRES)
+	cpu.addCycles(resetCycles, waits)
 }
@@ -0,0 +1,70 @@
+package core
+
+import "testing"
+
+//benchmarkPattern is 16 bytes of straight line code. Repeated throughout the
+//address space, it keeps the cpu busy forever without jumping, since the
+//program counter wraps around from FFFF to 0000 at an instruction boundary.
+var benchmarkPattern = []uint8{
+	0xa9, 0x01, //LDA #$01
+	0x69, 0x03, //ADC #$03
+	0xaa,             //TAX
+	0xe8,             //INX
+	0xad, 0x00, 0x02, //LDA $0200
+	0x8a,       //TXA
+	0xc9, 0x20, //CMP #$20
+	0x0a, //ASL A
+	0xea, //NOP
+	0x1a, //INC A
+	0xc8, //INY
+}
+
+const (
+	benchmarkPatternInstructions = 11 //instructions in benchmarkPattern
+	benchmarkPatternCycles       = 24 //cycles it takes to run them
+)
+
+//benchmarkImage returns 64K of memory filled with benchmarkPattern
+func benchmarkImage() []uint8 {
+	image := make([]uint8, MaxBusSize)
+	for i := 0; i < len(image); i += len(benchmarkPattern) {
+		copy(image[i:], benchmarkPattern)
+	}
+	return image
+}
+
+//reportMHz reports the emulated clock speed, the number of cpu cycles run per
+//second of wall time
+func reportMHz(b *testing.B, cycles uint64) {
+	b.ReportMetric(float64(cycles)/b.Elapsed().Seconds()/1e6, "MHz")
+}
+
+//benchmarkExecute runs benchmarkPattern once per iteration
+func benchmarkExecute(b *testing.B, bus SystemBus) {
+	cpu := NewCPU(bus, NewCPURegisters())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchmarkPatternInstructions; j++ {
+			cpu.Execute()
+		}
+	}
+	b.StopTimer()
+	reportMHz(b, uint64(b.N)*benchmarkPatternCycles)
+}
+
+func BenchmarkExecuteBasicBus(b *testing.B) {
+	bus := NewBasicBus()
+	for addr, val := range benchmarkImage() {
+		bus.Write(uint16(addr), val)
+	}
+	benchmarkExecute(b, bus)
+}
+
+func BenchmarkExecuteMappedBus(b *testing.B) {
+	ram := NewRAM(MaxBusSize)
+	ram.Load(0x0000, benchmarkImage())
+	bus := NewMappedBus()
+	bus.Map("ram", 0x0000, 0xffff, ram)
+	benchmarkExecute(b, bus)
+}
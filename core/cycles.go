@@ -0,0 +1,97 @@
+package core
+
+//WaitStater is implemented by buses that insert wait states for slow devices.
+//WaitStates returns the total number of wait states inserted since the bus
+//was created. The cpu adds the wait states inserted during an instruction to
+//its cycle count.
+type WaitStater interface {
+	WaitStates() uint64
+}
+
+//Cycles returns the number of clock cycles the cpu has run for, including
+//wait states inserted by the bus
+func (cpu *CPU) Cycles() uint64 {
+	return cpu.cycles
+}
+
+//Idle adds cycles to the cycle count without executing anything. Run loops
+//use it to skip ahead while the cpu waits in WAI or STP.
+func (cpu *CPU) Idle(cycles uint64) {
+	cpu.cycles += cycles
+}
+
+//waitStates returns the wait states inserted by the bus so far
+func (cpu *CPU) waitStates() uint64 {
+	if cpu.waits == nil {
+		return 0
+	}
+	return cpu.waits.WaitStates()
+}
+
+//addCycles adds base cycles and the wait states inserted since the bus
+//reported startWaits to the cycle count
+func (cpu *CPU) addCycles(base uint8, startWaits uint64) {
+	cpu.cycles += uint64(base)
+	if cpu.waits != nil {
+		cpu.cycles += cpu.waits.WaitStates() - startWaits
+	}
+}
+
+//instructionCycles returns the number of cycles taken by the instruction
+//that just ran, including the extra cycles of taken branches, page crossings
+//and decimal mode, and clears the extra cycles for the next instruction
+func (cpu *CPU) instructionCycles(opcode uint8) uint8 {
+	cycles := cycleTable[opcode] + cpu.extraCycles
+	if cpu.pageCrossed && pageCrossPenalty[opcode] {
+		cycles++
+	}
+	cpu.extraCycles = 0
+	cpu.pageCrossed = false
+	return cycles
+}
+
+//Cycles taken by the interrupt sequence and by the reset sequence
+const (
+	interruptCycles uint8 = 7
+	resetCycles     uint8 = 7
+)
+
+/*
+~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+Cycle table
+
+NOTES: base cycle counts of the W65C02S. On top of these, a taken branch
+takes one more cycle and another one if it lands on a different page, ADC and
+SBC take one more cycle in decimal mode, and the instructions flagged in
+pageCrossPenalty take one more cycle when an indexed address crosses a page.
+~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+*/
+var cycleTable = [256]uint8{
+	//0  1  2  3  4  5  6  7  8  9  A  B  C  D  E  F
+	7, 6, 2, 1, 5, 3, 5, 5, 3, 2, 2, 1, 6, 4, 6, 5, //0
+	2, 5, 5, 1, 5, 4, 6, 5, 2, 4, 2, 1, 6, 4, 6, 5, //1
+	6, 6, 2, 1, 3, 3, 5, 5, 4, 2, 2, 1, 4, 4, 6, 5, //2
+	2, 5, 5, 1, 4, 4, 6, 5, 2, 4, 2, 1, 4, 4, 6, 5, //3
+	6, 6, 2, 1, 3, 3, 5, 5, 3, 2, 2, 1, 3, 4, 6, 5, //4
+	2, 5, 5, 1, 4, 4, 6, 5, 2, 4, 3, 1, 8, 4, 6, 5, //5
+	6, 6, 2, 1, 3, 3, 5, 5, 4, 2, 2, 1, 6, 4, 6, 5, //6
+	2, 5, 5, 1, 4, 4, 6, 5, 2, 4, 4, 1, 6, 4, 6, 5, //7
+	3, 6, 2, 1, 3, 3, 3, 5, 2, 2, 2, 1, 4, 4, 4, 5, //8
+	2, 6, 5, 1, 4, 4, 4, 5, 2, 5, 2, 1, 4, 5, 5, 5, //9
+	2, 6, 2, 1, 3, 3, 3, 5, 2, 2, 2, 1, 4, 4, 4, 5, //A
+	2, 5, 5, 1, 4, 4, 4, 5, 2, 4, 2, 1, 4, 4, 4, 5, //B
+	2, 6, 2, 1, 3, 3, 5, 5, 2, 2, 2, 3, 4, 4, 6, 5, //C
+	2, 5, 5, 1, 4, 4, 6, 5, 2, 4, 3, 3, 4, 4, 7, 5, //D
+	2, 6, 2, 1, 3, 3, 5, 5, 2, 2, 2, 1, 4, 4, 6, 5, //E
+	2, 5, 5, 1, 4, 4, 6, 5, 2, 4, 4, 1, 4, 4, 7, 5, //F
+}
+
+//pageCrossPenalty flags the instructions that take an extra cycle when an
+//absolute indexed address crosses a page boundary: the reads and the shifts.
+//Stores and INC/DEC always take that cycle, so it is part of their base count.
+var pageCrossPenalty = [256]bool{
+	0x1d: true, 0x3d: true, 0x5d: true, 0x7d: true, 0xbd: true, 0xdd: true, 0xfd: true, //ORA AND EOR ADC LDA CMP SBC abs,X
+	0x19: true, 0x39: true, 0x59: true, 0x79: true, 0xb9: true, 0xd9: true, 0xf9: true, //ORA AND EOR ADC LDA CMP SBC abs,Y
+	0x3c: true, 0xbc: true, 0xbe: true, //BIT LDY abs,X, LDX abs,Y
+	0x1e: true, 0x3e: true, 0x5e: true, 0x7e: true, //ASL ROL LSR ROR abs,X
+}
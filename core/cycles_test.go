@@ -0,0 +1,57 @@
+package core
+
+import "testing"
+
+func TestInstructionCycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		addr   uint16
+		code   []uint8
+		x      uint8
+		status uint8
+		cycles uint64
+	}{
+		{"BNE not taken", 0x0200, []uint8{0xd0, 0x10}, 0, ZeroBit, 2},
+		{"BNE taken", 0x0200, []uint8{0xd0, 0x10}, 0, 0, 3},
+		{"BNE taken to another page", 0x02f0, []uint8{0xd0, 0x20}, 0, 0, 4},
+		{"BRA", 0x0200, []uint8{0x80, 0x10}, 0, 0, 3},
+		{"BRA to another page", 0x02f0, []uint8{0x80, 0x20}, 0, 0, 4},
+		{"BBR0 taken", 0x0200, []uint8{0x0f, 0x10, 0x10}, 0, 0, 6},
+		{"LDA abs,X", 0x0200, []uint8{0xbd, 0x00, 0x03}, 0xff, 0, 4},
+		{"LDA abs,X across pages", 0x0200, []uint8{0xbd, 0x01, 0x03}, 0xff, 0, 5},
+		{"STA abs,X across pages", 0x0200, []uint8{0x9d, 0x01, 0x03}, 0xff, 0, 5},
+		{"ASL abs,X across pages", 0x0200, []uint8{0x1e, 0x01, 0x03}, 0xff, 0, 7},
+		{"INC abs,X across pages", 0x0200, []uint8{0xfe, 0x01, 0x03}, 0xff, 0, 7},
+		{"ADC", 0x0200, []uint8{0x69, 0x01}, 0, 0, 2},
+		{"ADC in decimal mode", 0x0200, []uint8{0x69, 0x01}, 0, DecimalBit, 3},
+		{"SBC in decimal mode", 0x0200, []uint8{0xe9, 0x01}, 0, DecimalBit, 3},
+	}
+	for _, test := range tests {
+		bus := NewBasicBus()
+		bus.Load(test.addr, test.code)
+		registers := NewCPURegisters()
+		registers.ProgramCounter = test.addr
+		registers.X = test.x
+		registers.Status |= test.status
+		cpu := NewCPU(bus, registers)
+		cpu.Execute()
+		if cpu.Cycles() != test.cycles {
+			t.Errorf("%s: %d cycles, want %d", test.name, cpu.Cycles(), test.cycles)
+		}
+	}
+}
+
+func TestWaitStatesAreCounted(t *testing.T) {
+	ram, slow := NewRAM(0x8000), NewRAM(0x8000)
+	ram.Load(0x0200, []uint8{0xad, 0x00, 0x80}) //LDA $8000
+	bus := NewMappedBus()
+	bus.Map("ram", 0x0000, 0x7fff, ram)
+	bus.MapRegion(Region{Name: "slow", Start: 0x8000, End: 0xffff, Device: slow, WaitStates: 2})
+	registers := NewCPURegisters()
+	registers.ProgramCounter = 0x0200
+	cpu := NewCPU(bus, registers)
+	cpu.Execute()
+	if cpu.Cycles() != 4+2 {
+		t.Fatalf("%d cycles, want 6", cpu.Cycles())
+	}
+}
@@ -0,0 +1,603 @@
+package core
+
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+Instruction dispatch
+
+NOTES: this switch was generated automatically. Each case corresponds to one
+CPU opcode and calls the addressing mode handler followed by the instruction
+handler directly, so that the compiler can build a jump table and inline the
+small handlers. All illegal opcodes fall through to the default case and are
+executed as NOP as per WDC specifications.
+~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+
+func (cpu *CPU) dispatch(opcode uint8) {
+	switch opcode {
+	case 0x00:
+		cpu.brk()
+	case 0x01:
+		cpu.zpii()
+		cpu.ora()
+	case 0x04:
+		cpu.zp()
+		cpu.tsb()
+	case 0x05:
+		cpu.zp()
+		cpu.ora()
+	case 0x06:
+		cpu.zp()
+		cpu.asl()
+	case 0x07:
+		cpu.zp()
+		cpu.rmb0()
+	case 0x08:
+		cpu.php()
+	case 0x09:
+		cpu.imm()
+		cpu.ora()
+	case 0x0A:
+		cpu.asla()
+	case 0x0C:
+		cpu.abs()
+		cpu.tsb()
+	case 0x0D:
+		cpu.abs()
+		cpu.ora()
+	case 0x0E:
+		cpu.abs()
+		cpu.asl()
+	case 0x0F:
+		cpu.zp()
+		cpu.bbr0()
+	case 0x10:
+		cpu.bpl()
+	case 0x11:
+		cpu.zpiy()
+		cpu.ora()
+	case 0x12:
+		cpu.zpi()
+		cpu.ora()
+	case 0x14:
+		cpu.zp()
+		cpu.trb()
+	case 0x15:
+		cpu.zpx()
+		cpu.ora()
+	case 0x16:
+		cpu.zpx()
+		cpu.asl()
+	case 0x17:
+		cpu.zp()
+		cpu.rmb1()
+	case 0x18:
+		cpu.clc()
+	case 0x19:
+		cpu.aiy()
+		cpu.ora()
+	case 0x1A:
+		cpu.inca()
+	case 0x1C:
+		cpu.abs()
+		cpu.trb()
+	case 0x1D:
+		cpu.aix()
+		cpu.ora()
+	case 0x1E:
+		cpu.aix()
+		cpu.asl()
+	case 0x1F:
+		cpu.zp()
+		cpu.bbr1()
+	case 0x20:
+		cpu.abs()
+		cpu.jsr()
+	case 0x21:
+		cpu.zpii()
+		cpu.and()
+	case 0x24:
+		cpu.zp()
+		cpu.bit()
+	case 0x25:
+		cpu.zp()
+		cpu.and()
+	case 0x26:
+		cpu.zp()
+		cpu.rol()
+	case 0x27:
+		cpu.zp()
+		cpu.rmb2()
+	case 0x28:
+		cpu.plp()
+	case 0x29:
+		cpu.imm()
+		cpu.and()
+	case 0x2A:
+		cpu.rola()
+	case 0x2C:
+		cpu.abs()
+		cpu.bit()
+	case 0x2D:
+		cpu.abs()
+		cpu.and()
+	case 0x2E:
+		cpu.abs()
+		cpu.rol()
+	case 0x2F:
+		cpu.zp()
+		cpu.bbr2()
+	case 0x30:
+		cpu.bmi()
+	case 0x31:
+		cpu.zpiy()
+		cpu.and()
+	case 0x32:
+		cpu.zp()
+		cpu.and()
+	case 0x34:
+		cpu.zpx()
+		cpu.bit()
+	case 0x35:
+		cpu.zpx()
+		cpu.and()
+	case 0x36:
+		cpu.zpx()
+		cpu.rol()
+	case 0x37:
+		cpu.zp()
+		cpu.rmb3()
+	case 0x38:
+		cpu.sec()
+	case 0x39:
+		cpu.aiy()
+		cpu.and()
+	case 0x3A:
+		cpu.deca()
+	case 0x3C:
+		cpu.aix()
+		cpu.bit()
+	case 0x3D:
+		cpu.aix()
+		cpu.and()
+	case 0x3E:
+		cpu.aix()
+		cpu.rol()
+	case 0x3F:
+		cpu.zp()
+		cpu.bbr3()
+	case 0x40:
+		cpu.rti()
+	case 0x41:
+		cpu.zpii()
+		cpu.eor()
+	case 0x45:
+		cpu.zp()
+		cpu.eor()
+	case 0x46:
+		cpu.zp()
+		cpu.lsr()
+	case 0x47:
+		cpu.zp()
+		cpu.rmb4()
+	case 0x48:
+		cpu.pha()
+	case 0x49:
+		cpu.imm()
+		cpu.eor()
+	case 0x4A:
+		cpu.lsra()
+	case 0x4C:
+		cpu.abs()
+		cpu.jmp()
+	case 0x4D:
+		cpu.abs()
+		cpu.eor()
+	case 0x4E:
+		cpu.abs()
+		cpu.lsr()
+	case 0x4F:
+		cpu.abs()
+		cpu.bbr4()
+	case 0x50:
+		cpu.bvc()
+	case 0x51:
+		cpu.zpiy()
+		cpu.eor()
+	case 0x52:
+		cpu.zpi()
+		cpu.eor()
+	case 0x55:
+		cpu.zpx()
+		cpu.eor()
+	case 0x56:
+		cpu.zpx()
+		cpu.lsr()
+	case 0x57:
+		cpu.zp()
+		cpu.rmb5()
+	case 0x58:
+		cpu.cli()
+	case 0x59:
+		cpu.aiy()
+		cpu.eor()
+	case 0x5A:
+		cpu.phy()
+	case 0x5D:
+		cpu.aix()
+		cpu.eor()
+	case 0x5E:
+		cpu.aix()
+		cpu.lsr()
+	case 0x5F:
+		cpu.zp()
+		cpu.bbr5()
+	case 0x60:
+		cpu.rts()
+	case 0x61:
+		cpu.zpii()
+		cpu.adc()
+	case 0x64:
+		cpu.zp()
+		cpu.stz()
+	case 0x65:
+		cpu.zp()
+		cpu.adc()
+	case 0x66:
+		cpu.zp()
+		cpu.ror()
+	case 0x67:
+		cpu.zp()
+		cpu.rmb6()
+	case 0x68:
+		cpu.pla()
+	case 0x69:
+		cpu.imm()
+		cpu.adc()
+	case 0x6A:
+		cpu.rora()
+	case 0x6C:
+		cpu.ai()
+	case 0x6D:
+		cpu.abs()
+		cpu.adc()
+	case 0x6E:
+		cpu.abs()
+		cpu.ror()
+	case 0x6F:
+		cpu.zp()
+		cpu.bbr6()
+	case 0x70:
+		cpu.bvs()
+	case 0x71:
+		cpu.zpiy()
+		cpu.adc()
+	case 0x72:
+		cpu.zpi()
+		cpu.adc()
+	case 0x74:
+		cpu.zpx()
+		cpu.stz()
+	case 0x75:
+		cpu.zpx()
+		cpu.adc()
+	case 0x76:
+		cpu.zpx()
+		cpu.ror()
+	case 0x77:
+		cpu.zp()
+		cpu.rmb7()
+	case 0x78:
+		cpu.sei()
+	case 0x79:
+		cpu.aiy()
+		cpu.adc()
+	case 0x7A:
+		cpu.ply()
+	case 0x7C:
+		cpu.aii()
+		cpu.jmp()
+	case 0x7D:
+		cpu.aix()
+		cpu.adc()
+	case 0x7E:
+		cpu.aix()
+		cpu.ror()
+	case 0x7F:
+		cpu.zp()
+		cpu.bbr7()
+	case 0x80:
+		cpu.pcr()
+	case 0x81:
+		cpu.zpii()
+		cpu.sta()
+	case 0x84:
+		cpu.zp()
+		cpu.sty()
+	case 0x85:
+		cpu.zp()
+		cpu.sta()
+	case 0x86:
+		cpu.zp()
+		cpu.stx()
+	case 0x87:
+		cpu.zp()
+		cpu.smb0()
+	case 0x88:
+		cpu.dey()
+	case 0x89:
+		cpu.imm()
+		cpu.bit()
+	case 0x8A:
+		cpu.txa()
+	case 0x8C:
+		cpu.abs()
+		cpu.sty()
+	case 0x8D:
+		cpu.abs()
+		cpu.sta()
+	case 0x8E:
+		cpu.abs()
+		cpu.stx()
+	case 0x8F:
+		cpu.zp()
+		cpu.bbs0()
+	case 0x90:
+		cpu.bcc()
+	case 0x91:
+		cpu.zpiy()
+		cpu.sta()
+	case 0x92:
+		cpu.zpi()
+		cpu.sta()
+	case 0x94:
+		cpu.zpx()
+		cpu.sty()
+	case 0x95:
+		cpu.zpx()
+		cpu.sta()
+	case 0x96:
+		cpu.zpy()
+		cpu.stx()
+	case 0x97:
+		cpu.zp()
+		cpu.smb1()
+	case 0x98:
+		cpu.tya()
+	case 0x99:
+		cpu.aiy()
+		cpu.sta()
+	case 0x9A:
+		cpu.txs()
+	case 0x9C:
+		cpu.abs()
+		cpu.stz()
+	case 0x9D:
+		cpu.aix()
+		cpu.sta()
+	case 0x9E:
+		cpu.aix()
+		cpu.stz()
+	case 0x9F:
+		cpu.zp()
+		cpu.bbs1()
+	case 0xA0:
+		cpu.imm()
+		cpu.ldy()
+	case 0xA1:
+		cpu.zpii()
+		cpu.lda()
+	case 0xA2:
+		cpu.imm()
+		cpu.ldx()
+	case 0xA4:
+		cpu.zp()
+		cpu.ldy()
+	case 0xA5:
+		cpu.zp()
+		cpu.lda()
+	case 0xA6:
+		cpu.zp()
+		cpu.ldx()
+	case 0xA7:
+		cpu.zp()
+		cpu.smb2()
+	case 0xA8:
+		cpu.tay()
+	case 0xA9:
+		cpu.imm()
+		cpu.lda()
+	case 0xAA:
+		cpu.tax()
+	case 0xAC:
+		cpu.ldy()
+	case 0xAD:
+		cpu.abs()
+		cpu.lda()
+	case 0xAE:
+		cpu.abs()
+		cpu.ldx()
+	case 0xAF:
+		cpu.zp()
+		cpu.bbs2()
+	case 0xB0:
+		cpu.bcs()
+	case 0xB1:
+		cpu.zpiy()
+		cpu.lda()
+	case 0xB2:
+		cpu.zpi()
+		cpu.lda()
+	case 0xB4:
+		cpu.zpx()
+		cpu.ldy()
+	case 0xB5:
+		cpu.zpx()
+		cpu.lda()
+	case 0xB6:
+		cpu.zpy()
+		cpu.ldx()
+	case 0xB7:
+		cpu.zp()
+		cpu.smb3()
+	case 0xB8:
+		cpu.clv()
+	case 0xB9:
+		cpu.aiy()
+		cpu.lda()
+	case 0xBA:
+		cpu.tsx()
+	case 0xBC:
+		cpu.aix()
+		cpu.ldy()
+	case 0xBD:
+		cpu.aix()
+		cpu.lda()
+	case 0xBE:
+		cpu.aiy()
+		cpu.ldx()
+	case 0xBF:
+		cpu.zp()
+		cpu.bbs3()
+	case 0xC0:
+		cpu.imm()
+		cpu.cpy()
+	case 0xC1:
+		cpu.zpii()
+		cpu.cmp()
+	case 0xC4:
+		cpu.zp()
+		cpu.cpy()
+	case 0xC5:
+		cpu.zp()
+		cpu.cmp()
+	case 0xC6:
+		cpu.zp()
+		cpu.dec()
+	case 0xC7:
+		cpu.zp()
+		cpu.smb4()
+	case 0xC8:
+		cpu.iny()
+	case 0xC9:
+		cpu.imm()
+		cpu.cmp()
+	case 0xCA:
+		cpu.dex()
+	case 0xCB:
+		cpu.wai()
+	case 0xCC:
+		cpu.abs()
+		cpu.cpy()
+	case 0xCD:
+		cpu.abs()
+		cpu.cmp()
+	case 0xCE:
+		cpu.abs()
+		cpu.dec()
+	case 0xCF:
+		cpu.zp()
+		cpu.bbs4()
+	case 0xD0:
+		cpu.bne()
+	case 0xD1:
+		cpu.zpiy()
+		cpu.cmp()
+	case 0xD2:
+		cpu.zpi()
+		cpu.cmp()
+	case 0xD5:
+		cpu.zpx()
+		cpu.cmp()
+	case 0xD6:
+		cpu.zpx()
+		cpu.dec()
+	case 0xD7:
+		cpu.zp()
+		cpu.smb5()
+	case 0xD8:
+		cpu.cld()
+	case 0xD9:
+		cpu.aiy()
+		cpu.cmp()
+	case 0xDA:
+		cpu.phx()
+	case 0xDB:
+		cpu.stp()
+	case 0xDD:
+		cpu.aix()
+		cpu.cmp()
+	case 0xDE:
+		cpu.aix()
+		cpu.dec()
+	case 0xDF:
+		cpu.zp()
+		cpu.bbs5()
+	case 0xE0:
+		cpu.imm()
+		cpu.cpx()
+	case 0xE1:
+		cpu.zpii()
+		cpu.sbc()
+	case 0xE4:
+		cpu.zp()
+		cpu.cpx()
+	case 0xE5:
+		cpu.zp()
+		cpu.sbc()
+	case 0xE6:
+		cpu.zp()
+		cpu.inc()
+	case 0xE7:
+		cpu.zp()
+		cpu.smb6()
+	case 0xE8:
+		cpu.inx()
+	case 0xE9:
+		cpu.imm()
+		cpu.sbc()
+	case 0xEC:
+		cpu.abs()
+		cpu.cpx()
+	case 0xED:
+		cpu.abs()
+		cpu.sbc()
+	case 0xEE:
+		cpu.abs()
+		cpu.inc()
+	case 0xEF:
+		cpu.zp()
+		cpu.bbs6()
+	case 0xF0:
+		cpu.beq()
+	case 0xF1:
+		cpu.zpiy()
+		cpu.sbc()
+	case 0xF2:
+		cpu.zpi()
+		cpu.sbc()
+	case 0xF5:
+		cpu.zpx()
+		cpu.sbc()
+	case 0xF6:
+		cpu.zpx()
+		cpu.inc()
+	case 0xF7:
+		cpu.zp()
+		cpu.smb7()
+	case 0xF8:
+		cpu.sed()
+	case 0xF9:
+		cpu.aiy()
+		cpu.sbc()
+	case 0xFA:
+		cpu.plx()
+	case 0xFD:
+		cpu.aix()
+		cpu.sbc()
+	case 0xFE:
+		cpu.aix()
+		cpu.inc()
+	case 0xFF:
+		cpu.zp()
+		cpu.bbs7()
+	default:
+	}
+}
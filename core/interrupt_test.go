@@ -0,0 +1,84 @@
+package core
+
+import "testing"
+
+//newInterruptCPU returns a cpu about to run at 1234 with the carry and
+//decimal flags set, and with the NMI, reset and IRQ vectors pointing to 3000,
+//4000 and 5000
+func newInterruptCPU() *CPU {
+	bus := NewBasicBus()
+	vectors := []uint8{0x00, 0x30, 0x00, 0x40, 0x00, 0x50}
+	for i, val := range vectors {
+		bus.Write(vectorNMI+uint16(i), val)
+	}
+	registers := NewCPURegisters()
+	registers.ProgramCounter = 0x1234
+	registers.Status |= CarryBit | DecimalBit
+	return NewCPU(bus, registers)
+}
+
+func TestInterruptSequence(t *testing.T) {
+	tests := []struct {
+		name      string
+		interrupt func(cpu *CPU)
+		vector    uint16
+		pc        uint16 //pushed return address
+		status    uint8  //pushed status
+	}{
+		{"IRQ", (*CPU).Interrupt, 0x5000, 0x1234, UnusedBit | CarryBit | DecimalBit},
+		{"NMI", (*CPU).NMInterrupt, 0x3000, 0x1234, UnusedBit | CarryBit | DecimalBit},
+		{"BRK", func(cpu *CPU) {
+			cpu.Bus.Write(0x1234, 0x00) //BRK
+			cpu.Execute()
+		}, 0x5000, 0x1236, UnusedBit | CarryBit | DecimalBit | BreakBit},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cpu := newInterruptCPU()
+			test.interrupt(cpu)
+			if pc := cpu.Registers.ProgramCounter; pc != test.vector {
+				t.Fatalf("cpu jumped to %#04x, want %#04x", pc, test.vector)
+			}
+			if sp := cpu.Registers.StackPointer; sp != 0xfa {
+				t.Fatalf("stack pointer is %#02x, want 0xfa", sp)
+			}
+			stack := []uint8{cpu.Bus.Read(0x01fd), cpu.Bus.Read(0x01fc), cpu.Bus.Read(0x01fb)}
+			want := []uint8{uint8(test.pc >> 8), uint8(test.pc), test.status}
+			for i := range want {
+				if stack[i] != want[i] {
+					t.Fatalf("pushed % x, want % x", stack, want)
+				}
+			}
+			if status := cpu.Registers.Status; status != UnusedBit|CarryBit|InterruptDisableBit {
+				t.Fatalf("status is %#02x, want I set, D and B clear and C kept", status)
+			}
+			//RTI returns to the pushed address with the pushed status
+			cpu.Bus.Write(test.vector, 0x40)
+			cpu.Execute()
+			if cpu.Registers.ProgramCounter != test.pc || cpu.Registers.Status != test.status {
+				t.Fatalf("RTI returned to %#04x with status %#02x", cpu.Registers.ProgramCounter, cpu.Registers.Status)
+			}
+		})
+	}
+}
+
+func TestInterruptDisable(t *testing.T) {
+	cpu := newInterruptCPU()
+	cpu.Registers.Status |= InterruptDisableBit
+	cpu.Interrupt()
+	if cpu.Registers.ProgramCounter != 0x1234 || cpu.Registers.StackPointer != 0xfd {
+		t.Fatal("cpu took an IRQ with interrupts disabled")
+	}
+	cpu.NMInterrupt()
+	if cpu.Registers.ProgramCounter != 0x3000 {
+		t.Fatal("cpu did not take an NMI with interrupts disabled")
+	}
+}
+
+func TestResetVector(t *testing.T) {
+	cpu := newInterruptCPU()
+	cpu.Reset()
+	if cpu.Registers.ProgramCounter != 0x4000 {
+		t.Fatalf("cpu reset to %#04x, want 0x4000", cpu.Registers.ProgramCounter)
+	}
+}
@@ -0,0 +1,196 @@
+package core
+
+import "fmt"
+
+//Region is a range of addresses decoded to a device. Any SystemBus can be a
+//device. The device sees addresses as offsets from Start, masked with Mask.
+//A Mask smaller than the size of the region makes the device repeat
+//throughout the region, which is how boards with partial address decoding
+//mirror memory and I/O chips. A zero Mask is the same as 0xffff.
+type Region struct {
+	Name   string
+	Start  uint16
+	End    uint16 //last address of the region, inclusive
+	Mask   uint16
+	Device SystemBus
+	//WaitStates is the number of cycles every access to the region is
+	//stretched by
+	WaitStates int
+
+	size int //size of the device if it has one, 0 otherwise
+}
+
+//sizer is implemented by devices of a fixed size, like RAM and ROM
+type sizer interface {
+	Size() int
+}
+
+//contains reports whether addr falls within the region
+func (r *Region) contains(addr uint16) bool {
+	return addr >= r.Start && addr <= r.End
+}
+
+//offset translates a bus address into a device address
+func (r *Region) offset(addr uint16) uint16 {
+	return (addr - r.Start) & r.Mask
+}
+
+//MappedBus is a SystemBus made of devices attached to address ranges.
+//Regions mapped later take priority over the ones they overlap, so I/O can be
+//mapped on top of RAM. Reads from unmapped addresses return the open bus
+//value and writes to them are ignored. Reads past the end of a device with a
+//Size method, like RAM mapped to a larger region, return the open bus value
+//too.
+type MappedBus struct {
+	regions []*Region
+	pages   [MaxBusSize / pageSize][]*Region //regions overlapping each page, highest priority first
+	//OpenBus is the value read from unmapped addresses
+	OpenBus uint8
+	//FloatingBus makes unmapped reads return the last value that was on the
+	//data bus instead of OpenBus, like on most NMOS era boards
+	FloatingBus bool
+	last        uint8          //last value on the data bus
+	waits       uint64         //wait states inserted so far
+	switchers   []BankSwitcher //mapped devices that switch banks
+}
+
+func NewMappedBus() *MappedBus {
+	return &MappedBus{OpenBus: openBus}
+}
+
+//Map attaches device to addresses start through end. The device sees offsets
+//from start.
+func (bus *MappedBus) Map(name string, start, end uint16, device SystemBus) error {
+	return bus.MapRegion(Region{Name: name, Start: start, End: end, Mask: 0xffff, Device: device})
+}
+
+//MapMirrored attaches device to addresses start through end and repeats it
+//every size bytes. Size must be a power of two.
+func (bus *MappedBus) MapMirrored(name string, start, end uint16, size int, device SystemBus) error {
+	if size < 2 || size > MaxBusSize || size&(size-1) != 0 {
+		return fmt.Errorf("mirror size %d of region %q is not a power of two", size, name)
+	}
+	return bus.MapRegion(Region{Name: name, Start: start, End: end, Mask: uint16(size - 1), Device: device})
+}
+
+//MapRegion attaches a region to the bus
+func (bus *MappedBus) MapRegion(region Region) error {
+	if region.Start > region.End {
+		return fmt.Errorf("region %q starts at %#04x after it ends at %#04x", region.Name, region.Start, region.End)
+	}
+	if region.Device == nil {
+		return fmt.Errorf("region %q has no device", region.Name)
+	}
+	if region.WaitStates < 0 {
+		return fmt.Errorf("region %q has %d wait states", region.Name, region.WaitStates)
+	}
+	if region.Mask == 0 {
+		region.Mask = 0xffff
+	}
+	region.size = 0
+	if sized, ok := region.Device.(sizer); ok {
+		region.size = sized.Size()
+	}
+	r := &region
+	bus.regions = append(bus.regions, r)
+	if switcher, ok := r.Device.(BankSwitcher); ok {
+		bus.switchers = append(bus.switchers, switcher)
+	}
+	for page := int(r.Start >> 8); page <= int(r.End>>8); page++ {
+		bus.pages[page] = append([]*Region{r}, bus.pages[page]...)
+	}
+	return nil
+}
+
+//Regions returns the regions attached to the bus in the order they were
+//mapped
+func (bus *MappedBus) Regions() []Region {
+	regions := make([]Region, len(bus.regions))
+	for i, r := range bus.regions {
+		regions[i] = *r
+	}
+	return regions
+}
+
+//Lookup returns the region addr is decoded to, or nil if it is unmapped
+func (bus *MappedBus) Lookup(addr uint16) *Region {
+	for _, r := range bus.pages[addr>>8] {
+		if r.contains(addr) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (bus *MappedBus) Read(addr uint16) uint8 {
+	r := bus.Lookup(addr)
+	if r == nil {
+		return bus.open()
+	}
+	bus.waits += uint64(r.WaitStates)
+	offset := r.offset(addr)
+	if r.size != 0 && int(offset) >= r.size {
+		return bus.open()
+	}
+	bus.last = r.Device.Read(offset)
+	return bus.last
+}
+
+//open returns the value read when nothing drives the data bus
+func (bus *MappedBus) open() uint8 {
+	if bus.FloatingBus {
+		return bus.last
+	}
+	return bus.OpenBus
+}
+
+func (bus *MappedBus) Write(addr uint16, val uint8) error {
+	bus.last = val
+	r := bus.Lookup(addr)
+	if r == nil {
+		return nil
+	}
+	bus.waits += uint64(r.WaitStates)
+	err := r.Device.Write(r.offset(addr), val)
+	if err != nil {
+		return fmt.Errorf("write to %#04x (%s): %w", addr, r.Name, err)
+	}
+	return nil
+}
+
+//WaitStates returns the number of wait states inserted by slow regions so far
+func (bus *MappedBus) WaitStates() uint64 {
+	return bus.waits
+}
+
+//Fork returns a copy of the bus. Devices that implement Forker are forked,
+//the rest are shared between both buses, so that accesses to them through
+//either bus are seen by both. A device mapped to several regions is forked
+//once, and bank controllers in the fork drive the forked banks. CPU.Fork
+//refuses to fork a bus that would share devices, see CheckFork.
+func (bus *MappedBus) Fork() SystemBus {
+	forkedBus := &MappedBus{
+		OpenBus:     bus.OpenBus,
+		FloatingBus: bus.FloatingBus,
+		last:        bus.last,
+		waits:       bus.waits,
+	}
+	fork := newForkSet()
+	for _, r := range bus.regions {
+		region := *r
+		region.Device = fork(r.Device)
+		forkedBus.MapRegion(region)
+	}
+	return forkedBus
+}
+
+//CheckFork returns an error naming the first mapped device that can not be
+//forked and would be shared between the bus and its forks
+func (bus *MappedBus) CheckFork() error {
+	for _, r := range bus.regions {
+		if err := checkFork(r.Device); err != nil {
+			return fmt.Errorf("region %q: %w", r.Name, err)
+		}
+	}
+	return nil
+}
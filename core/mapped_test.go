@@ -0,0 +1,123 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMappedBusMasks(t *testing.T) {
+	bus := NewMappedBus()
+	ram := NewRAM(0x100)
+	err := bus.MapMirrored("ram", 0x1000, 0x1fff, 0x100, ram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom := NewROM([]uint8{0x11, 0x22}, ROMWriteError)
+	err = bus.Map("rom", 0x2000, 0x2001, rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Write(0x1042, 0xaa)
+	for _, addr := range []uint16{0x1042, 0x1142, 0x1f42} {
+		if val := bus.Read(addr); val != 0xaa {
+			t.Errorf("mirror %#04x reads %#02x, want 0xaa", addr, val)
+		}
+	}
+	if ram.Read(0x42) != 0xaa {
+		t.Error("device does not see the offset from the start of its region")
+	}
+	if bus.Read(0x2001) != 0x22 {
+		t.Error("region without a mirror does not see offsets from its start")
+	}
+	for _, size := range []int{0, 1, 0x300, MaxBusSize + 1} {
+		if err := bus.MapMirrored("bad", 0x3000, 0x3fff, size, ram); err == nil {
+			t.Errorf("mapped a mirror of %#x bytes", size)
+		}
+	}
+	if err := bus.Map("backwards", 0x4000, 0x3fff, ram); err == nil {
+		t.Error("mapped a region that ends before it starts")
+	}
+	if err := bus.MapRegion(Region{Name: "slow", Start: 0x4000, End: 0x4fff, Device: ram, WaitStates: -1}); err == nil {
+		t.Error("mapped a region with negative wait states")
+	}
+	if err := bus.MapRegion(Region{Name: "zero", Start: 0x5000, End: 0x50ff, Device: ram}); err != nil {
+		t.Fatal(err)
+	}
+	if r := bus.Lookup(0x5000); r.Mask != 0xffff {
+		t.Errorf("zero mask became %#04x, want 0xffff", r.Mask)
+	}
+}
+
+func TestMappedBusOverlap(t *testing.T) {
+	bus := NewMappedBus()
+	ram := NewRAM(MaxBusSize)
+	io := NewRAM(0x10)
+	bus.Map("ram", 0x0000, 0xffff, ram)
+	bus.Map("io", 0x8000, 0x800f, io)
+	tests := []struct {
+		addr   uint16
+		region string
+	}{
+		{0x7fff, "ram"},
+		{0x8000, "io"},
+		{0x800f, "io"},
+		{0x8010, "ram"},
+	}
+	for _, test := range tests {
+		if r := bus.Lookup(test.addr); r == nil || r.Name != test.region {
+			t.Errorf("%#04x is decoded to %v, want %s", test.addr, r, test.region)
+		}
+	}
+	bus.Write(0x8001, 0x42)
+	if io.Read(0x01) != 0x42 || ram.Read(0x8001) != 0x00 {
+		t.Fatal("write went to the region mapped first")
+	}
+}
+
+func TestMappedBusROMWrites(t *testing.T) {
+	bus := NewMappedBus()
+	bus.Map("error", 0x0000, 0x00ff, NewROM(make([]uint8, 0x100), ROMWriteError))
+	bus.Map("ignore", 0x0100, 0x01ff, NewROM(make([]uint8, 0x100), ROMWriteIgnore))
+	if err := bus.Write(0x0010, 0x42); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("write to ROMWriteError returned %v, want ErrReadOnly", err)
+	}
+	if err := bus.Write(0x0110, 0x42); err != nil {
+		t.Errorf("write to ROMWriteIgnore returned %v", err)
+	}
+	if bus.Read(0x0010) != 0 || bus.Read(0x0110) != 0 {
+		t.Error("write changed a ROM")
+	}
+}
+
+func TestMappedBusOpenBus(t *testing.T) {
+	tests := []struct {
+		name     string
+		floating bool
+		want     uint8
+	}{
+		{"open bus", false, 0x5a},
+		{"floating bus", true, 0x42},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bus := NewMappedBus()
+			bus.OpenBus = 0x5a
+			bus.FloatingBus = test.floating
+			//RAM smaller than its region
+			bus.Map("ram", 0x0000, 0x0fff, NewRAM(0x100))
+			bus.Write(0x0010, 0x42)
+			bus.Read(0x0010)
+			for _, addr := range []uint16{0x8000, 0x0100, 0x0fff} {
+				if val := bus.Read(addr); val != test.want {
+					t.Errorf("%#04x reads %#02x, want %#02x", addr, val, test.want)
+				}
+			}
+			if err := bus.Write(0x8000, 0x42); err != nil {
+				t.Errorf("write to an unmapped address returned %v", err)
+			}
+		})
+	}
+	if NewMappedBus().Read(0x0000) != 0xff {
+		t.Error("unmapped addresses of a new bus do not read 0xff")
+	}
+}
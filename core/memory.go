@@ -0,0 +1,149 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+//ErrReadOnly is returned when writing to read-only memory
+var ErrReadOnly = errors.New("memory is read only")
+
+//openBus is the value read from addresses nothing responds to, see
+//MappedBus.OpenBus
+const openBus uint8 = 0xff
+
+//RAM is a block of read/write memory that can be attached to a MappedBus.
+//Addresses are offsets from the start of the RAM. Like BasicBus memory, it is
+//split into pages that are shared with forks and copied on the first write.
+type RAM struct {
+	pages []*memoryPage
+	owned []bool
+	size  int
+}
+
+//NewRAM returns size bytes of zeroed RAM
+func NewRAM(size int) *RAM {
+	n := (size + pageSize - 1) / pageSize
+	ram := &RAM{
+		pages: make([]*memoryPage, n),
+		owned: make([]bool, n),
+		size:  size,
+	}
+	for i := range ram.pages {
+		ram.pages[i] = zeroPage
+	}
+	return ram
+}
+
+//Size returns the size of the RAM in bytes
+func (ram *RAM) Size() int {
+	return ram.size
+}
+
+//Read returns the byte at addr, or the open bus value if addr is past the end
+//of the RAM
+func (ram *RAM) Read(addr uint16) uint8 {
+	if int(addr) >= ram.size {
+		return openBus
+	}
+	return ram.pages[addr>>8][addr&0xff]
+}
+
+func (ram *RAM) Write(addr uint16, val uint8) error {
+	if int(addr) >= ram.size {
+		return fmt.Errorf("address %#04x is outside of %d bytes of ram", addr, ram.size)
+	}
+	ram.writablePage(addr >> 8)[addr&0xff] = val
+	return nil
+}
+
+//writablePage returns page n, copying it first if it is shared
+func (ram *RAM) writablePage(n uint16) *memoryPage {
+	if !ram.owned[n] {
+		page := *ram.pages[n]
+		ram.pages[n] = &page
+		ram.owned[n] = true
+	}
+	return ram.pages[n]
+}
+
+//Load copies data to the RAM starting at addr. Data that does not fit is
+//ignored.
+func (ram *RAM) Load(addr uint16, data []uint8) {
+	for _, val := range data {
+		if int(addr) >= ram.size {
+			return
+		}
+		ram.writablePage(addr >> 8)[addr&0xff] = val
+		addr++
+	}
+}
+
+//Fork returns a copy of the RAM that shares all pages with the original until
+//one of them writes to a page.
+func (ram *RAM) Fork() SystemBus {
+	fork := &RAM{
+		pages: append([]*memoryPage(nil), ram.pages...),
+		owned: make([]bool, len(ram.owned)),
+		size:  ram.size,
+	}
+	for i := range ram.owned {
+		ram.owned[i] = false
+	}
+	return fork
+}
+
+//ROMWritePolicy decides what happens when the cpu writes to a ROM
+type ROMWritePolicy int
+
+const (
+	//ROMWriteError -> writes return ErrReadOnly
+	ROMWriteError ROMWritePolicy = iota
+	//ROMWriteIgnore -> writes are silently dropped, like on most real boards
+	ROMWriteIgnore
+)
+
+//ROM is a block of read-only memory that can be attached to a MappedBus.
+//Addresses are offsets from the start of the ROM.
+type ROM struct {
+	data   []uint8
+	Policy ROMWritePolicy
+}
+
+//NewROM returns a ROM holding a copy of data
+func NewROM(data []uint8, policy ROMWritePolicy) *ROM {
+	return &ROM{
+		data:   append([]uint8(nil), data...),
+		Policy: policy,
+	}
+}
+
+//Size returns the size of the ROM in bytes
+func (rom *ROM) Size() int {
+	return len(rom.data)
+}
+
+//Read returns the byte at addr, or the open bus value if addr is past the end
+//of the ROM
+func (rom *ROM) Read(addr uint16) uint8 {
+	if int(addr) >= len(rom.data) {
+		return openBus
+	}
+	return rom.data[addr]
+}
+
+func (rom *ROM) Write(addr uint16, val uint8) error {
+	if rom.Policy == ROMWriteIgnore {
+		return nil
+	}
+	return ErrReadOnly
+}
+
+//Fork returns a copy of the ROM. The contents never change and are shared,
+//but the write policy of each copy can be changed on its own.
+func (rom *ROM) Fork() SystemBus {
+	return &ROM{
+		data:   rom.data,
+		Policy: rom.Policy,
+	}
+}
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRAMBounds(t *testing.T) {
+	ram := NewRAM(0x180)
+	if ram.Size() != 0x180 {
+		t.Fatalf("RAM has %#x bytes, want 0x180", ram.Size())
+	}
+	if err := ram.Write(0x017f, 0x42); err != nil || ram.Read(0x017f) != 0x42 {
+		t.Fatalf("last byte of RAM is not writable: %v", err)
+	}
+	if err := ram.Write(0x0180, 0x42); err == nil {
+		t.Fatal("write past the end of RAM succeeded")
+	}
+	if val := ram.Read(0x0180); val != openBus {
+		t.Fatalf("read past the end of RAM returned %#02x, want the open bus value", val)
+	}
+	ram.Load(0x017e, []uint8{1, 2, 3})
+	if ram.Read(0x017e) != 1 || ram.Read(0x017f) != 2 {
+		t.Fatal("load did not fill the end of RAM")
+	}
+}
+
+func TestROM(t *testing.T) {
+	image := []uint8{0x11, 0x22}
+	rom := NewROM(image, ROMWriteError)
+	image[0] = 0
+	if rom.Read(0x0000) != 0x11 {
+		t.Fatal("ROM shares its image")
+	}
+	if val := rom.Read(0x0002); val != openBus {
+		t.Fatalf("read past the end of ROM returned %#02x, want the open bus value", val)
+	}
+	fork := rom.Fork().(*ROM)
+	fork.Policy = ROMWriteIgnore
+	if err := rom.Write(0x0000, 0); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("changing the policy of a fork changed the ROM, write returned %v", err)
+	}
+	if err := fork.Write(0x0000, 0); err != nil || fork.Read(0x0000) != 0x11 {
+		t.Fatalf("fork ignoring writes returned %v", err)
+	}
+}
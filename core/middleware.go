@@ -0,0 +1,331 @@
+package core
+
+import (
+	"fmt"
+	"io"
+)
+
+//Bus middleware wraps any SystemBus, including other middleware, and adds
+//behavior to its accesses. Every wrapper forks along with the bus it wraps,
+//gives access to its banks, reports its wait states and bank switches and
+//passes vector pulls on to it.
+
+//BusAccess is a single read or write on the bus
+type BusAccess struct {
+	Address uint16
+	Value   uint8
+	Write   bool
+	//Vector is set for vector bytes supplied by a VectorPuller instead of
+	//being read from the bus
+	Vector bool
+}
+
+func (access BusAccess) String() string {
+	if access.Vector {
+		return fmt.Sprintf("V %04X %02X", access.Address, access.Value)
+	}
+	if access.Write {
+		return fmt.Sprintf("W %04X %02X", access.Address, access.Value)
+	}
+	return fmt.Sprintf("R %04X %02X", access.Address, access.Value)
+}
+
+//busWrapper holds the wrapped bus and forwards bank accesses, wait states,
+//bank switches and vector pulls to it
+type busWrapper struct {
+	bus SystemBus
+}
+
+//Unwrap returns the wrapped bus
+func (w *busWrapper) Unwrap() SystemBus {
+	return w.bus
+}
+
+func (w *busWrapper) ReadBank(bank int, addr uint16) (uint8, error) {
+	banked, ok := w.bus.(BankedBus)
+	if !ok {
+		return 0, fmt.Errorf("the bus has no banks")
+	}
+	return banked.ReadBank(bank, addr)
+}
+
+func (w *busWrapper) WriteBank(bank int, addr uint16, val uint8) error {
+	banked, ok := w.bus.(BankedBus)
+	if !ok {
+		return fmt.Errorf("the bus has no banks")
+	}
+	return banked.WriteBank(bank, addr, val)
+}
+
+func (w *busWrapper) WaitStates() uint64 {
+	if waits, ok := w.bus.(WaitStater); ok {
+		return waits.WaitStates()
+	}
+	return 0
+}
+
+func (w *busWrapper) BankSwitches() uint64 {
+	if switcher, ok := w.bus.(BankSwitcher); ok {
+		return switcher.BankSwitches()
+	}
+	return 0
+}
+
+func (w *busWrapper) PullVector(addr uint16) (uint8, bool) {
+	if puller, ok := w.bus.(VectorPuller); ok {
+		return puller.PullVector(addr)
+	}
+	return 0, false
+}
+
+//fork returns a wrapper of the fork of the wrapped bus. A wrapped bus that
+//can not be forked is shared with the fork, which CheckFork reports.
+func (w *busWrapper) fork() busWrapper {
+	return busWrapper{bus: forkAlone(w.bus)}
+}
+
+//CheckFork returns an error if the wrapped bus can not be forked into an
+//independent copy
+func (w *busWrapper) CheckFork() error {
+	return checkFork(w.bus)
+}
+
+//LoggingBus writes every access to Writer, one line per access. See
+//BusAccess.String for the format.
+type LoggingBus struct {
+	busWrapper
+	Writer io.Writer
+	//Err is the first error returned by Writer
+	Err error
+}
+
+func NewLoggingBus(bus SystemBus, w io.Writer) *LoggingBus {
+	return &LoggingBus{busWrapper: busWrapper{bus: bus}, Writer: w}
+}
+
+func (bus *LoggingBus) log(access BusAccess) {
+	_, err := fmt.Fprintln(bus.Writer, access)
+	if err != nil && bus.Err == nil {
+		bus.Err = err
+	}
+}
+
+func (bus *LoggingBus) Read(addr uint16) uint8 {
+	val := bus.bus.Read(addr)
+	bus.log(BusAccess{Address: addr, Value: val})
+	return val
+}
+
+func (bus *LoggingBus) PullVector(addr uint16) (uint8, bool) {
+	val, ok := bus.busWrapper.PullVector(addr)
+	if ok {
+		bus.log(BusAccess{Address: addr, Value: val, Vector: true})
+	}
+	return val, ok
+}
+
+func (bus *LoggingBus) Write(addr uint16, val uint8) error {
+	bus.log(BusAccess{Address: addr, Value: val, Write: true})
+	return bus.bus.Write(addr, val)
+}
+
+//Fork returns a logger of the fork of the wrapped bus writing to the same
+//Writer
+func (bus *LoggingBus) Fork() SystemBus {
+	return &LoggingBus{busWrapper: bus.fork(), Writer: bus.Writer}
+}
+
+//CountingBus counts reads and writes per address
+type CountingBus struct {
+	busWrapper
+	reads  [MaxBusSize]uint64
+	writes [MaxBusSize]uint64
+}
+
+func NewCountingBus(bus SystemBus) *CountingBus {
+	return &CountingBus{busWrapper: busWrapper{bus: bus}}
+}
+
+//Reads returns the number of reads from addr
+func (bus *CountingBus) Reads(addr uint16) uint64 {
+	return bus.reads[addr]
+}
+
+//Writes returns the number of writes to addr
+func (bus *CountingBus) Writes(addr uint16) uint64 {
+	return bus.writes[addr]
+}
+
+//Reset sets all counts to zero
+func (bus *CountingBus) Reset() {
+	bus.reads = [MaxBusSize]uint64{}
+	bus.writes = [MaxBusSize]uint64{}
+}
+
+func (bus *CountingBus) Read(addr uint16) uint8 {
+	bus.reads[addr]++
+	return bus.bus.Read(addr)
+}
+
+//PullVector counts vector bytes supplied by a VectorPuller as reads
+func (bus *CountingBus) PullVector(addr uint16) (uint8, bool) {
+	val, ok := bus.busWrapper.PullVector(addr)
+	if ok {
+		bus.reads[addr]++
+	}
+	return val, ok
+}
+
+func (bus *CountingBus) Write(addr uint16, val uint8) error {
+	bus.writes[addr]++
+	return bus.bus.Write(addr, val)
+}
+
+//Fork returns a counter of the fork of the wrapped bus that starts from the
+//current counts
+func (bus *CountingBus) Fork() SystemBus {
+	fork := *bus
+	fork.busWrapper = bus.fork()
+	return &fork
+}
+
+//ReadOnlyBus protects addresses Start through End from writes. Depending on
+//Policy, protected writes either return ErrReadOnly or are ignored.
+type ReadOnlyBus struct {
+	busWrapper
+	Start  uint16
+	End    uint16
+	Policy ROMWritePolicy
+}
+
+func NewReadOnlyBus(bus SystemBus, start, end uint16, policy ROMWritePolicy) *ReadOnlyBus {
+	return &ReadOnlyBus{busWrapper: busWrapper{bus: bus}, Start: start, End: end, Policy: policy}
+}
+
+func (bus *ReadOnlyBus) Read(addr uint16) uint8 {
+	return bus.bus.Read(addr)
+}
+
+func (bus *ReadOnlyBus) Write(addr uint16, val uint8) error {
+	if addr >= bus.Start && addr <= bus.End {
+		if bus.Policy == ROMWriteIgnore {
+			return nil
+		}
+		return fmt.Errorf("write to %#04x: %w", addr, ErrReadOnly)
+	}
+	return bus.bus.Write(addr, val)
+}
+
+func (bus *ReadOnlyBus) Fork() SystemBus {
+	fork := *bus
+	fork.busWrapper = bus.fork()
+	return &fork
+}
+
+//BusFault is the error returned for accesses to faulting addresses
+type BusFault struct {
+	Access BusAccess
+}
+
+func (fault *BusFault) Error() string {
+	if fault.Access.Write {
+		return fmt.Sprintf("bus fault writing %#02x to %#04x", fault.Access.Value, fault.Access.Address)
+	}
+	return fmt.Sprintf("bus fault reading from %#04x", fault.Access.Address)
+}
+
+//FaultingBus fails every access to chosen addresses. Writes return a
+//*BusFault and never reach the wrapped bus. Reads can not fail, so they
+//return OpenBus and the fault is kept in Err until it is cleared.
+type FaultingBus struct {
+	busWrapper
+	faults map[uint16]bool
+	//OpenBus is the value returned by faulting reads
+	OpenBus uint8
+	//Err is the first fault of a read
+	Err error
+}
+
+func NewFaultingBus(bus SystemBus, addrs ...uint16) *FaultingBus {
+	f := &FaultingBus{busWrapper: busWrapper{bus: bus}, faults: make(map[uint16]bool), OpenBus: openBus}
+	for _, addr := range addrs {
+		f.Fault(addr)
+	}
+	return f
+}
+
+//Fault makes accesses to addr fail
+func (bus *FaultingBus) Fault(addr uint16) {
+	bus.faults[addr] = true
+}
+
+//Clear makes accesses to addr succeed again
+func (bus *FaultingBus) Clear(addr uint16) {
+	delete(bus.faults, addr)
+}
+
+func (bus *FaultingBus) Read(addr uint16) uint8 {
+	if bus.faults[addr] {
+		if bus.Err == nil {
+			bus.Err = &BusFault{Access: BusAccess{Address: addr}}
+		}
+		return bus.OpenBus
+	}
+	return bus.bus.Read(addr)
+}
+
+func (bus *FaultingBus) Write(addr uint16, val uint8) error {
+	if bus.faults[addr] {
+		return &BusFault{Access: BusAccess{Address: addr, Value: val, Write: true}}
+	}
+	return bus.bus.Write(addr, val)
+}
+
+func (bus *FaultingBus) Fork() SystemBus {
+	fork := *bus
+	fork.busWrapper = bus.fork()
+	fork.faults = make(map[uint16]bool, len(bus.faults))
+	for addr := range bus.faults {
+		fork.faults[addr] = true
+	}
+	return &fork
+}
+
+//HookBus calls Hook after every access to the wrapped bus. Writes that fail
+//are not reported.
+type HookBus struct {
+	busWrapper
+	Hook func(access BusAccess)
+}
+
+func NewHookBus(bus SystemBus, hook func(access BusAccess)) *HookBus {
+	return &HookBus{busWrapper: busWrapper{bus: bus}, Hook: hook}
+}
+
+func (bus *HookBus) Read(addr uint16) uint8 {
+	val := bus.bus.Read(addr)
+	bus.Hook(BusAccess{Address: addr, Value: val})
+	return val
+}
+
+func (bus *HookBus) PullVector(addr uint16) (uint8, bool) {
+	val, ok := bus.busWrapper.PullVector(addr)
+	if ok {
+		bus.Hook(BusAccess{Address: addr, Value: val, Vector: true})
+	}
+	return val, ok
+}
+
+func (bus *HookBus) Write(addr uint16, val uint8) error {
+	err := bus.bus.Write(addr, val)
+	if err != nil {
+		return err
+	}
+	bus.Hook(BusAccess{Address: addr, Value: val, Write: true})
+	return nil
+}
+
+//Fork returns a wrapper of the fork of the wrapped bus calling the same Hook
+func (bus *HookBus) Fork() SystemBus {
+	return &HookBus{busWrapper: bus.fork(), Hook: bus.Hook}
+}
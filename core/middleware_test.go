@@ -0,0 +1,78 @@
+package core
+
+import (
+	"io"
+	"testing"
+)
+
+//sharedDevice is a device that does not implement Forker
+type sharedDevice struct {
+	mem [0x100]uint8
+}
+
+func (dev *sharedDevice) Read(addr uint16) uint8 {
+	return dev.mem[addr&0xff]
+}
+
+func (dev *sharedDevice) Write(addr uint16, val uint8) error {
+	dev.mem[addr&0xff] = val
+	return nil
+}
+
+func TestCPUForkRejectsSharedState(t *testing.T) {
+	mapped := func(device SystemBus) SystemBus {
+		bus := NewMappedBus()
+		bus.Map("ram", 0x0000, 0x7fff, NewRAM(0x8000))
+		bus.Map("device", 0x8000, 0x80ff, device)
+		return bus
+	}
+	buses := []struct {
+		name string
+		bus  SystemBus
+	}{
+		{"device", &sharedDevice{}},
+		{"wrapped device", NewLoggingBus(&sharedDevice{}, io.Discard)},
+		{"stacked middleware", NewCountingBus(NewHookBus(&sharedDevice{}, func(BusAccess) {}))},
+		{"mapped device", mapped(&sharedDevice{})},
+		{"mapped bank", mapped(NewBankedMemory(NewRAM(0x100), &sharedDevice{}))},
+		{"wrapped mapped device", NewReadOnlyBus(mapped(&sharedDevice{}), 0, 0, ROMWriteIgnore)},
+	}
+	for _, b := range buses {
+		cpu := NewCPU(b.bus, NewCPURegisters())
+		if _, err := cpu.Fork(); err == nil {
+			t.Errorf("%s: fork shares the device", b.name)
+		}
+	}
+}
+
+func TestCPUForkIsIndependent(t *testing.T) {
+	buses := []struct {
+		name string
+		bus  SystemBus
+	}{
+		{"BasicBus", NewBasicBus()},
+		{"middleware", NewCountingBus(NewLoggingBus(NewBasicBus(), io.Discard))},
+		{"MappedBus", func() SystemBus {
+			bus := NewMappedBus()
+			window := NewBankedRAM(2, 0x1000)
+			bus.Map("ram", 0x0000, 0x7fff, NewRAM(0x8000))
+			bus.Map("window", 0x8000, 0x8fff, window)
+			bus.Map("bank", 0x9000, 0x9000, NewBankRegister(0x01, window))
+			return bus
+		}()},
+	}
+	for _, b := range buses {
+		cpu := NewCPU(b.bus, NewCPURegisters())
+		fork, err := cpu.Fork()
+		if err != nil {
+			t.Errorf("%s: %v", b.name, err)
+			continue
+		}
+		for _, addr := range []uint16{0x0200, 0x8000} {
+			fork.Bus.Write(addr, 0x42)
+			if val := cpu.Bus.Read(addr); val == 0x42 {
+				t.Errorf("%s: write to the fork at %#04x is visible in the original", b.name, addr)
+			}
+		}
+	}
+}
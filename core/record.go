@@ -0,0 +1,343 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+//InputKind identifies the kind of an external input to the machine.
+type InputKind string
+
+const (
+	//InputInterrupt -> CPU.Interrupt was called
+	InputInterrupt InputKind = "irq"
+	//InputNMInterrupt -> CPU.NMInterrupt was called
+	InputNMInterrupt InputKind = "nmi"
+	//InputReset -> the cpu, or the machine it runs in, was reset
+	InputReset InputKind = "reset"
+	//InputBusWrite -> the host wrote Value to the bus at Address
+	InputBusWrite InputKind = "write"
+	//InputRegister -> the host set Register to Value
+	InputRegister InputKind = "register"
+	//InputDevice -> Device received the input byte Value
+	InputDevice InputKind = "device"
+)
+
+//InputEvent is one external input, stamped with the number of instructions
+//the cpu had executed since the recording started when the input arrived.
+type InputEvent struct {
+	Instruction uint64    `json:"instruction"`
+	Kind        InputKind `json:"kind"`
+	Address     uint16    `json:"address,omitempty"`
+	Register    string    `json:"register,omitempty"`
+	Device      string    `json:"device,omitempty"`
+	Value       uint16    `json:"value,omitempty"`
+}
+
+//Recording is everything needed to reproduce a session: the state it starts
+//from and every input in the order it arrived.
+//
+//A recording of a cpu on a BasicBus starts from the saved registers and
+//memory. The state of other buses, and of the devices on them, can not be
+//saved, so their recordings start from reset instead: Reset is set, and the
+//session is only reproduced on a machine built the same way, with the same
+//images, and reset before the first instruction.
+type Recording struct {
+	//Reset is set when the recording starts from reset instead of from the
+	//saved registers and memory
+	Reset     bool         `json:"reset,omitempty"`
+	Registers CPURegisters `json:"registers"`
+	Waiting   bool         `json:"waiting,omitempty"`
+	Stopped   bool         `json:"stopped,omitempty"`
+	//Memory is the initial image of a BasicBus
+	Memory []uint8 `json:"memory,omitempty"`
+	//Instructions is the instruction count at which the recording ended
+	Instructions uint64       `json:"instructions"`
+	Events       []InputEvent `json:"events"`
+}
+
+//Save writes the recording as JSON.
+func (rec *Recording) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(rec)
+}
+
+//LoadRecording reads a recording written by Recording.Save.
+func LoadRecording(r io.Reader) (*Recording, error) {
+	rec := &Recording{}
+	err := json.NewDecoder(r).Decode(rec)
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+//Recorder records the external inputs of a cpu. Inputs must be sent through
+//the recorder instead of directly to the cpu or its bus to end up in the
+//recording.
+type Recorder struct {
+	//ResetMachine performs the resets sent through the recorder. It is nil
+	//by default, which resets only the cpu. Set it to reset a machine along
+	//with its devices.
+	ResetMachine func()
+
+	cpu       *CPU
+	recording Recording
+	start     uint64 //instruction count of the cpu when the recording started
+	devices   map[string]func(uint8)
+}
+
+//NewRecorder starts recording the inputs of cpu from its current registers
+//and memory. It fails unless cpu.Bus is a BasicBus, since the state of other
+//buses can not be saved. Record those from reset with NewResetRecorder.
+func NewRecorder(cpu *CPU) (*Recorder, error) {
+	basic, ok := cpu.Bus.(*BasicBus)
+	if !ok {
+		return nil, fmt.Errorf("can not save the state of bus %T, record from reset instead", cpu.Bus)
+	}
+	r := newRecorder(cpu)
+	r.recording.Registers = *cpu.Registers
+	r.recording.Waiting = cpu.waiting
+	r.recording.Stopped = cpu.stopped
+	r.recording.Memory = make([]uint8, MaxBusSize)
+	for addr := range r.recording.Memory {
+		r.recording.Memory[addr] = basic.Read(uint16(addr))
+	}
+	return r, nil
+}
+
+//NewResetRecorder resets the machine cpu runs in with reset, or only the cpu
+//if reset is nil, and starts recording its inputs from there. reset is kept
+//as ResetMachine. The machine should be freshly built, since memory and
+//devices that ignore resets keep their state.
+func NewResetRecorder(cpu *CPU, reset func()) *Recorder {
+	r := newRecorder(cpu)
+	r.ResetMachine = reset
+	r.reset()
+	r.recording.Reset = true
+	r.recording.Registers = *cpu.Registers
+	return r
+}
+
+func newRecorder(cpu *CPU) *Recorder {
+	return &Recorder{
+		cpu:     cpu,
+		start:   cpu.Instructions(),
+		devices: make(map[string]func(uint8)),
+	}
+}
+
+func (r *Recorder) record(event InputEvent) {
+	event.Instruction = r.cpu.Instructions() - r.start
+	r.recording.Events = append(r.recording.Events, event)
+}
+
+//HandleInput registers the function that delivers input bytes to device.
+func (r *Recorder) HandleInput(device string, deliver func(uint8)) {
+	r.devices[device] = deliver
+}
+
+//Interrupt records and sends a maskable interrupt
+func (r *Recorder) Interrupt() {
+	r.record(InputEvent{Kind: InputInterrupt})
+	r.cpu.Interrupt()
+}
+
+//NMInterrupt records and sends a non-maskable interrupt
+func (r *Recorder) NMInterrupt() {
+	r.record(InputEvent{Kind: InputNMInterrupt})
+	r.cpu.NMInterrupt()
+}
+
+//Reset records and performs a reset
+func (r *Recorder) Reset() {
+	r.record(InputEvent{Kind: InputReset})
+	r.reset()
+}
+
+func (r *Recorder) reset() {
+	if r.ResetMachine != nil {
+		r.ResetMachine()
+		return
+	}
+	r.cpu.Reset()
+}
+
+//Write records and performs a host write to the bus
+func (r *Recorder) Write(addr uint16, val uint8) error {
+	r.record(InputEvent{Kind: InputBusWrite, Address: addr, Value: uint16(val)})
+	return r.cpu.Bus.Write(addr, val)
+}
+
+//SetRegister records and sets one of the cpu registers. See
+//CPURegisters.Set for valid names.
+func (r *Recorder) SetRegister(name string, val uint16) error {
+	err := r.cpu.Registers.Set(name, val)
+	if err != nil {
+		return err
+	}
+	r.record(InputEvent{Kind: InputRegister, Register: name, Value: val})
+	return nil
+}
+
+//Input records and delivers an input byte to device
+func (r *Recorder) Input(device string, val uint8) error {
+	deliver, ok := r.devices[device]
+	if !ok {
+		return fmt.Errorf("no input handler for device %q", device)
+	}
+	r.record(InputEvent{Kind: InputDevice, Device: device, Value: uint16(val)})
+	deliver(val)
+	return nil
+}
+
+//Recording returns everything recorded up to the current instruction.
+func (r *Recorder) Recording() *Recording {
+	rec := r.recording
+	rec.Instructions = r.cpu.Instructions() - r.start
+	rec.Events = append([]InputEvent(nil), r.recording.Events...)
+	return &rec
+}
+
+//Replayer reproduces a recorded session by feeding the recorded inputs to a
+//cpu at the same instruction counts at which they arrived.
+type Replayer struct {
+	//Step executes one instruction. It is nil by default, which executes
+	//instructions with CPU.Execute. Set it to step a machine that runs
+	//devices along with the cpu.
+	Step func() error
+	//ResetMachine performs the recorded resets. It is nil by default, which
+	//resets only the cpu. Set it to reset a machine along with its devices.
+	ResetMachine func()
+
+	cpu       *CPU
+	recording *Recording
+	start     uint64 //instruction count of the cpu when the replay started
+	next      int    //index of the next event to apply
+	started   bool   //the replay was reset, if it starts from reset
+	devices   map[string]func(uint8)
+}
+
+//NewReplayer prepares cpu for replaying rec. For a recording from reset, the
+//machine is reset before the first instruction, with ResetMachine if it is
+//set by then. Otherwise the recorded registers and memory are restored.
+func NewReplayer(cpu *CPU, rec *Recording) (*Replayer, error) {
+	if !rec.Reset {
+		if len(rec.Memory) != MaxBusSize {
+			return nil, fmt.Errorf("recording has neither memory nor a reset to start from")
+		}
+		*cpu.Registers = rec.Registers
+		cpu.waiting = rec.Waiting
+		cpu.stopped = rec.Stopped
+		for addr, val := range rec.Memory {
+			err := cpu.Bus.Write(uint16(addr), val)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	p := &Replayer{
+		cpu:       cpu,
+		recording: rec,
+		start:     cpu.Instructions(),
+		started:   !rec.Reset,
+		devices:   make(map[string]func(uint8)),
+	}
+	return p, nil
+}
+
+//HandleInput registers the function that delivers input bytes to device.
+func (p *Replayer) HandleInput(device string, deliver func(uint8)) {
+	p.devices[device] = deliver
+}
+
+//Done reports whether the cpu reached the end of the recording
+func (p *Replayer) Done() bool {
+	return p.next == len(p.recording.Events) && p.position() >= p.recording.Instructions
+}
+
+//position returns the instruction count of the replay in recording terms
+func (p *Replayer) position() uint64 {
+	return p.cpu.Instructions() - p.start
+}
+
+//Execute applies the inputs that arrived before the next instruction and
+//executes it.
+func (p *Replayer) Execute() error {
+	err := p.apply()
+	if err != nil {
+		return err
+	}
+	if p.Step != nil {
+		return p.Step()
+	}
+	p.cpu.Execute()
+	return nil
+}
+
+//Run replays the recording up to its end or until the cpu executed n
+//instructions, whichever comes first. The inputs that arrived after the
+//last instruction are applied too.
+func (p *Replayer) Run(n uint64) error {
+	for i := uint64(0); i < n && p.position() < p.recording.Instructions; i++ {
+		before := p.cpu.Instructions()
+		err := p.Execute()
+		if err != nil {
+			return err
+		}
+		if p.cpu.Instructions() == before {
+			//the cpu is stopped or waiting and no input is due to wake it
+			break
+		}
+	}
+	return p.apply()
+}
+
+//apply applies every input due at the current position
+func (p *Replayer) apply() error {
+	if !p.started {
+		p.started = true
+		p.reset()
+	}
+	for p.next < len(p.recording.Events) {
+		event := p.recording.Events[p.next]
+		if event.Instruction > p.position() {
+			return nil
+		}
+		p.next++
+		var err error
+		switch event.Kind {
+		case InputInterrupt:
+			p.cpu.Interrupt()
+		case InputNMInterrupt:
+			p.cpu.NMInterrupt()
+		case InputReset:
+			p.reset()
+		case InputBusWrite:
+			err = p.cpu.Bus.Write(event.Address, uint8(event.Value))
+		case InputRegister:
+			err = p.cpu.Registers.Set(event.Register, event.Value)
+		case InputDevice:
+			deliver, ok := p.devices[event.Device]
+			if !ok {
+				err = fmt.Errorf("no input handler for device %q", event.Device)
+				break
+			}
+			deliver(uint8(event.Value))
+		default:
+			err = fmt.Errorf("unknown input kind %q", event.Kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Replayer) reset() {
+	if p.ResetMachine != nil {
+		p.ResetMachine()
+		return
+	}
+	p.cpu.Reset()
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestReplayResetsTheMachine(t *testing.T) {
+	newCPU := func() *CPU {
+		bus := NewBasicBus()
+		bus.Load(0x0200, bytes.Repeat([]uint8{0xe8}, 0x10)) //INX
+		bus.Load(vectorRES, []uint8{0x00, 0x02})
+		registers := NewCPURegisters()
+		registers.ProgramCounter = 0x0200
+		return NewCPU(bus, registers)
+	}
+	//a device of the machine that counts the resets it sees
+	resets := 0
+	cpu := newCPU()
+	recorder, err := NewRecorder(cpu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.ResetMachine = func() {
+		resets++
+		cpu.Reset()
+	}
+	for i := 0; i < 5; i++ {
+		cpu.Execute()
+	}
+	recorder.Reset()
+	for i := 0; i < 3; i++ {
+		cpu.Execute()
+	}
+	if resets != 1 {
+		t.Fatalf("recorder reset the machine %d times, want 1", resets)
+	}
+	var file bytes.Buffer
+	err = recorder.Recording().Save(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := LoadRecording(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resets = 0
+	replayed := newCPU()
+	replayer, err := NewReplayer(replayed, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayer.ResetMachine = func() {
+		resets++
+		replayed.Reset()
+	}
+	err = replayer.Run(math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resets != 1 {
+		t.Fatalf("replay reset the machine %d times, want 1", resets)
+	}
+	if *replayed.Registers != *cpu.Registers {
+		t.Fatalf("replay ended with %+v, want %+v", *replayed.Registers, *cpu.Registers)
+	}
+}
+
+func TestRecordingFromReset(t *testing.T) {
+	newCPU := func() *CPU {
+		ram := NewRAM(MaxBusSize)
+		ram.Load(0x0200, []uint8{
+			0xe8,             //INX
+			0x8e, 0x00, 0x03, //STX $0300
+			0xe8,             //INX
+			0x8e, 0x01, 0x03, //STX $0301
+			0xdb, //STP
+		})
+		ram.Load(vectorRES, []uint8{0x00, 0x02})
+		bus := NewMappedBus()
+		bus.Map("ram", 0x0000, 0xffff, ram)
+		return NewCPU(bus, NewCPURegisters())
+	}
+	cpu := newCPU()
+	_, err := NewRecorder(cpu)
+	if err == nil {
+		t.Fatal("recorded a MappedBus without saving its state")
+	}
+	recorder := NewResetRecorder(cpu, nil)
+	cpu.Execute()
+	err = recorder.SetRegister(RegisterX, 0x41)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for !cpu.Stopped() {
+		cpu.Execute()
+	}
+	rec := recorder.Recording()
+	if !rec.Reset || rec.Memory != nil {
+		t.Fatalf("recording from reset has reset %v and %d bytes of memory", rec.Reset, len(rec.Memory))
+	}
+
+	replayed := newCPU()
+	//a cpu that ran before the replay starts over from reset
+	replayed.Registers.X = 0x10
+	replayed.Execute()
+	replayer, err := NewReplayer(replayed, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = replayer.Run(math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *replayed.Registers != *cpu.Registers {
+		t.Fatalf("replay ended with %+v, want %+v", *replayed.Registers, *cpu.Registers)
+	}
+	for _, addr := range []uint16{0x0300, 0x0301} {
+		if got, want := replayed.Bus.Read(addr), cpu.Bus.Read(addr); got != want {
+			t.Fatalf("%#04x is %#02x after the replay, want %#02x", addr, got, want)
+		}
+	}
+}
+
+func TestReplayerNeedsAStartState(t *testing.T) {
+	_, err := NewReplayer(NewCPU(NewBasicBus(), NewCPURegisters()), &Recording{})
+	if err == nil {
+		t.Fatal("replayed a recording without memory or a reset to start from")
+	}
+}
@@ -0,0 +1,62 @@
+package core
+
+//Addresses of the low bytes of the interrupt and reset vectors
+const (
+	vectorNMI uint16 = 0xfffa
+	vectorRES uint16 = 0xfffc
+	vectorIRQ uint16 = 0xfffe
+)
+
+//VectorPuller is implemented by buses and devices that take part in vector
+//pulls. The W65C02S pulls its VP pin low while it fetches the two bytes of an
+//interrupt, BRK or reset vector, and some boards use the pin to let an
+//interrupt controller put a vector of its own on the data bus. PullVector is
+//called with the absolute address of each vector byte and returns ok=false
+//to let the byte be read from the bus as usual.
+type VectorPuller interface {
+	PullVector(addr uint16) (val uint8, ok bool)
+}
+
+//readVector fetches the vector at addr, giving the bus a chance to supply it
+func (cpu *CPU) readVector(addr uint16) uint16 {
+	bus := cpu.Bus
+	if cached, ok := bus.(*blockCacheBus); ok {
+		bus = cached.SystemBus
+	}
+	puller, ok := bus.(VectorPuller)
+	if !ok {
+		return uint16(cpu.read(addr)) | uint16(cpu.read(addr+1))<<8
+	}
+	lo, ok := puller.PullVector(addr)
+	if !ok {
+		lo = cpu.read(addr)
+	}
+	hi, ok := puller.PullVector(addr + 1)
+	if !ok {
+		hi = cpu.read(addr + 1)
+	}
+	return uint16(lo) | uint16(hi)<<8
+}
+
+//PullVector asks the mapped devices that implement VectorPuller for the
+//vector byte at addr, most recently mapped first, no matter which addresses
+//they are mapped to. The wait states of the region addr is decoded to are
+//inserted as for a read.
+func (bus *MappedBus) PullVector(addr uint16) (uint8, bool) {
+	for i := len(bus.regions) - 1; i >= 0; i-- {
+		puller, ok := bus.regions[i].Device.(VectorPuller)
+		if !ok {
+			continue
+		}
+		val, ok := puller.PullVector(addr)
+		if !ok {
+			continue
+		}
+		if r := bus.Lookup(addr); r != nil {
+			bus.waits += uint64(r.WaitStates)
+		}
+		bus.last = val
+		return val, true
+	}
+	return 0, false
+}
@@ -17,6 +17,7 @@ func parseCliArguments() *shellOptions {
 	var binaryFileName string
 	opt.loadBinaryFile = false
 	flag.StringVar(&binaryFileName, "file", "", "65c02 executable to debug")
+	flag.StringVar(&opt.machineFile, "machine", "", "JSON machine config to debug")
 	flag.Parse()
 	if binaryFileName != "" {
 		opt.loadBinaryFile = true
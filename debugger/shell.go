@@ -4,16 +4,20 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"math"
 	"os"
 	"strconv"
 	"strings"
 
 	"github.com/rdzhaafar/emu6502/core"
+	"github.com/rdzhaafar/emu6502/devices"
+	"github.com/rdzhaafar/emu6502/machine"
 )
 
 type shellOptions struct {
 	loadBinaryFile bool
 	binaryFileName string
+	machineFile    string //machine config, the cpu runs on a plain BasicBus without one
 }
 
 type shellCommand struct {
@@ -22,8 +26,12 @@ type shellCommand struct {
 }
 
 type interactiveShell struct {
-	options *shellOptions
-	cpu     *core.CPU
+	options    *shellOptions
+	cpu        *core.CPU
+	machine    *machine.Machine //set if the debugger was started with a machine config
+	recorder   *core.Recorder   //set while a session is being recorded
+	recordFile string           //file the recording is saved to
+	replayer   *core.Replayer   //set while a recording is being replayed
 }
 
 func newShellCommand(input []byte) *shellCommand {
@@ -65,6 +73,18 @@ func (shell *interactiveShell) handleCommand(cmd *shellCommand) bool {
 	case "help":
 		shell.helpCmd(cmd)
 		return false
+	case "irq", "nmi", "reset":
+		shell.signalCmd(cmd)
+		return false
+	case "record":
+		shell.recordCmd(cmd)
+		return false
+	case "replay":
+		shell.replayCmd(cmd)
+		return false
+	case "lcd":
+		shell.lcdCmd(cmd)
+		return false
 	default:
 		shell.invalidCmd(cmd.command)
 		return false
@@ -103,33 +123,33 @@ func (shell *interactiveShell) printCmd(cmd *shellCommand) {
 	case 2:
 		switch args[0] {
 		case "bus":
-			addr, err := strconv.ParseUint(args[1], 16, 16)
+			addr, err := parseBusAddress(args[1])
 			if err != nil {
 				shell.invalidArgs(cmd.command, args)
 				break
 			}
-			shell.printBus(uint16(addr))
+			shell.printBus(cmd.command, addr)
 
 		default:
 			shell.invalidArgs(cmd.command, args)
 		}
 
 	case 3:
-		addrl, err := strconv.ParseUint(args[1], 16, 16)
+		addrl, err := parseBusAddress(args[1])
 		if err != nil {
 			shell.invalidArgs(cmd.command, args)
 			break
 		}
-		addrh, err := strconv.ParseUint(args[2], 16, 16)
+		addrh, err := parseBusAddress(args[2])
 		if err != nil {
 			shell.invalidArgs(cmd.command, args)
 			break
 		}
-		if addrh < addrl {
+		if addrh.addr < addrl.addr || addrh.banked != addrl.banked || addrh.bank != addrl.bank {
 			shell.invalidArgs(cmd.command, args)
 			break
 		}
-		shell.printBusRange(uint16(addrl), uint16(addrh))
+		shell.printBusRange(cmd.command, addrl, addrh.addr)
 
 	default:
 		shell.invalidArgs(cmd.command, args)
@@ -145,13 +165,25 @@ func (shell *interactiveShell) helpCmd(cmd *shellCommand) {
 		fmt.Println("\t\"print registers\" prints the contents of cpu registers")
 		fmt.Println("\t\"print bus X\" prints the contents of the bus at address X")
 		fmt.Println("\t\"print bus X Y\" prints the contents of the bus from address X to Y")
+		fmt.Println("\t\"print bus B:X\" prints address X in bank B, even if the bank is not mapped in")
 		fmt.Println("3. set -> sets the registers/bus to the specified value")
 		fmt.Println("\t\"set (a, p, x, y, pc, sp) X\" sets the the specified register to X")
 		fmt.Println("\t\"set bus X Y\" sets the bus at address X to Y")
+		fmt.Println("\t\"set bus B:X Y\" sets address X in bank B to Y")
 		fmt.Println("4. load -> loads a binary file to the bus for debugging")
 		fmt.Println("\t\"load X\" loads file X (where X is either an absolute path, or a relative path)")
-		fmt.Println("5. help -> prints this help message")
-		fmt.Println("6. exit -> exits the interactive shell")
+		fmt.Println("5. irq, nmi, reset -> sends an interrupt, a non-maskable interrupt or a reset to the cpu")
+		fmt.Println("6. record -> records every input to the cpu so that the session can be replayed")
+		fmt.Println("\t\"record X\" starts recording to file X")
+		fmt.Println("\t\"record stop\" stops recording and saves the file")
+		fmt.Println("7. replay -> replays a recorded session")
+		fmt.Println("\t\"replay X\" replays file X up to the end of the recording")
+		fmt.Println("\t\"replay X N\" replays file X up to instruction N")
+		fmt.Println("8. lcd -> prints the text shown on the LCDs of the machine")
+		fmt.Println("\t\"lcd\" prints every LCD")
+		fmt.Println("\t\"lcd X\" prints the LCD named X")
+		fmt.Println("9. help -> prints this help message")
+		fmt.Println("10. exit -> exits the interactive shell")
 	default:
 		shell.invalidArgs(cmd.command, cmd.args)
 	}
@@ -166,15 +198,70 @@ func (shell *interactiveShell) printRegisters() {
 	fmt.Printf("PC: %04X\n", shell.cpu.Registers.ProgramCounter)
 }
 
-func (shell *interactiveShell) printBusRange(addrl uint16, addrh uint16) {
-	for i := addrl; i <= addrh; i++ {
-		shell.printBus(i)
+func (shell *interactiveShell) printBusRange(cmd string, addrl busAddress, addrh uint16) {
+	for i := uint32(addrl.addr); i <= uint32(addrh); i++ {
+		addr := addrl
+		addr.addr = uint16(i)
+		if !shell.printBus(cmd, addr) {
+			break
+		}
+	}
+}
+
+func (shell *interactiveShell) printBus(cmd string, addr busAddress) bool {
+	if !addr.banked {
+		val := shell.cpu.Bus.Read(addr.addr)
+		fmt.Printf("%04X: %02X\n", addr.addr, val)
+		return true
+	}
+	banked, ok := shell.cpu.Bus.(core.BankedBus)
+	if !ok {
+		shell.printError(cmd, "The bus has no banks.")
+		return false
 	}
+	val, err := banked.ReadBank(addr.bank, addr.addr)
+	if err != nil {
+		shell.printError(cmd, err.Error())
+		return false
+	}
+	fmt.Printf("%s: %02X\n", addr, val)
+	return true
 }
 
-func (shell *interactiveShell) printBus(addr uint16) {
-	val := shell.cpu.Bus.Read(uint16(addr))
-	fmt.Printf("%04X: %02X\n", addr, val)
+//busAddress is an address on the bus, optionally in a bank that does not
+//have to be mapped in
+type busAddress struct {
+	bank   int
+	banked bool
+	addr   uint16
+}
+
+//parseBusAddress parses hexadecimal addresses written as either "ADDR" or
+//"BANK:ADDR"
+func parseBusAddress(arg string) (busAddress, error) {
+	var addr busAddress
+	if i := strings.IndexByte(arg, ':'); i >= 0 {
+		bank, err := strconv.ParseUint(arg[:i], 16, 16)
+		if err != nil {
+			return addr, err
+		}
+		addr.bank = int(bank)
+		addr.banked = true
+		arg = arg[i+1:]
+	}
+	val, err := strconv.ParseUint(arg, 16, 16)
+	if err != nil {
+		return addr, err
+	}
+	addr.addr = uint16(val)
+	return addr, nil
+}
+
+func (addr busAddress) String() string {
+	if addr.banked {
+		return fmt.Sprintf("%X:%04X", addr.bank, addr.addr)
+	}
+	return fmt.Sprintf("%04X", addr.addr)
 }
 
 func (shell *interactiveShell) setCmd(cmd *shellCommand) {
@@ -189,7 +276,7 @@ func (shell *interactiveShell) setCmd(cmd *shellCommand) {
 				shell.invalidArgs(cmd.command, args)
 				break
 			}
-			shell.cpu.Registers.Accumulator = uint8(val)
+			shell.setRegister(core.RegisterA, uint16(val))
 			shell.printInfo(cmd.command, fmt.Sprintf("Set accumulator to %02X", val))
 
 		case "x":
@@ -198,7 +285,7 @@ func (shell *interactiveShell) setCmd(cmd *shellCommand) {
 				shell.invalidArgs(cmd.command, args)
 				break
 			}
-			shell.cpu.Registers.X = uint8(val)
+			shell.setRegister(core.RegisterX, uint16(val))
 			shell.printInfo(cmd.command, fmt.Sprintf("Set x register to %02X", val))
 
 		case "y":
@@ -207,7 +294,7 @@ func (shell *interactiveShell) setCmd(cmd *shellCommand) {
 				shell.invalidArgs(cmd.command, args)
 				break
 			}
-			shell.cpu.Registers.Y = uint8(val)
+			shell.setRegister(core.RegisterY, uint16(val))
 			shell.printInfo(cmd.command, fmt.Sprintf("Set y register to %02X", val))
 
 		case "p":
@@ -216,7 +303,7 @@ func (shell *interactiveShell) setCmd(cmd *shellCommand) {
 				shell.invalidArgs(cmd.command, args)
 				break
 			}
-			shell.cpu.Registers.Status = uint8(val)
+			shell.setRegister(core.RegisterP, uint16(val))
 			shell.printInfo(cmd.command, fmt.Sprintf("Set status register to %02X", val))
 
 		case "pc":
@@ -225,7 +312,7 @@ func (shell *interactiveShell) setCmd(cmd *shellCommand) {
 				shell.invalidArgs(cmd.command, args)
 				break
 			}
-			shell.cpu.Registers.ProgramCounter = uint16(val)
+			shell.setRegister(core.RegisterPC, uint16(val))
 			shell.printInfo(cmd.command, fmt.Sprintf("Set program counter to %02X", val))
 
 		case "sp":
@@ -234,7 +321,7 @@ func (shell *interactiveShell) setCmd(cmd *shellCommand) {
 				shell.invalidArgs(cmd.command, args)
 				break
 			}
-			shell.cpu.Registers.StackPointer = uint8(val)
+			shell.setRegister(core.RegisterSP, uint16(val))
 			shell.printInfo(cmd.command, fmt.Sprintf("Set stack pointer to %02X", val))
 
 		default:
@@ -245,7 +332,7 @@ func (shell *interactiveShell) setCmd(cmd *shellCommand) {
 		switch args[0] {
 
 		case "bus":
-			addr, err := strconv.ParseUint(args[1], 16, 16)
+			addr, err := parseBusAddress(args[1])
 			if err != nil {
 				shell.invalidArgs(cmd.command, args)
 				break
@@ -255,8 +342,12 @@ func (shell *interactiveShell) setCmd(cmd *shellCommand) {
 				shell.invalidArgs(cmd.command, args)
 				break
 			}
-			shell.cpu.Bus.Write(uint16(addr), uint8(val))
-			shell.printInfo(cmd.command, fmt.Sprintf("Set bus address %04X to %02X", addr, val))
+			err = shell.writeBus(addr, uint8(val))
+			if err != nil {
+				shell.printError(cmd.command, err.Error())
+				break
+			}
+			shell.printInfo(cmd.command, fmt.Sprintf("Set bus address %s to %02X", addr, val))
 		default:
 			shell.invalidArgs(cmd.command, args)
 		}
@@ -267,6 +358,10 @@ func (shell *interactiveShell) setCmd(cmd *shellCommand) {
 
 func (shell *interactiveShell) loadCmd(cmd *shellCommand) {
 	args := cmd.args
+	if shell.recorder != nil {
+		shell.printError(cmd.command, "Files can not be loaded while recording.")
+		return
+	}
 	switch l := len(args); l {
 	case 1:
 		err := shell.loadFile(args[0])
@@ -292,9 +387,15 @@ func (shell *interactiveShell) loadFile(filename string) error {
 	if err != nil {
 		return err
 	}
-	shell.cpu.Bus = core.NewBasicBus()
-	for i := 0; i <= read; i++ {
-		shell.cpu.Bus.Write(uint16(i), bytes[i])
+	if shell.machine == nil {
+		shell.cpu.Bus = core.NewBasicBus()
+	}
+	for i := 0; i < read; i++ {
+		//machines keep their bus, so the file can hit ROM or devices
+		err = shell.cpu.Bus.Write(uint16(i), bytes[i])
+		if err != nil {
+			return err
+		}
 	}
 	shell.printInfo("load", fmt.Sprintf("Read %v bytes from file %v.\n", read, filename))
 	return nil
@@ -304,10 +405,10 @@ func (shell *interactiveShell) stepCmd(cmd *shellCommand) {
 	args := cmd.args
 	switch l := len(args); l {
 	case 0:
-		shell.cpu.Execute()
+		shell.execute(cmd.command)
 		shell.printInfo(cmd.command, "Executed 1 cpu cycle")
 	case 1:
-		steps, err := strconv.ParseUint(args[2], 10, 32)
+		steps, err := strconv.ParseUint(args[0], 10, 32)
 		if err != nil {
 			shell.invalidArgs(cmd.command, args)
 			break
@@ -316,33 +417,312 @@ func (shell *interactiveShell) stepCmd(cmd *shellCommand) {
 			shell.invalidArgs(cmd.command, args)
 			break
 		}
-		for i := uint64(0); i <= steps; i++ {
-			shell.cpu.Execute()
+		for i := uint64(0); i < steps; i++ {
+			shell.execute(cmd.command)
 		}
 	default:
 		shell.invalidArgs(cmd.command, args)
 	}
 }
 
+//execute executes one instruction, feeding the cpu the recorded inputs if a
+//replay is in progress
+func (shell *interactiveShell) execute(cmd string) {
+	if shell.replayer == nil {
+		if shell.machine == nil {
+			shell.cpu.Execute()
+			return
+		}
+		err := shell.machine.Step()
+		if err != nil {
+			shell.printError(cmd, err.Error())
+		}
+		return
+	}
+	err := shell.replayer.Execute()
+	if err != nil {
+		shell.printError(cmd, fmt.Sprintf("Replay failed: %v", err))
+		shell.endReplay()
+		return
+	}
+	if shell.replayer.Done() {
+		shell.printInfo(cmd, "Reached the end of the recording")
+		shell.endReplay()
+	}
+}
+
+//setRegister sets a cpu register, recording the change if a recording is in
+//progress
+func (shell *interactiveShell) setRegister(name string, val uint16) {
+	if shell.recorder != nil {
+		shell.recorder.SetRegister(name, val)
+		return
+	}
+	shell.cpu.Registers.Set(name, val)
+}
+
+//writeBus writes to the bus, recording the write if a recording is in
+//progress. Banks that are not mapped in are written to directly, which can
+//not be recorded.
+func (shell *interactiveShell) writeBus(addr busAddress, val uint8) error {
+	if !addr.banked {
+		if shell.recorder != nil {
+			return shell.recorder.Write(addr.addr, val)
+		}
+		return shell.cpu.Bus.Write(addr.addr, val)
+	}
+	if shell.recorder != nil {
+		return fmt.Errorf("banks can not be written to while recording")
+	}
+	banked, ok := shell.cpu.Bus.(core.BankedBus)
+	if !ok {
+		return fmt.Errorf("the bus has no banks")
+	}
+	return banked.WriteBank(addr.bank, addr.addr, val)
+}
+
+func (shell *interactiveShell) signalCmd(cmd *shellCommand) {
+	if len(cmd.args) != 0 {
+		shell.invalidArgs(cmd.command, cmd.args)
+		return
+	}
+	switch cmd.command {
+	case "irq":
+		if shell.recorder != nil {
+			shell.recorder.Interrupt()
+		} else {
+			shell.cpu.Interrupt()
+		}
+		shell.printInfo(cmd.command, "Sent an interrupt")
+	case "nmi":
+		if shell.recorder != nil {
+			shell.recorder.NMInterrupt()
+		} else {
+			shell.cpu.NMInterrupt()
+		}
+		shell.printInfo(cmd.command, "Sent a non-maskable interrupt")
+	case "reset":
+		if shell.recorder != nil {
+			shell.recorder.Reset()
+		} else if shell.machine != nil {
+			shell.machine.Reset()
+		} else {
+			shell.cpu.Reset()
+		}
+		shell.printInfo(cmd.command, "Reset the cpu")
+	}
+}
+
+func (shell *interactiveShell) recordCmd(cmd *shellCommand) {
+	args := cmd.args
+	if len(args) != 1 {
+		shell.invalidArgs(cmd.command, args)
+		return
+	}
+	if args[0] != "stop" {
+		if shell.recorder != nil {
+			shell.printError(cmd.command, fmt.Sprintf("Already recording to file %v.", shell.recordFile))
+			return
+		}
+		if shell.machine != nil {
+			//the state of a machine can not be saved, so it is recorded
+			//from reset as it was built
+			err := shell.newCPU()
+			if err != nil {
+				shell.printError(cmd.command, fmt.Sprintf("Could not build the machine: %v", err))
+				return
+			}
+			shell.recorder = shell.machine.Record()
+		} else {
+			var err error
+			shell.recorder, err = core.NewRecorder(shell.cpu)
+			if err != nil {
+				shell.printError(cmd.command, err.Error())
+				return
+			}
+		}
+		shell.recordFile = args[0]
+		shell.printInfo(cmd.command, fmt.Sprintf("Recording to file %v.", args[0]))
+		return
+	}
+	if shell.recorder == nil {
+		shell.printError(cmd.command, "Not recording.")
+		return
+	}
+	err := shell.saveRecording()
+	if err != nil {
+		shell.printError(cmd.command, fmt.Sprintf("Could not save file %v: %v", shell.recordFile, err))
+		return
+	}
+	shell.printInfo(cmd.command, fmt.Sprintf("Saved recording to file %v.", shell.recordFile))
+	shell.recorder = nil
+	if shell.machine != nil {
+		shell.machine.Live()
+	}
+}
+
+func (shell *interactiveShell) saveRecording() error {
+	file, err := os.Create(shell.recordFile)
+	if err != nil {
+		return err
+	}
+	err = shell.recorder.Recording().Save(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (shell *interactiveShell) replayCmd(cmd *shellCommand) {
+	args := cmd.args
+	if len(args) != 1 && len(args) != 2 {
+		shell.invalidArgs(cmd.command, args)
+		return
+	}
+	if shell.recorder != nil {
+		shell.printError(cmd.command, "Recordings can not be replayed while recording.")
+		return
+	}
+	steps := uint64(math.MaxUint64)
+	if len(args) == 2 {
+		n, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			shell.invalidArgs(cmd.command, args)
+			return
+		}
+		steps = n
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		shell.printError(cmd.command, fmt.Sprintf("Could not load file %v.", args[0]))
+		return
+	}
+	defer file.Close()
+	rec, err := core.LoadRecording(file)
+	if err != nil {
+		shell.printError(cmd.command, fmt.Sprintf("Could not load file %v: %v", args[0], err))
+		return
+	}
+	err = shell.newCPU()
+	if err != nil {
+		shell.printError(cmd.command, fmt.Sprintf("Could not build the machine: %v", err))
+		return
+	}
+	if shell.machine != nil {
+		shell.replayer, err = shell.machine.Replay(rec)
+	} else {
+		shell.replayer, err = core.NewReplayer(shell.cpu, rec)
+	}
+	if err == nil {
+		err = shell.replayer.Run(steps)
+	}
+	if err != nil {
+		shell.printError(cmd.command, fmt.Sprintf("Replay failed: %v", err))
+		shell.endReplay()
+		return
+	}
+	shell.printInfo(cmd.command, fmt.Sprintf("Replayed %v instructions.", shell.cpu.Instructions()))
+	if shell.replayer.Done() {
+		shell.endReplay()
+	}
+}
+
+//endReplay goes back to running the cpu, or the machine with live input
+func (shell *interactiveShell) endReplay() {
+	shell.replayer = nil
+	if shell.machine != nil {
+		shell.machine.Live()
+	}
+}
+
+func (shell *interactiveShell) lcdCmd(cmd *shellCommand) {
+	if len(cmd.args) > 1 {
+		shell.invalidArgs(cmd.command, cmd.args)
+		return
+	}
+	if shell.machine == nil {
+		shell.printError(cmd.command, "The debugger was started without a machine config.")
+		return
+	}
+	found := false
+	for _, name := range shell.machine.Devices() {
+		lcd, ok := shell.machine.Device(name).(*devices.LCD)
+		if !ok || len(cmd.args) == 1 && !strings.EqualFold(name, cmd.args[0]) {
+			continue
+		}
+		found = true
+		lines := lcd.Lines()
+		border := "+" + strings.Repeat("-", len([]rune(lines[0]))) + "+"
+		fmt.Println(name)
+		fmt.Println(border)
+		for _, line := range lines {
+			fmt.Printf("|%s|\n", line)
+		}
+		fmt.Println(border)
+	}
+	if !found {
+		shell.printError(cmd.command, "The machine has no such LCD.")
+	}
+}
+
+//newCPU replaces the cpu with a fresh one. With a machine config, the whole
+//machine is rebuilt from it.
+func (shell *interactiveShell) newCPU() error {
+	if shell.options.machineFile == "" {
+		shell.cpu = core.NewCPU(core.NewBasicBus(), core.NewCPURegisters())
+		return nil
+	}
+	if shell.machine != nil {
+		shell.machine.Close()
+		shell.machine = nil
+	}
+	m, err := machine.Load(shell.options.machineFile)
+	if err != nil {
+		return err
+	}
+	shell.machine = m
+	shell.cpu = m.CPU
+	shell.printSerialPorts()
+	return nil
+}
+
+//printSerialPorts tells where the serial ports of the machine can be reached
+func (shell *interactiveShell) printSerialPorts() {
+	for _, name := range shell.machine.Devices() {
+		acia, ok := shell.machine.Device(name).(*devices.ACIA)
+		if !ok {
+			continue
+		}
+		if host := acia.HostName(); host != "" {
+			fmt.Printf("Serial port %s is on %s\n", name, host)
+		}
+	}
+}
+
 func newInteractiveShell(opt *shellOptions) (*interactiveShell, error) {
-	bus := core.NewBasicBus()
-	shell := interactiveShell{}
+	shell := interactiveShell{options: opt}
+	err := shell.newCPU()
+	if err != nil {
+		return nil, err
+	}
 	if opt.loadBinaryFile {
 		err := shell.loadFile(opt.binaryFileName)
 		if err != nil {
 			return nil, err
 		}
 	}
-	registers := core.NewCPURegisters()
-	cpu := core.NewCPU(bus, registers)
-	shell.cpu = cpu
-	shell.options = opt
 	return &shell, nil
 }
 
 func (shell *interactiveShell) run() error {
 	fmt.Printf("Interactive 65c02 debugger shell.\n")
 	reader := bufio.NewReader(os.Stdin)
+	defer func() {
+		if shell.machine != nil {
+			shell.machine.Close()
+		}
+	}()
 	exit := false
 	for !exit {
 		fmt.Printf(">")
@@ -0,0 +1,418 @@
+package devices
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+func init() {
+	machine.RegisterDevice("acia", newACIADevice)
+}
+
+//aciaOptions are the options of an ACIA in a machine config
+type aciaOptions struct {
+	//Host is what the serial port is connected to: "terminal", "pty", "tcp"
+	//or nothing
+	Host        string `json:"host"`
+	Address     string `json:"address"` //TCP address to listen on
+	WDCBug      bool   `json:"wdc_bug"`
+	FlowControl bool   `json:"flow_control"`
+}
+
+func newACIADevice(options machine.Options) (machine.Device, error) {
+	opt := aciaOptions{Address: DefaultSerialAddress, WDCBug: true}
+	err := options.Decode(&opt)
+	if err != nil {
+		return nil, err
+	}
+	a := NewACIA()
+	a.WDCBug = opt.WDCBug
+	a.FlowControl = opt.FlowControl
+	var host io.ReadWriteCloser
+	switch opt.Host {
+	case "":
+		return a, nil
+	case "terminal":
+		host, err = OpenTerminal()
+	case "pty":
+		host, err = OpenPTY()
+	case "tcp":
+		host, err = ListenTCP(opt.Address)
+	default:
+		return nil, fmt.Errorf("unknown serial host %q", opt.Host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.Connect(host)
+	return a, nil
+}
+
+//Registers of the ACIA
+const (
+	AciaData    = 0x0 //transmit data on writes, received data on reads
+	AciaStatus  = 0x1 //status on reads, programmed reset on writes
+	AciaCommand = 0x2
+	AciaControl = 0x3
+)
+
+//Bits of the status register
+const (
+	AciaParityError  uint8 = 0x01
+	AciaFramingError uint8 = 0x02
+	AciaOverrun      uint8 = 0x04
+	AciaRDRF         uint8 = 0x08 //receiver data register full
+	AciaTDRE         uint8 = 0x10 //transmitter data register empty
+	AciaDCD          uint8 = 0x20
+	AciaDSR          uint8 = 0x40
+	AciaIRQ          uint8 = 0x80
+)
+
+//Bits of the command register
+const (
+	aciaDTR         uint8 = 0x01 //enables the receiver and interrupts
+	aciaIRD         uint8 = 0x02 //disables receiver interrupts
+	aciaTIC         uint8 = 0x0c //transmitter interrupt control
+	aciaTxInterrupt uint8 = 0x04
+	aciaEcho        uint8 = 0x10
+	aciaParity      uint8 = 0x20
+)
+
+//aciaBaudRates are the baud rates selected by the low bits of the control
+//register. Rate 0 is the external clock, which boards run at 16 times 115200
+//baud with a 1.8432MHz crystal.
+var aciaBaudRates = [16]uint64{115200, 50, 75, 110, 135, 150, 300, 600, 1200, 1800, 2400, 3600, 4800, 7200, 9600, 19200}
+
+//ACIA is a W65C51 asynchronous communications interface adapter. Bytes are
+//sent and received at the baud rate, word length, parity and stop bits set in
+//the control and command registers, and the ACIA interrupts the cpu when a
+//byte arrives and, on chips without the WDC bug, when the transmitter is
+//empty. It takes up 4 addresses.
+//
+//The host side of the serial line is any io.ReadWriter, like a terminal, a
+//pseudo terminal or a TCP connection. The ACIA has to be added to a machine
+//to send and receive.
+type ACIA struct {
+	//WDCBug makes the ACIA behave like the W65C51N, which is set by NewACIA.
+	//Its transmitter data register empty bit is stuck at 1 and there is no
+	//transmitter interrupt. Writing a byte while another one is being sent
+	//garbles the one being sent, so firmware has to wait a character time
+	//between bytes. Without it, the ACIA behaves like the original 6551.
+	WDCBug bool
+	//FlowControl holds received bytes back until the cpu read the previous
+	//one. Without it, bytes that arrive before the previous one was read are
+	//lost and set the overrun bit, like on a serial line without flow
+	//control.
+	FlowControl bool
+	//Err is the first error writing to the host
+	Err error
+
+	rdr     uint8
+	status  uint8
+	command uint8
+	control uint8
+
+	txShift   bool  //a byte is being sent
+	txByte    uint8 //byte being sent
+	tdr       uint8 //byte waiting to be sent
+	tdrFull   bool
+	txEvent   *machine.Event
+	rxEvent   *machine.Event
+	interrupt bool //an interrupt condition was raised since status was read
+
+	input []uint8 //bytes received from the host and not taken yet
+
+	mu     sync.Mutex
+	output io.Writer
+	host   io.Closer
+	reader io.Reader      //host, read once the ACIA is added to a machine
+	in     *machine.Input //input from the host, through the machine
+
+	m   *machine.Machine
+	irq *machine.Line
+}
+
+//NewACIA returns a W65C51 that is not connected to a host
+func NewACIA() *ACIA {
+	a := &ACIA{WDCBug: true}
+	a.reset()
+	return a
+}
+
+//Connect connects the serial line to host. Bytes read from host are received
+//by the ACIA and bytes it sends are written to host. Once the ACIA is added
+//to a machine, a goroutine reads from host until it fails, and the bytes go
+//through the input of the machine so they can be recorded. If host
+//implements io.Closer, it is closed by Close.
+func (a *ACIA) Connect(host io.ReadWriter) {
+	a.mu.Lock()
+	a.output = host
+	a.host, _ = host.(io.Closer)
+	a.reader = host
+	in := a.in
+	a.mu.Unlock()
+	if in != nil {
+		go readHost(host, in)
+	}
+}
+
+//HostName returns where the host side of the serial line can be reached:
+//the path of a pseudo terminal or the address a TCP host listens on. It is
+//empty for other hosts.
+func (a *ACIA) HostName() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch host := a.output.(type) {
+	case *PTY:
+		return host.Name()
+	case *TCPSerial:
+		return host.Addr().String()
+	}
+	return ""
+}
+
+//Receive queues bytes as if they came in from the host. Like the registers,
+//it has to be called from the goroutine running the machine. Only bytes read
+//from the connected host are recorded.
+func (a *ACIA) Receive(data ...uint8) {
+	a.input = append(a.input, data...)
+	a.updateReceiver()
+}
+
+//Close closes the host the ACIA is connected to
+func (a *ACIA) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.host == nil {
+		return nil
+	}
+	err := a.host.Close()
+	a.host = nil
+	a.output = nil
+	return err
+}
+
+//Size returns the number of addresses the ACIA takes up
+func (a *ACIA) Size() int {
+	return 4
+}
+
+func (a *ACIA) Attach(m *machine.Machine) error {
+	a.m = m
+	a.irq = m.IRQ()
+	a.mu.Lock()
+	a.in = m.Input(func(val uint8) { a.Receive(val) })
+	reader := a.reader
+	a.mu.Unlock()
+	if reader != nil {
+		go readHost(reader, a.in)
+	}
+	return nil
+}
+
+//Reset puts the ACIA into its hardware reset state
+func (a *ACIA) Reset() {
+	a.reset()
+}
+
+func (a *ACIA) reset() {
+	a.status = AciaTDRE
+	a.command = aciaIRD
+	a.control = 0
+	a.txShift = false
+	a.tdrFull = false
+	a.interrupt = false
+	a.cancel(&a.txEvent)
+	a.cancel(&a.rxEvent)
+	a.updateIRQ()
+}
+
+func (a *ACIA) cancel(event **machine.Event) {
+	if *event != nil {
+		(*event).Cancel()
+		*event = nil
+	}
+}
+
+//charCycles returns the number of cpu cycles it takes to send one character.
+//It is at least one cycle, even if the cpu clock is slower than the line.
+func (a *ACIA) charCycles() uint64 {
+	data := 8 - uint64(a.control>>5&0x3)
+	bits := 1 + data + 1 //start, data and stop bits
+	if a.command&aciaParity != 0 {
+		bits++
+	}
+	if a.control&0x80 != 0 {
+		bits++
+	}
+	cycles := bits * a.m.Clock / aciaBaudRates[a.control&0xf]
+	if cycles == 0 {
+		return 1
+	}
+	return cycles
+}
+
+//updateIRQ drives the IRQ line while an interrupt condition is pending
+func (a *ACIA) updateIRQ() {
+	if a.irq != nil {
+		a.irq.Set(a.interrupt)
+	}
+}
+
+//raise raises an interrupt if interrupts are enabled
+func (a *ACIA) raise() {
+	if a.command&aciaDTR == 0 {
+		return
+	}
+	a.interrupt = true
+	a.updateIRQ()
+}
+
+func (a *ACIA) Read(addr uint16) uint8 {
+	switch addr & 0x3 {
+	case AciaData:
+		a.status &^= AciaRDRF | AciaOverrun | AciaFramingError | AciaParityError
+		//with flow control, the next byte is sent once this one was read
+		a.updateReceiver()
+		return a.rdr
+	case AciaStatus:
+		status := a.status
+		if a.WDCBug {
+			status |= AciaTDRE
+		}
+		if a.interrupt {
+			status |= AciaIRQ
+		}
+		a.interrupt = false
+		a.updateIRQ()
+		return status
+	case AciaCommand:
+		return a.command
+	default: //AciaControl
+		return a.control
+	}
+}
+
+func (a *ACIA) Write(addr uint16, val uint8) error {
+	switch addr & 0x3 {
+	case AciaData:
+		a.transmit(val)
+	case AciaStatus:
+		//programmed reset
+		a.command = a.command&0xe0 | aciaIRD
+		a.status &^= AciaOverrun
+		a.updateReceiver()
+	case AciaCommand:
+		a.command = val
+		a.updateReceiver()
+	default: //AciaControl
+		a.control = val
+	}
+	return nil
+}
+
+//transmit starts sending a byte
+func (a *ACIA) transmit(val uint8) {
+	if a.m == nil {
+		return
+	}
+	if a.WDCBug {
+		//the byte goes straight into the shift register and garbles the
+		//one that was being sent
+		a.txByte = val
+		a.txShift = true
+		a.cancel(&a.txEvent)
+		a.txEvent = a.m.After(a.charCycles(), a.transmitted)
+		return
+	}
+	if a.txShift {
+		a.tdr = val
+		a.tdrFull = true
+		a.status &^= AciaTDRE
+		return
+	}
+	a.txByte = val
+	a.txShift = true
+	a.txEvent = a.m.After(a.charCycles(), a.transmitted)
+	a.transmitterEmpty()
+}
+
+//transmitted is called when the last stop bit of a byte was sent
+func (a *ACIA) transmitted() {
+	a.txEvent = nil
+	a.txShift = false
+	a.send(a.txByte)
+	if a.tdrFull {
+		a.tdrFull = false
+		a.txByte = a.tdr
+		a.txShift = true
+		a.txEvent = a.m.After(a.charCycles(), a.transmitted)
+		a.transmitterEmpty()
+	}
+}
+
+//transmitterEmpty sets TDRE and raises the transmitter interrupt if it is
+//enabled
+func (a *ACIA) transmitterEmpty() {
+	a.status |= AciaTDRE
+	if !a.WDCBug && a.command&aciaTIC == aciaTxInterrupt {
+		a.raise()
+	}
+}
+
+//send writes a byte to the host
+func (a *ACIA) send(val uint8) {
+	a.mu.Lock()
+	output := a.output
+	a.mu.Unlock()
+	if output == nil {
+		return
+	}
+	_, err := output.Write([]uint8{val})
+	if err != nil && a.Err == nil {
+		a.Err = err
+	}
+}
+
+//updateReceiver schedules the next byte to arrive a character time from now
+//if the receiver is enabled and a byte is waiting, and cancels it when the
+//receiver is disabled. An idle serial line schedules nothing.
+func (a *ACIA) updateReceiver() {
+	if a.m == nil {
+		return
+	}
+	if a.command&aciaDTR == 0 {
+		a.cancel(&a.rxEvent)
+		return
+	}
+	if a.rxEvent != nil || len(a.input) == 0 {
+		return
+	}
+	if a.FlowControl && a.status&AciaRDRF != 0 {
+		return
+	}
+	a.rxEvent = a.m.After(a.charCycles(), a.receive)
+}
+
+//receive takes the next byte from the host
+func (a *ACIA) receive() {
+	a.rxEvent = nil
+	val := a.input[0]
+	a.input = a.input[1:]
+	defer a.updateReceiver()
+	if a.status&AciaRDRF != 0 {
+		a.status |= AciaOverrun
+		return
+	}
+	a.rdr = val
+	a.status |= AciaRDRF
+	if a.command&aciaEcho != 0 {
+		a.send(val)
+	}
+	if a.command&aciaIRD == 0 {
+		a.raise()
+	}
+}
@@ -0,0 +1,167 @@
+package devices
+
+import (
+	"io"
+	"testing"
+
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+//serialHost is the host side of a serial line that records what it is sent
+//and never sends anything
+type serialHost struct {
+	sent []uint8
+}
+
+func (h *serialHost) Read(p []uint8) (int, error) {
+	return 0, io.EOF
+}
+
+func (h *serialHost) Write(p []uint8) (int, error) {
+	h.sent = append(h.sent, p...)
+	return len(p), nil
+}
+
+func TestACIATransmit(t *testing.T) {
+	m := newTestMachine(t)
+	a := NewACIA()
+	a.WDCBug = false
+	host := &serialHost{}
+	a.Connect(host)
+	addDevice(t, m, "acia", a)
+	//9600 baud, 8 data bits and 1 stop bit take 1041 cycles per byte
+	a.Write(AciaControl, 0x1e)
+	a.Write(AciaCommand, 0x0b)
+	a.Write(AciaData, 'h')
+	a.Write(AciaData, 'i')
+	if a.Read(AciaStatus)&AciaTDRE != 0 {
+		t.Fatal("TDRE is set while a byte waits to be sent")
+	}
+	m.Run(1000)
+	if len(host.sent) != 0 {
+		t.Fatalf("sent %q before the end of the first character", host.sent)
+	}
+	m.Run(100)
+	if string(host.sent) != "h" || a.Read(AciaStatus)&AciaTDRE == 0 {
+		t.Fatalf("sent %q after one character time, want \"h\" and TDRE", host.sent)
+	}
+	m.Run(1041)
+	if string(host.sent) != "hi" {
+		t.Fatalf("sent %q after two character times, want \"hi\"", host.sent)
+	}
+}
+
+func TestACIAReceive(t *testing.T) {
+	m := newTestMachine(t)
+	a := NewACIA()
+	addDevice(t, m, "acia", a)
+	a.Write(AciaControl, 0x1e)
+	//receiver and receiver interrupts enabled
+	a.Write(AciaCommand, 0x09)
+	a.Receive('x', 'y', 'z')
+	m.Run(1100)
+	if !m.IRQAsserted() {
+		t.Fatal("the ACIA did not interrupt after receiving a byte")
+	}
+	status := a.Read(AciaStatus)
+	if status&(AciaIRQ|AciaRDRF) != AciaIRQ|AciaRDRF || m.IRQAsserted() {
+		t.Fatalf("status is %#02x, want IRQ and RDRF, and reading it releases IRQ", status)
+	}
+	if val := a.Read(AciaData); val != 'x' {
+		t.Fatalf("received %q, want 'x'", val)
+	}
+	//without flow control, bytes that are not read in time are lost
+	m.Run(2100)
+	if status := a.Read(AciaStatus); status&AciaOverrun == 0 {
+		t.Fatalf("status is %#02x, want an overrun", status)
+	}
+	if val := a.Read(AciaData); val != 'y' {
+		t.Fatalf("received %q, want 'y'", val)
+	}
+}
+
+func TestACIALineFasterThanClock(t *testing.T) {
+	m := newTestMachine(t)
+	m.Clock = 1000
+	a := NewACIA()
+	addDevice(t, m, "acia", a)
+	a.Write(AciaControl, 0x10)
+	a.Write(AciaCommand, 0x09)
+	a.Receive('x')
+	m.Run(10)
+	if val := a.Read(AciaData); val != 'x' {
+		t.Fatalf("received %q, want 'x'", val)
+	}
+}
+
+//wakeups steps a machine with a stopped cpu from event to event until cycle
+//end and returns the number of times events woke it up
+func wakeups(t *testing.T, m *machine.Machine, end uint64) int {
+	n := 0
+	for m.Now() < end {
+		now := m.Now()
+		err := m.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Now() == now {
+			//nothing is scheduled
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func TestACIAIdleLine(t *testing.T) {
+	tests := []struct {
+		name        string
+		flowControl bool
+		received    int //bytes received before the first one is read
+		afterRead   int //bytes received after it was read
+	}{
+		{"overrun", false, 3, 0},
+		{"flow control", true, 1, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestMachine(t)
+			a := NewACIA()
+			a.FlowControl = test.flowControl
+			addDevice(t, m, "acia", a)
+			a.Write(AciaControl, 0x1e)
+			a.Write(AciaCommand, 0x09)
+			//an idle line with the receiver enabled wakes nobody
+			if n := wakeups(t, m, m.Now()+100*1041); n != 0 {
+				t.Fatalf("idle line woke the machine %d times", n)
+			}
+			a.Receive('x', 'y', 'z')
+			if n := wakeups(t, m, m.Now()+100*1041); n != test.received {
+				t.Fatalf("receiving 3 bytes woke the machine %d times, want %d", n, test.received)
+			}
+			a.Read(AciaData)
+			if n := wakeups(t, m, m.Now()+100*1041); n != test.afterRead {
+				t.Fatalf("reading the first byte woke the machine %d times, want %d", n, test.afterRead)
+			}
+		})
+	}
+}
+
+func TestACIAHostName(t *testing.T) {
+	a := NewACIA()
+	if name := a.HostName(); name != "" {
+		t.Fatalf("unconnected ACIA is on %q", name)
+	}
+	tcp, err := ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	a.Connect(tcp)
+	if name := a.HostName(); name != tcp.Addr().String() {
+		t.Fatalf("ACIA is on %q, want %q", name, tcp.Addr())
+	}
+	a.Close()
+	if name := a.HostName(); name != "" {
+		t.Fatalf("closed ACIA is on %q", name)
+	}
+}
@@ -0,0 +1,310 @@
+package devices
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+func init() {
+	machine.RegisterDevice("block", newBlockDevice)
+}
+
+//blockOptions are the options of a block device in a machine config
+type blockOptions struct {
+	Image      string `json:"image"`
+	SectorSize int    `json:"sector_size"`
+	ReadOnly   bool   `json:"read_only"`
+	Latency    uint64 `json:"latency"` //cycles a command takes
+}
+
+func newBlockDevice(options machine.Options) (machine.Device, error) {
+	opt := blockOptions{SectorSize: DefaultSectorSize}
+	err := options.Decode(&opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Image == "" {
+		return nil, fmt.Errorf("a block device needs an image")
+	}
+	b, err := OpenBlockDevice(options.Path(opt.Image), opt.SectorSize, opt.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	b.Latency = opt.Latency
+	return b, nil
+}
+
+//DefaultSectorSize is the sector size of block devices in machine configs
+const DefaultSectorSize = 512
+
+//Registers of the block device. Multibyte registers are low byte first.
+const (
+	BlockLBA        = 0x00 //sector number, 4 bytes
+	BlockDMA        = 0x04 //address sectors are transferred to and from, 2 bytes
+	BlockCommand    = 0x06 //writing a command starts it
+	BlockStatus     = 0x07 //status bits, written 1 to clear BlockDone and BlockError
+	BlockControl    = 0x08
+	BlockData       = 0x09 //data port
+	BlockSectorSize = 0x0a //2 bytes, read only
+	BlockSectors    = 0x0c //sectors in the image, 4 bytes, read only
+)
+
+//Commands of the block device
+const (
+	BlockRead  uint8 = 0x01
+	BlockWrite uint8 = 0x02
+	BlockFlush uint8 = 0x03
+)
+
+//Bits of the status register
+const (
+	BlockBusy  uint8 = 0x01
+	BlockError uint8 = 0x02
+	BlockDone  uint8 = 0x80 //a command finished, interrupts if enabled
+)
+
+//Bits of the control register
+const (
+	BlockIRQEnable uint8 = 0x01
+	BlockDMAEnable uint8 = 0x02 //transfer through memory instead of the data port
+)
+
+//BlockImage is the storage behind a block device
+type BlockImage interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+//BlockDevice is a paravirtual block storage controller, which transfers
+//whole sectors of a disk image without emulating a real disk controller.
+//Sectors are transferred through memory with DMA, or through the data port,
+//which reads and writes the sector buffer one byte at a time. The position in
+//the sector buffer goes back to the start when a command finishes or the
+//control register is written. It takes up 16 addresses.
+//
+//Commands finish right away, or after Latency cycles, while BlockBusy is
+//set. The device has to be added to a machine for DMA and latency.
+type BlockDevice struct {
+	//Latency is the number of cycles a command takes
+	Latency uint64
+
+	image      BlockImage
+	sectors    uint32
+	sectorSize int
+	buffer     []uint8
+	pos        int //position in the buffer of the data port
+
+	lba     uint32
+	dma     uint16
+	status  uint8
+	control uint8
+	event   *machine.Event
+
+	m   *machine.Machine
+	irq *machine.Line
+}
+
+//NewBlockDevice returns a block device on an image of size bytes, split into
+//sectors of sectorSize bytes
+func NewBlockDevice(image BlockImage, size int64, sectorSize int) (*BlockDevice, error) {
+	if sectorSize < 1 || sectorSize > 0xffff {
+		return nil, fmt.Errorf("sector size %d is not between 1 and 65535", sectorSize)
+	}
+	sectors := size / int64(sectorSize)
+	if sectors > 0xffffffff {
+		return nil, fmt.Errorf("image has more than 2^32 sectors")
+	}
+	return &BlockDevice{
+		image:      image,
+		sectors:    uint32(sectors),
+		sectorSize: sectorSize,
+		buffer:     make([]uint8, sectorSize),
+	}, nil
+}
+
+//OpenBlockDevice returns a block device on an image file. Bytes after the
+//last whole sector are not used.
+func OpenBlockDevice(path string, sectorSize int, readOnly bool) (*BlockDevice, error) {
+	flag := os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	b, err := NewBlockDevice(file, info.Size(), sectorSize)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+//Close closes the image if it implements io.Closer
+func (b *BlockDevice) Close() error {
+	if closer, ok := b.image.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+//Size returns the number of addresses the block device takes up
+func (b *BlockDevice) Size() int {
+	return 16
+}
+
+func (b *BlockDevice) Attach(m *machine.Machine) error {
+	b.m = m
+	b.irq = m.IRQ()
+	return nil
+}
+
+//Reset clears the registers. A command in progress is abandoned.
+func (b *BlockDevice) Reset() {
+	if b.event != nil {
+		b.event.Cancel()
+		b.event = nil
+	}
+	b.lba = 0
+	b.dma = 0
+	b.status = 0
+	b.control = 0
+	b.pos = 0
+	b.update()
+}
+
+//update drives the IRQ line
+func (b *BlockDevice) update() {
+	if b.irq != nil {
+		b.irq.Set(b.status&BlockDone != 0 && b.control&BlockIRQEnable != 0)
+	}
+}
+
+//start starts a command
+func (b *BlockDevice) start(command uint8) {
+	if b.status&BlockBusy != 0 {
+		return
+	}
+	b.status = b.status&^(BlockDone|BlockError) | BlockBusy
+	if b.Latency == 0 || b.m == nil {
+		b.execute(command)
+		return
+	}
+	b.event = b.m.After(b.Latency, func() {
+		b.event = nil
+		b.execute(command)
+	})
+}
+
+//execute carries out a command and finishes it
+func (b *BlockDevice) execute(command uint8) {
+	var err error
+	switch command {
+	case BlockRead:
+		err = b.transfer(false)
+	case BlockWrite:
+		err = b.transfer(true)
+	case BlockFlush:
+		if syncer, ok := b.image.(interface{ Sync() error }); ok {
+			err = syncer.Sync()
+		}
+	default:
+		err = fmt.Errorf("unknown command %#02x", command)
+	}
+	b.status &^= BlockBusy
+	b.status |= BlockDone
+	if err != nil {
+		b.status |= BlockError
+	}
+	b.pos = 0
+	b.update()
+}
+
+//transfer reads or writes the sector at the LBA
+func (b *BlockDevice) transfer(write bool) error {
+	if b.lba >= b.sectors {
+		return fmt.Errorf("sector %d is past the end of the image", b.lba)
+	}
+	dma := b.control&BlockDMAEnable != 0 && b.m != nil
+	offset := int64(b.lba) * int64(b.sectorSize)
+	if !write {
+		_, err := b.image.ReadAt(b.buffer, offset)
+		if err != nil {
+			return err
+		}
+		if !dma {
+			return nil
+		}
+		for i, val := range b.buffer {
+			err = b.m.Bus.Write(b.dma+uint16(i), val)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if dma {
+		for i := range b.buffer {
+			b.buffer[i] = b.m.Bus.Read(b.dma + uint16(i))
+		}
+	}
+	_, err := b.image.WriteAt(b.buffer, offset)
+	return err
+}
+
+func (b *BlockDevice) Read(addr uint16) uint8 {
+	addr &= 0xf
+	switch {
+	case addr < BlockDMA:
+		return uint8(b.lba >> (8 * addr))
+	case addr < BlockCommand:
+		return uint8(b.dma >> (8 * (addr - BlockDMA)))
+	case addr == BlockStatus:
+		return b.status
+	case addr == BlockControl:
+		return b.control
+	case addr == BlockData:
+		val := b.buffer[b.pos]
+		b.pos = (b.pos + 1) % b.sectorSize
+		return val
+	case addr == BlockSectorSize, addr == BlockSectorSize+1:
+		return uint8(b.sectorSize >> (8 * (addr - BlockSectorSize)))
+	case addr >= BlockSectors:
+		return uint8(b.sectors >> (8 * (addr - BlockSectors)))
+	}
+	return 0
+}
+
+func (b *BlockDevice) Write(addr uint16, val uint8) error {
+	addr &= 0xf
+	switch {
+	case addr < BlockDMA:
+		shift := 8 * addr
+		b.lba = b.lba&^(0xff<<shift) | uint32(val)<<shift
+	case addr < BlockCommand:
+		shift := 8 * (addr - BlockDMA)
+		b.dma = b.dma&^(0xff<<shift) | uint16(val)<<shift
+	case addr == BlockCommand:
+		b.start(val)
+	case addr == BlockStatus:
+		b.status &^= val & (BlockDone | BlockError)
+		b.update()
+	case addr == BlockControl:
+		b.control = val & (BlockIRQEnable | BlockDMAEnable)
+		b.pos = 0
+		b.update()
+	case addr == BlockData:
+		b.buffer[b.pos] = val
+		b.pos = (b.pos + 1) % b.sectorSize
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/rdzhaafar/emu6502/core"
+)
+
+//memoryImage is a disk image in memory
+type memoryImage []uint8
+
+func (img memoryImage) ReadAt(p []uint8, off int64) (int, error) {
+	return copy(p, img[off:]), nil
+}
+
+func (img memoryImage) WriteAt(p []uint8, off int64) (int, error) {
+	return copy(img[off:], p), nil
+}
+
+//newTestBlockDevice returns a block device with 4 sectors of 16 bytes
+func newTestBlockDevice(t *testing.T) (*BlockDevice, memoryImage) {
+	img := make(memoryImage, 64)
+	b, err := NewBlockDevice(img, int64(len(img)), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, img
+}
+
+//blockCommand sets the LBA and the DMA address of b and runs command
+func blockCommand(b *BlockDevice, command uint8, lba uint8, dma uint16) {
+	b.Write(BlockLBA, lba)
+	b.Write(BlockDMA, uint8(dma))
+	b.Write(BlockDMA+1, uint8(dma>>8))
+	b.Write(BlockCommand, command)
+}
+
+func TestBlockDeviceTransfers(t *testing.T) {
+	m := newTestMachine(t)
+	b, img := newTestBlockDevice(t)
+	addDevice(t, m, "block", b)
+	//write sector 2 through the data port
+	for i := 0; i < 16; i++ {
+		b.Write(BlockData, uint8(i+1))
+	}
+	b.Write(BlockControl, BlockIRQEnable)
+	blockCommand(b, BlockWrite, 2, 0)
+	if b.Read(BlockStatus) != BlockDone || !m.IRQAsserted() {
+		t.Fatalf("status is %#02x after a write, want done", b.Read(BlockStatus))
+	}
+	if img[32] != 1 || img[47] != 16 {
+		t.Fatalf("sector 2 of the image is % x", img[32:48])
+	}
+	b.Write(BlockStatus, BlockDone)
+	if m.IRQAsserted() {
+		t.Fatal("clearing done did not release IRQ")
+	}
+	//read it back into memory with DMA
+	b.Write(BlockControl, BlockDMAEnable)
+	blockCommand(b, BlockRead, 2, 0x1000)
+	for i := uint16(0); i < 16; i++ {
+		if val := m.Bus.Read(0x1000 + i); val != uint8(i+1) {
+			t.Fatalf("%#04x is %#02x after the DMA read, want %#02x", 0x1000+i, val, i+1)
+		}
+	}
+	//sectors past the end of the image fail
+	blockCommand(b, BlockRead, 4, 0x1000)
+	if b.Read(BlockStatus) != BlockDone|BlockError {
+		t.Fatalf("status is %#02x after reading past the end, want done and error", b.Read(BlockStatus))
+	}
+}
+
+func TestBlockDeviceLatency(t *testing.T) {
+	m := newTestMachine(t)
+	b, _ := newTestBlockDevice(t)
+	b.Latency = 100
+	addDevice(t, m, "block", b)
+	blockCommand(b, BlockRead, 0, 0)
+	m.Run(99)
+	if b.Read(BlockStatus) != BlockBusy {
+		t.Fatalf("status is %#02x during the command, want busy", b.Read(BlockStatus))
+	}
+	m.Run(2)
+	if b.Read(BlockStatus) != BlockDone {
+		t.Fatalf("status is %#02x after the latency, want done", b.Read(BlockStatus))
+	}
+}
+
+func TestBlockDeviceDMAWriteFails(t *testing.T) {
+	m := newTestMachine(t)
+	m.Bus.Map("rom", 0x9000, 0x9fff, core.NewROM(make([]uint8, 0x1000), core.ROMWriteError))
+	b, _ := newTestBlockDevice(t)
+	addDevice(t, m, "block", b)
+	b.Write(BlockControl, BlockDMAEnable)
+	blockCommand(b, BlockRead, 0, 0x9000)
+	if b.Read(BlockStatus) != BlockDone|BlockError {
+		t.Fatalf("status is %#02x after a DMA read into ROM, want done and error", b.Read(BlockStatus))
+	}
+}
@@ -0,0 +1,181 @@
+package devices
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+func init() {
+	machine.RegisterDevice("console", newConsoleDevice)
+}
+
+//Default addresses of the console, the ones py65 uses
+const (
+	DefaultConsolePutc uint16 = 0xf001
+	DefaultConsoleGetc uint16 = 0xf004
+)
+
+//consoleOptions are the options of a console in a machine config
+type consoleOptions struct {
+	Putc machine.Address `json:"putc"`
+	Getc machine.Address `json:"getc"`
+	//Input is where typed characters come from: "stdin", "terminal" or
+	//nothing
+	Input string `json:"input"`
+}
+
+func newConsoleDevice(options machine.Options) (machine.Device, error) {
+	opt := consoleOptions{
+		Putc: machine.Address(DefaultConsolePutc),
+		Getc: machine.Address(DefaultConsoleGetc),
+	}
+	err := options.Decode(&opt)
+	if err != nil {
+		return nil, err
+	}
+	var input io.Reader
+	switch opt.Input {
+	case "":
+	case "stdin":
+		input = os.Stdin
+	case "terminal":
+		input, err = OpenTerminal()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown console input %q", opt.Input)
+	}
+	c := NewConsole(input, os.Stdout)
+	c.Putc = uint16(opt.Putc)
+	c.Getc = uint16(opt.Getc)
+	return c, nil
+}
+
+//Console is a memory mapped console compatible with the one of the py65
+//simulator. Writes to the putc address output a character, and reads from the
+//getc address return the next character typed, or 0 if there is none. It does
+//not echo input. The console maps both addresses when it is added to a
+//machine.
+type Console struct {
+	Putc uint16
+	Getc uint16
+	//Err is the first error writing the output
+	Err error
+
+	output io.Writer
+	reader io.Reader //input, read once the console is added to a machine
+
+	mu     sync.Mutex
+	input  []uint8
+	closer io.Closer
+}
+
+//NewConsole returns a console at the default addresses that writes output to
+//out and reads input from in. Either can be nil. Once the console is added to
+//a machine, a goroutine reads in until it fails, and the characters go
+//through the input of the machine so they can be recorded. in is closed by
+//Close if it implements io.Closer.
+func NewConsole(in io.Reader, out io.Writer) *Console {
+	c := &Console{
+		Putc:   DefaultConsolePutc,
+		Getc:   DefaultConsoleGetc,
+		output: out,
+		reader: in,
+	}
+	c.closer, _ = in.(io.Closer)
+	return c
+}
+
+//Type queues characters as if they were typed. It can be called from any
+//goroutine, but only characters read from the input are recorded.
+func (c *Console) Type(data ...uint8) {
+	c.mu.Lock()
+	c.input = append(c.input, data...)
+	c.mu.Unlock()
+}
+
+//Close closes the input
+func (c *Console) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closer == nil {
+		return nil
+	}
+	err := c.closer.Close()
+	c.closer = nil
+	return err
+}
+
+func (c *Console) Attach(m *machine.Machine) error {
+	err := m.Bus.Map("console putc", c.Putc, c.Putc, consolePutc{c})
+	if err != nil {
+		return err
+	}
+	err = m.Bus.Map("console getc", c.Getc, c.Getc, consoleGetc{c})
+	if err != nil {
+		return err
+	}
+	in := m.Input(func(val uint8) { c.Type(val) })
+	if c.reader != nil {
+		go readHost(c.reader, in)
+	}
+	return nil
+}
+
+//Reset does nothing, typed characters are kept
+func (c *Console) Reset() {
+}
+
+//getc returns the next typed character or 0
+func (c *Console) getc() uint8 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.input) == 0 {
+		return 0
+	}
+	val := c.input[0]
+	c.input = c.input[1:]
+	return val
+}
+
+func (c *Console) putc(val uint8) {
+	if c.output == nil {
+		return
+	}
+	_, err := c.output.Write([]uint8{val})
+	if err != nil && c.Err == nil {
+		c.Err = err
+	}
+}
+
+//consolePutc is the output address of a console
+type consolePutc struct {
+	c *Console
+}
+
+func (p consolePutc) Read(addr uint16) uint8 {
+	return 0
+}
+
+func (p consolePutc) Write(addr uint16, val uint8) error {
+	p.c.putc(val)
+	return nil
+}
+
+//consoleGetc is the input address of a console
+type consoleGetc struct {
+	c *Console
+}
+
+func (g consoleGetc) Read(addr uint16) uint8 {
+	return g.c.getc()
+}
+
+func (g consoleGetc) Write(addr uint16, val uint8) error {
+	return nil
+}
@@ -0,0 +1,111 @@
+package devices
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rdzhaafar/emu6502/core"
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+func TestConsole(t *testing.T) {
+	ram := core.NewRAM(0x8000)
+	ram.Load(0x0000, []uint8{
+		0xad, 0x04, 0xf0, //LDA $F004
+		0x8d, 0x01, 0xf0, //STA $F001
+		0xad, 0x04, 0xf0, //LDA $F004
+		0x8d, 0x01, 0xf0, //STA $F001
+		0xad, 0x04, 0xf0, //LDA $F004
+		0xdb, //STP
+	})
+	bus := core.NewMappedBus()
+	bus.Map("ram", 0x0000, 0x7fff, ram)
+	m := machine.New(bus)
+	var out bytes.Buffer
+	c := NewConsole(nil, &out)
+	addDevice(t, m, "console", c)
+	c.Type('h', 'i')
+	for !m.CPU.Stopped() {
+		err := m.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.String() != "hi" {
+		t.Fatalf("console printed %q, want \"hi\"", out.String())
+	}
+	//reads return 0 once nothing is typed
+	if a := m.CPU.Registers.Accumulator; a != 0 {
+		t.Fatalf("getc returned %#02x with no input, want 0", a)
+	}
+}
+
+//newEchoMachine returns a machine running a program that stores every
+//character typed on a console at 0300 and up, counting them in X
+func newEchoMachine(t *testing.T, in io.Reader) *machine.Machine {
+	ram := core.NewRAM(core.MaxBusSize)
+	ram.Load(0x0200, []uint8{
+		0xad, 0x04, 0xf0, //LDA $F004
+		//BEQ $021C. When it is not taken, its offset runs as CLC.
+		0xf0, 0x18,
+		0x9d, 0x00, 0x03, //STA $0300,X
+		0xe8,             //INX
+		0x6c, 0x00, 0x02, //JMP $0200
+	})
+	ram.Load(0x021c, []uint8{0x6c, 0x00, 0x02}) //JMP $0200
+	ram.Load(0xfffc, []uint8{0x00, 0x02})
+	bus := core.NewMappedBus()
+	bus.Map("ram", 0x0000, 0xffff, ram)
+	m := machine.New(bus)
+	addDevice(t, m, "console", NewConsole(in, nil))
+	return m
+}
+
+func TestConsoleRecordAndReplay(t *testing.T) {
+	host, typist := io.Pipe()
+	m := newEchoMachine(t, host)
+	recorder := m.Record()
+	go func() {
+		for _, key := range "hello" {
+			typist.Write([]uint8{uint8(key)})
+		}
+	}()
+	for m.CPU.Registers.X < 5 {
+		err := m.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	typist.Close()
+	rec := recorder.Recording()
+	if !rec.Reset || len(rec.Events) != 5 {
+		t.Fatalf("recorded %d inputs from reset %v, want 5 characters from reset", len(rec.Events), rec.Reset)
+	}
+
+	//live input is dropped while replaying
+	replayed := newEchoMachine(t, strings.NewReader("xxxxx"))
+	replayer, err := replayed.Replay(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for !replayer.Done() {
+		err := replayer.Execute()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if *replayed.CPU.Registers != *m.CPU.Registers || replayed.Now() != m.Now() {
+		t.Fatalf("replay ended with %+v at cycle %d, want %+v at cycle %d",
+			*replayed.CPU.Registers, replayed.Now(), *m.CPU.Registers, m.Now())
+	}
+	for addr := uint16(0x0300); addr < 0x0305; addr++ {
+		if got, want := replayed.Bus.Read(addr), m.Bus.Read(addr); got != want {
+			t.Fatalf("%#04x is %q after the replay, want %q", addr, got, want)
+		}
+	}
+	if got := string([]uint8{m.Bus.Read(0x0300), m.Bus.Read(0x0304)}); got != "ho" {
+		t.Fatalf("the program read %q first and last, want \"ho\"", got)
+	}
+}
@@ -0,0 +1,287 @@
+package devices
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+func init() {
+	machine.RegisterDevice("ds1307", newDS1307Device)
+}
+
+//ds1307Options are the options of a DS1307 in a machine config
+type ds1307Options struct {
+	i2cWiring
+	//Time is the time the clock starts at, in RFC 3339 format. Without it
+	//the clock follows the host.
+	Time string `json:"time"`
+}
+
+func newDS1307Device(options machine.Options) (machine.Device, error) {
+	opt := ds1307Options{i2cWiring: i2cWiring{Port: "a"}}
+	err := options.Decode(&opt)
+	if err != nil {
+		return nil, err
+	}
+	err = opt.check()
+	if err != nil {
+		return nil, err
+	}
+	var d *DS1307
+	if opt.Time == "" {
+		d = NewDS1307()
+	} else {
+		start, err := time.Parse(time.RFC3339, opt.Time)
+		if err != nil {
+			return nil, fmt.Errorf("bad DS1307 time: %w", err)
+		}
+		d = NewFixedDS1307(start)
+	}
+	d.wireTo = &opt.i2cWiring
+	return d, nil
+}
+
+//DS1307Address is the I2C address of the DS1307
+const DS1307Address uint8 = 0x68
+
+//Registers of the DS1307. The time is in BCD.
+const (
+	DS1307Seconds = 0x00 //bit 7 is DS1307Halt
+	DS1307Minutes = 0x01
+	DS1307Hours   = 0x02 //bit 6 selects DS1307Hours12
+	DS1307Day     = 0x03 //day of the week, 1 to 7
+	DS1307Date    = 0x04
+	DS1307Month   = 0x05
+	DS1307Year    = 0x06 //00 to 99, for 2000 to 2099
+	DS1307Control = 0x07
+	DS1307RAM     = 0x08 //56 bytes of RAM up to 0x3f
+)
+
+//Bits of the time registers
+const (
+	DS1307Halt    uint8 = 0x80 //clock halt, stops the clock
+	DS1307Hours12 uint8 = 0x40 //12 hour mode
+	DS1307PM      uint8 = 0x20 //afternoon in 12 hour mode
+)
+
+//DS1307 is a DS1307 real time clock on an I2C bus, with the time in BCD
+//registers and 56 bytes of RAM. The first byte written after the address
+//sets the register pointer, and reads and writes go on from there, wrapping
+//from 0x3f to 0. Like on the real chip, the time registers are copied when a
+//transfer starts, so a read sees a consistent time even when the clock ticks
+//during it.
+//
+//The clock either follows host time or starts at a fixed time and counts cpu
+//cycles, so runs are reproducible. Firmware setting the time moves the clock
+//without changing the host clock. It starts running, with a control register
+//of 0. The square wave output is not emulated.
+type DS1307 struct {
+	ram     [64]uint8 //the time registers are not used
+	time    [7]uint8  //time registers copied at the start of a transfer
+	pointer uint8
+	setting bool //the next byte written sets the pointer
+
+	source    func() time.Time //time the clock follows
+	offset    time.Duration    //clock time ahead of the source
+	halted    bool
+	stopped   time.Time //clock time while halted
+	weekday   int       //days the day register is ahead of Sunday being 1
+	hours12   bool
+	writeback bool //the time registers were written
+
+	m      *machine.Machine
+	start  time.Time
+	bus    *I2CBus
+	wireTo *i2cWiring
+}
+
+//NewDS1307 returns a DS1307 following host time
+func NewDS1307() *DS1307 {
+	return &DS1307{source: time.Now}
+}
+
+//NewFixedDS1307 returns a DS1307 that starts at start and counts cycles of
+//the machine it is added to. Until it is added, it stays at start.
+func NewFixedDS1307(start time.Time) *DS1307 {
+	d := &DS1307{start: start}
+	d.source = d.emulated
+	return d
+}
+
+//emulated returns the start time plus the cycles run. A machine without a
+//clock speed is taken to run at the default one.
+func (d *DS1307) emulated() time.Time {
+	if d.m == nil {
+		return d.start
+	}
+	clock := d.m.Clock
+	if clock == 0 {
+		clock = machine.DefaultClock
+	}
+	now := d.m.Now()
+	elapsed := now / clock * uint64(time.Second)
+	elapsed += now % clock * uint64(time.Second) / clock
+	return d.start.Add(time.Duration(elapsed))
+}
+
+//Time returns the time of the clock
+func (d *DS1307) Time() time.Time {
+	if d.halted {
+		return d.stopped
+	}
+	return d.source().Add(d.offset)
+}
+
+//SetTime sets the time of the clock
+func (d *DS1307) SetTime(t time.Time) {
+	if d.halted {
+		d.stopped = t
+		return
+	}
+	d.offset = t.Sub(d.source())
+}
+
+//Halted reports whether the clock is stopped
+func (d *DS1307) Halted() bool {
+	return d.halted
+}
+
+//SetHalted stops or starts the clock
+func (d *DS1307) SetHalted(halted bool) {
+	if halted == d.halted {
+		return
+	}
+	t := d.Time()
+	d.halted = halted
+	if halted {
+		d.stopped = t
+	} else {
+		d.SetTime(t)
+	}
+}
+
+func (d *DS1307) Attach(m *machine.Machine) error {
+	d.m = m
+	opt := d.wireTo
+	if opt == nil {
+		return nil
+	}
+	d.wireTo = nil
+	var err error
+	d.bus, err = opt.connect(m, d)
+	return err
+}
+
+//Reset releases the bus. The clock keeps running, it has a battery.
+func (d *DS1307) Reset() {
+	if d.bus != nil {
+		d.bus.Reset()
+	}
+}
+
+//Start latches the time registers when the DS1307 is addressed
+func (d *DS1307) Start(addr uint8, read bool) bool {
+	if addr != DS1307Address {
+		return false
+	}
+	d.latch()
+	d.setting = !read
+	return true
+}
+
+//Write sets the register pointer or writes a register
+func (d *DS1307) Write(val uint8) bool {
+	if d.setting {
+		d.setting = false
+		d.pointer = val & 0x3f
+		return true
+	}
+	switch {
+	case d.pointer < DS1307Control:
+		d.time[d.pointer] = val
+		d.writeback = true
+	case d.pointer == DS1307Control:
+		d.ram[d.pointer] = val & 0x93
+	default:
+		d.ram[d.pointer] = val
+	}
+	d.pointer = (d.pointer + 1) & 0x3f
+	return true
+}
+
+//Read returns the register at the pointer
+func (d *DS1307) Read() uint8 {
+	val := d.ram[d.pointer]
+	if d.pointer < DS1307Control {
+		val = d.time[d.pointer]
+	}
+	d.pointer = (d.pointer + 1) & 0x3f
+	return val
+}
+
+//Stop sets the clock to the time registers written during the transfer
+func (d *DS1307) Stop() {
+	if !d.writeback {
+		return
+	}
+	d.writeback = false
+	d.setRegisters()
+}
+
+//latch copies the time into the time registers
+func (d *DS1307) latch() {
+	t := d.Time()
+	d.time[DS1307Seconds] = bcd(t.Second())
+	if d.halted {
+		d.time[DS1307Seconds] |= DS1307Halt
+	}
+	d.time[DS1307Minutes] = bcd(t.Minute())
+	if d.hours12 {
+		hour := t.Hour() % 12
+		if hour == 0 {
+			hour = 12
+		}
+		d.time[DS1307Hours] = DS1307Hours12 | bcd(hour)
+		if t.Hour() >= 12 {
+			d.time[DS1307Hours] |= DS1307PM
+		}
+	} else {
+		d.time[DS1307Hours] = bcd(t.Hour())
+	}
+	d.time[DS1307Day] = uint8((int(t.Weekday())+d.weekday)%7) + 1
+	d.time[DS1307Date] = bcd(t.Day())
+	d.time[DS1307Month] = bcd(int(t.Month()))
+	d.time[DS1307Year] = bcd(t.Year() % 100)
+}
+
+//setRegisters sets the clock to the time registers. Dates that do not
+//exist roll over into the next month.
+func (d *DS1307) setRegisters() {
+	r := d.time
+	hour := unbcd(r[DS1307Hours] & 0x3f)
+	d.hours12 = r[DS1307Hours]&DS1307Hours12 != 0
+	if d.hours12 {
+		hour = unbcd(r[DS1307Hours]&0x1f) % 12
+		if r[DS1307Hours]&DS1307PM != 0 {
+			hour += 12
+		}
+	}
+	t := time.Date(2000+unbcd(r[DS1307Year]), time.Month(unbcd(r[DS1307Month]&0x1f)),
+		unbcd(r[DS1307Date]&0x3f), hour, unbcd(r[DS1307Minutes]&0x7f),
+		unbcd(r[DS1307Seconds]&0x7f), 0, d.Time().Location())
+	d.weekday = ((int(r[DS1307Day]&0x7)-1-int(t.Weekday()))%7 + 7) % 7
+	d.SetHalted(r[DS1307Seconds]&DS1307Halt != 0)
+	d.SetTime(t)
+}
+
+//bcd returns a number from 0 to 99 in BCD
+func bcd(n int) uint8 {
+	return uint8(n/10<<4 | n%10)
+}
+
+//unbcd returns the number a BCD byte holds
+func unbcd(val uint8) int {
+	return int(val>>4)*10 + int(val&0xf)
+}
@@ -0,0 +1,65 @@
+package devices
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDS1307(t *testing.T) {
+	h := newI2CHost(t)
+	start := time.Date(2024, time.February, 29, 23, 59, 58, 0, time.UTC)
+	d := NewFixedDS1307(start)
+	addDevice(t, h.m, "rtc", d)
+	h.bus.Add(d)
+	//Thursday is day 5 with Sunday being 1
+	regs := h.readRegisters(t, DS1307Address, []uint8{DS1307Seconds}, 7)
+	want := []uint8{0x58, 0x59, 0x23, 0x05, 0x29, 0x02, 0x24}
+	for i := range want {
+		if regs[i] != want[i] {
+			t.Fatalf("time registers are % x, want % x", regs, want)
+		}
+	}
+	//the clock counts cycles
+	h.m.Run(2 * h.m.Clock)
+	regs = h.readRegisters(t, DS1307Address, []uint8{DS1307Seconds}, 7)
+	want = []uint8{0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x24}
+	for i := range want {
+		if regs[i] != want[i] {
+			t.Fatalf("time registers are % x two seconds later, want % x", regs, want)
+		}
+	}
+
+	//set 11:30:00 PM in 12 hour mode and write the RAM
+	h.start()
+	h.write(DS1307Address << 1)
+	for _, val := range []uint8{DS1307Seconds, 0x00, 0x30, DS1307Hours12 | DS1307PM | 0x11} {
+		h.write(val)
+	}
+	h.stop()
+	if got := d.Time(); got.Hour() != 23 || got.Minute() != 30 {
+		t.Fatalf("clock is at %v after setting it, want 23:30", got)
+	}
+	h.start()
+	h.write(DS1307Address << 1)
+	h.write(0x3f)
+	//the pointer wraps from the last RAM byte to the seconds
+	h.write(0xa5)
+	h.stop()
+	regs = h.readRegisters(t, DS1307Address, []uint8{0x3f}, 4)
+	if regs[0] != 0xa5 || regs[3] != DS1307Hours12|DS1307PM|0x11 {
+		t.Fatalf("registers from 3f are % x", regs)
+	}
+}
+
+func TestDS1307WithoutClockSpeed(t *testing.T) {
+	h := newI2CHost(t)
+	h.m.Clock = 0
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	d := NewFixedDS1307(start)
+	addDevice(t, h.m, "rtc", d)
+	//the clock runs at the default speed
+	h.m.Run(3 * 1000000)
+	if elapsed := d.Time().Sub(start); elapsed < 3*time.Second || elapsed > 4*time.Second {
+		t.Fatalf("%v passed in 3000000 cycles, want 3s", elapsed)
+	}
+}
@@ -0,0 +1,346 @@
+package devices
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+func init() {
+	machine.RegisterDevice("eeprom", newEEPROMDevice)
+}
+
+//eepromOptions are the options of an EEPROM in a machine config
+type eepromOptions struct {
+	Image     string `json:"image"`
+	Save      bool   `json:"save"`
+	Protected bool   `json:"protected"`
+}
+
+func newEEPROMDevice(options machine.Options) (machine.Device, error) {
+	var opt eepromOptions
+	err := options.Decode(&opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Image == "" {
+		return nil, fmt.Errorf("eeprom needs an image")
+	}
+	e, err := LoadEEPROM(options.Path(opt.Image), opt.Save)
+	if err != nil {
+		return nil, err
+	}
+	e.SetProtected(opt.Protected)
+	return e, nil
+}
+
+//Timing of the 28C256
+const (
+	//EEPROMWriteTime is the duration of the internal write cycle
+	EEPROMWriteTime = 10 * time.Millisecond
+	//EEPROMByteLoadTime is how long the chip waits for the next byte of a page
+	//before it starts the write cycle
+	EEPROMByteLoadTime = 150 * time.Microsecond
+	//EEPROMPageSize is the number of bytes that can be written in one cycle
+	EEPROMPageSize = 64
+)
+
+//sdpStep is one write of a software data protection command sequence
+type sdpStep struct {
+	addr uint16
+	val  uint8
+}
+
+var (
+	//sdpEnable enables write protection and unlocks the writes that follow it
+	sdpEnable = []sdpStep{{0x5555, 0xaa}, {0x2aaa, 0x55}, {0x5555, 0xa0}}
+	//sdpDisable disables write protection
+	sdpDisable = []sdpStep{{0x5555, 0xaa}, {0x2aaa, 0x55}, {0x5555, 0x80}, {0x5555, 0xaa}, {0x2aaa, 0x55}, {0x5555, 0x20}}
+)
+
+//EEPROM is a 28C series parallel EEPROM, like the 28C256. Writes are
+//collected into pages and written after the write cycle, during which reads
+//return data polling and toggle bits instead of the contents. With software
+//data protection enabled, only writes that follow the unlock sequence are
+//accepted.
+//
+//Writes that may start a command sequence are held until the sequence
+//breaks, the byte load window runs out or the EEPROM is read, and are then
+//written as data. Without a clock, writes complete immediately and the unlock
+//sequence covers all writes up to the next read.
+type EEPROM struct {
+	data      []uint8
+	mask      uint16
+	path      string //file written back to, if any
+	protected bool
+	//Err is the first error that occurred while saving writes back to the
+	//image file
+	Err error
+
+	clock       func() uint64 //current time in cpu cycles
+	loadCycles  uint64
+	writeCycles uint64
+
+	held      []sdpStep //writes that may be part of a command sequence
+	heldUntil uint64    //end of the byte load window of the last held write
+	unlocked  bool      //writes are accepted despite the protection
+
+	loading   bool
+	writing   bool
+	deadline  uint64 //end of the byte load window or of the write cycle
+	page      uint16 //address of the first byte of the page being written
+	pageData  [EEPROMPageSize]uint8
+	pageBytes [EEPROMPageSize]bool
+	last      uint8 //last byte written, inverted in bit 7 while polling
+	toggle    uint8 //bit 6 toggles on every read while busy
+}
+
+//NewEEPROM returns an EEPROM holding a copy of image. The size of image must
+//be a power of two. Software data protection starts out disabled.
+func NewEEPROM(image []uint8) (*EEPROM, error) {
+	size := len(image)
+	if size == 0 || size > 0x10000 || size&(size-1) != 0 {
+		return nil, fmt.Errorf("eeprom size %d is not a power of two", size)
+	}
+	e := &EEPROM{
+		data: append([]uint8(nil), image...),
+		mask: uint16(size - 1),
+	}
+	return e, nil
+}
+
+//LoadEEPROM returns an EEPROM holding the contents of the file at path. If
+//saveBack is set, every completed write is written back to the file.
+func LoadEEPROM(path string, saveBack bool) (*EEPROM, error) {
+	image, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	e, err := NewEEPROM(image)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if saveBack {
+		e.path = path
+	}
+	return e, nil
+}
+
+//Size returns the size of the EEPROM in bytes
+func (e *EEPROM) Size() int {
+	return len(e.data)
+}
+
+//SetClock makes write timing follow clock, which returns the current time in
+//cycles of a clock running at hz. Usually that is the cpu cycle count.
+func (e *EEPROM) SetClock(clock func() uint64, hz uint64) {
+	e.clock = clock
+	e.loadCycles = uint64(EEPROMByteLoadTime) * hz / uint64(time.Second)
+	e.writeCycles = uint64(EEPROMWriteTime) * hz / uint64(time.Second)
+}
+
+//Attach makes write timing follow the cpu cycles of m
+func (e *EEPROM) Attach(m *machine.Machine) error {
+	e.SetClock(m.Now, m.Clock)
+	return nil
+}
+
+//Reset does nothing, the EEPROM is not connected to the reset line
+func (e *EEPROM) Reset() {
+}
+
+//Protected reports whether software data protection is enabled
+func (e *EEPROM) Protected() bool {
+	return e.protected
+}
+
+//SetProtected enables or disables software data protection, like the
+//programmer does
+func (e *EEPROM) SetProtected(protected bool) {
+	e.protected = protected
+}
+
+//Busy reports whether the EEPROM is loading a page or writing it
+func (e *EEPROM) Busy() bool {
+	e.update()
+	return e.loading || e.writing
+}
+
+//Bytes returns a copy of the contents of the EEPROM. Pending writes are not
+//included.
+func (e *EEPROM) Bytes() []uint8 {
+	e.update()
+	return append([]uint8(nil), e.data...)
+}
+
+//Flush completes pending writes immediately
+func (e *EEPROM) Flush() {
+	e.flushHeld()
+	if e.loading || e.writing {
+		e.commit()
+	}
+}
+
+func (e *EEPROM) Read(addr uint16) uint8 {
+	if e.clock == nil {
+		e.Flush()
+		e.unlocked = false
+	}
+	e.update()
+	//a read ends any command sequence
+	e.flushHeld()
+	if e.loading || e.writing {
+		e.toggle ^= 0x40
+		return ^e.last&0x80 | e.toggle | e.last&0x3f
+	}
+	return e.data[addr&e.mask]
+}
+
+func (e *EEPROM) Write(addr uint16, val uint8) error {
+	e.update()
+	if e.writing {
+		//writes are ignored during the write cycle
+		return nil
+	}
+	e.command(sdpStep{addr & e.mask, val})
+	return nil
+}
+
+//command feeds a write to the command sequence detector. Writes that turn
+//out not to be part of a command are written as data.
+func (e *EEPROM) command(w sdpStep) {
+	e.held = append(e.held, w)
+	if e.clock != nil {
+		e.heldUntil = e.clock() + e.loadCycles
+		if e.loading {
+			//the chip sees every write as a byte load
+			e.deadline = e.heldUntil
+		}
+	}
+	enable := e.matches(sdpEnable)
+	disable := e.matches(sdpDisable)
+	switch {
+	case enable && len(e.held) == len(sdpEnable):
+		e.held = e.held[:0]
+		e.protected = true
+		e.unlocked = true
+	case disable && len(e.held) == len(sdpDisable):
+		e.held = e.held[:0]
+		e.protected = false
+	case enable || disable:
+		//wait for the rest of the sequence
+	default:
+		//the last write broke the sequence, but it may start a new one
+		e.held = e.held[:len(e.held)-1]
+		e.flushHeld()
+		e.held = append(e.held, w)
+		if !e.matches(sdpEnable) && !e.matches(sdpDisable) {
+			e.held = e.held[:0]
+			e.load(w.addr, w.val)
+		}
+	}
+}
+
+//matches reports whether the held writes are a prefix of sequence
+func (e *EEPROM) matches(sequence []sdpStep) bool {
+	if len(e.held) > len(sequence) {
+		return false
+	}
+	for i, w := range e.held {
+		if w != (sdpStep{sequence[i].addr & e.mask, sequence[i].val}) {
+			return false
+		}
+	}
+	return true
+}
+
+//flushHeld writes the held writes as data
+func (e *EEPROM) flushHeld() {
+	held := e.held
+	e.held = nil
+	for _, w := range held {
+		e.load(w.addr, w.val)
+	}
+}
+
+//load adds a byte to the page being loaded. All bytes of a page write must
+//be on the same page, bytes for other pages are ignored.
+func (e *EEPROM) load(addr uint16, val uint8) {
+	if e.protected && !e.unlocked {
+		return
+	}
+	page := addr &^ (EEPROMPageSize - 1)
+	if !e.loading {
+		e.loading = true
+		e.page = page
+		e.pageBytes = [EEPROMPageSize]bool{}
+	} else if page != e.page {
+		return
+	}
+	offset := addr & (EEPROMPageSize - 1)
+	e.pageData[offset] = val
+	e.pageBytes[offset] = true
+	e.last = val
+	if e.clock == nil {
+		e.commit()
+		return
+	}
+	e.deadline = e.clock() + e.loadCycles
+}
+
+//update advances the write state machine to the current time
+func (e *EEPROM) update() {
+	if e.clock == nil {
+		return
+	}
+	now := e.clock()
+	if len(e.held) > 0 && now >= e.heldUntil {
+		//the sequence was not completed in time
+		e.flushHeld()
+	}
+	if e.loading && now >= e.deadline {
+		e.loading = false
+		e.writing = true
+		e.unlocked = false
+		e.deadline += e.writeCycles
+	}
+	if e.writing && now >= e.deadline {
+		e.commit()
+	}
+}
+
+//commit writes the loaded page to the array and to the image file
+func (e *EEPROM) commit() {
+	e.loading = false
+	e.writing = false
+	e.unlocked = e.unlocked && e.clock == nil
+	for i, ok := range e.pageBytes {
+		if ok {
+			e.data[(e.page+uint16(i))&e.mask] = e.pageData[i]
+		}
+	}
+	if e.path != "" {
+		e.save()
+	}
+}
+
+//save writes the page that was just written back to the image file
+func (e *EEPROM) save() {
+	end := int(e.page) + EEPROMPageSize
+	if end > len(e.data) {
+		end = len(e.data)
+	}
+	f, err := os.OpenFile(e.path, os.O_WRONLY, 0)
+	if err == nil {
+		_, err = f.WriteAt(e.data[e.page:end], int64(e.page))
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil && e.Err == nil {
+		e.Err = err
+	}
+}
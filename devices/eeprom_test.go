@@ -0,0 +1,106 @@
+package devices
+
+import "testing"
+
+//clockedEEPROM returns a 32K EEPROM running on a 1MHz clock controlled by
+//the returned pointer
+func clockedEEPROM(t *testing.T) (*EEPROM, *uint64) {
+	e, err := NewEEPROM(make([]uint8, 0x8000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := new(uint64)
+	e.SetClock(func() uint64 { return *now }, 1000000)
+	return e, now
+}
+
+func TestEEPROMPageWrite(t *testing.T) {
+	e, now := clockedEEPROM(t)
+	e.Write(0x0100, 0x02)
+	e.Write(0x0101, 0x81)
+	//a byte for another page is ignored
+	e.Write(0x0200, 0x03)
+	if !e.Busy() {
+		t.Fatal("EEPROM is not busy while loading a page")
+	}
+	//data polling inverts bit 7 and bit 6 toggles on every read
+	first, second := e.Read(0x0100), e.Read(0x0100)
+	if first&0x80 != 0 || second&0x80 != 0 || (first^second)&0x40 == 0 {
+		t.Fatalf("polling read %#02x then %#02x", first, second)
+	}
+	*now += e.loadCycles + e.writeCycles
+	if e.Busy() {
+		t.Fatal("EEPROM is busy after the write cycle")
+	}
+	for addr, want := range map[uint16]uint8{0x0100: 0x02, 0x0101: 0x81, 0x0200: 0x00} {
+		if val := e.Read(addr); val != want {
+			t.Errorf("%#04x is %#02x, want %#02x", addr, val, want)
+		}
+	}
+}
+
+func TestEEPROMHeldWritesExpire(t *testing.T) {
+	e, now := clockedEEPROM(t)
+	//AA to 5555 may start a command sequence
+	e.Write(0x5555, 0xaa)
+	*now += e.loadCycles
+	if !e.Busy() {
+		t.Fatal("held write was not loaded after the byte load window")
+	}
+	*now += e.loadCycles + e.writeCycles
+	if val := e.Read(0x5555); val != 0xaa {
+		t.Fatalf("5555 is %#02x, want aa", val)
+	}
+	//a read breaks the sequence too
+	e.Write(0x5555, 0xaa)
+	e.Write(0x2aaa, 0x55)
+	e.Write(0x5555, 0x80)
+	if e.Busy() {
+		t.Fatal("writes of a command sequence were loaded")
+	}
+	e.Read(0x0000)
+	*now += e.loadCycles + e.writeCycles
+	//2AAA is on another page than 5555, so only the writes to 5555 count
+	if val := e.Read(0x5555); val != 0x80 {
+		t.Fatalf("5555 is %#02x, want 80", val)
+	}
+	if val := e.Read(0x2aaa); val != 0x00 {
+		t.Fatalf("2aaa is %#02x, want 00", val)
+	}
+}
+
+func TestEEPROMSoftwareDataProtection(t *testing.T) {
+	e, now := clockedEEPROM(t)
+	e.SetProtected(true)
+	e.Write(0x0000, 0x11)
+	*now += e.loadCycles + e.writeCycles
+	if e.Busy() || e.Read(0x0000) != 0x00 {
+		t.Fatal("protected EEPROM accepted a write")
+	}
+	for _, w := range sdpEnable {
+		e.Write(w.addr, w.val)
+	}
+	e.Write(0x0000, 0x22)
+	*now += e.loadCycles + e.writeCycles
+	if val := e.Read(0x0000); val != 0x22 {
+		t.Fatalf("0000 is %#02x after the unlock sequence, want 22", val)
+	}
+	for _, w := range sdpDisable {
+		e.Write(w.addr, w.val)
+	}
+	if e.Protected() {
+		t.Fatal("EEPROM is protected after the disable sequence")
+	}
+}
+
+func TestEEPROMWithoutClock(t *testing.T) {
+	e, err := NewEEPROM(make([]uint8, 0x2000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//on a 28C64 the sequence address 5555 is 1555
+	e.Write(0x1555, 0xaa)
+	if val := e.Read(0x1555); val != 0xaa {
+		t.Fatalf("1555 is %#02x, want aa", val)
+	}
+}
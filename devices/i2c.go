@@ -0,0 +1,288 @@
+package devices
+
+import (
+	"fmt"
+
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+//I2CDevice is a peripheral on an I2C bus. It works a byte at a time.
+type I2CDevice interface {
+	//Start is called after a start condition with the 7-bit address the
+	//master sent and whether it reads. It returns whether the device
+	//acknowledges, which it does only for its own addresses.
+	Start(addr uint8, read bool) bool
+	//Write is called with every byte the master writes to the device and
+	//returns whether the device acknowledges it
+	Write(val uint8) bool
+	//Read returns the next byte the device sends to the master
+	Read() uint8
+	//Stop is called when a transfer with the device ends with a stop
+	//condition
+	Stop()
+}
+
+//i2cState is what an I2C bus does with the clock pulses it sees
+type i2cState int
+
+const (
+	i2cIdle    i2cState = iota //waiting for a start condition
+	i2cAddress                 //receiving the address
+	i2cWrite                   //the master writes to a device
+	i2cRead                    //a device sends to the master
+)
+
+//I2CBus is an I2C bus that firmware drives by toggling port pins. Both lines
+//are open drain: the master releases a pin by making it an input, so it is
+//pulled up, and pulls it low by making it an output with a 0 in the data
+//register. Devices pull SDA low to acknowledge and to send 0 bits.
+//
+//The bus sees start and stop conditions, SDA changing while SCL is high,
+//and samples SDA on the rising edges of SCL. A device that does not
+//acknowledge its address is left out of the transfer until the next start.
+//Devices can stretch the clock by holding SCL low, which the master has to
+//wait for.
+type I2CBus struct {
+	port     *Port
+	scl, sda uint8
+	driver   *PortDriver
+	devices  []I2CDevice
+
+	state  i2cState
+	active I2CDevice //device addressed by the transfer
+	read   bool      //the transfer reads from the device
+	bits   int       //clock pulses of the current byte, the ninth is the acknowledge
+	shift  uint8     //byte being received or sent
+	ack    bool      //the byte was acknowledged
+
+	m       *machine.Machine
+	stretch uint64
+	hold    *machine.Event
+}
+
+//NewI2CBus returns an I2C bus on the SCL and SDA pins of a port, given as
+//masks
+func NewI2CBus(port *Port, scl, sda uint8) *I2CBus {
+	b := &I2CBus{
+		port:   port,
+		scl:    scl,
+		sda:    sda,
+		driver: port.Driver(),
+	}
+	port.Watch(b.pinsChanged)
+	return b
+}
+
+//Add adds a device to the bus
+func (b *I2CBus) Add(device I2CDevice) {
+	b.devices = append(b.devices, device)
+}
+
+//SetStretch makes devices hold SCL low for cycles after they acknowledge a
+//byte and before they send one, the way slow devices stretch the clock. It
+//takes the machine whose clock times the stretching. 0 turns it off.
+func (b *I2CBus) SetStretch(m *machine.Machine, cycles uint64) {
+	b.m = m
+	b.stretch = cycles
+}
+
+//HoldClock holds SCL low, or releases it, for devices that stretch the
+//clock themselves
+func (b *I2CBus) HoldClock(hold bool) {
+	if hold {
+		b.driver.Set(b.scl, 0)
+	} else {
+		b.driver.Release(b.scl)
+	}
+}
+
+//Reset releases both lines and abandons the transfer in progress, as if the
+//devices were powered up again
+func (b *I2CBus) Reset() {
+	if b.hold != nil {
+		b.hold.Cancel()
+		b.hold = nil
+	}
+	b.state = i2cIdle
+	b.active = nil
+	b.driver.Release(b.scl | b.sda)
+}
+
+//stretchClock holds SCL low for the stretch cycles
+func (b *I2CBus) stretchClock() {
+	if b.m == nil || b.stretch == 0 {
+		return
+	}
+	if b.hold != nil {
+		b.hold.Cancel()
+	}
+	b.HoldClock(true)
+	b.hold = b.m.After(b.stretch, func() {
+		b.hold = nil
+		b.HoldClock(false)
+	})
+}
+
+func (b *I2CBus) pinsChanged(old, pins uint8) {
+	changed := old ^ pins
+	switch {
+	case changed&b.scl != 0 && pins&b.scl != 0:
+		b.clockRose(pins&b.sda != 0)
+	case changed&b.scl != 0:
+		b.clockFell()
+	case changed&b.sda != 0 && pins&b.scl != 0:
+		if pins&b.sda == 0 {
+			b.start()
+		} else {
+			b.stop()
+		}
+	}
+}
+
+//start begins a transfer, or begins it again without a stop in between
+func (b *I2CBus) start() {
+	b.driver.Release(b.sda)
+	b.state = i2cAddress
+	b.active = nil
+	b.bits = 0
+	b.shift = 0
+}
+
+func (b *I2CBus) stop() {
+	b.driver.Release(b.sda)
+	b.state = i2cIdle
+	if b.active != nil {
+		b.active.Stop()
+		b.active = nil
+	}
+}
+
+//clockRose samples SDA
+func (b *I2CBus) clockRose(sda bool) {
+	switch b.state {
+	case i2cIdle:
+		return
+	case i2cAddress, i2cWrite:
+		if b.bits < 8 {
+			b.shift = b.shift<<1 | boolBit(sda, 1)
+		}
+	case i2cRead:
+		if b.bits == 8 {
+			//the master acknowledges to get another byte
+			b.ack = !sda
+		}
+	}
+	b.bits++
+}
+
+//clockFell drives SDA for the next clock pulse
+func (b *I2CBus) clockFell() {
+	switch b.state {
+	case i2cAddress, i2cWrite:
+		switch b.bits {
+		case 8:
+			b.ack = b.received(b.shift)
+			if b.ack {
+				b.driver.Set(b.sda, 0)
+				b.stretchClock()
+			}
+		case 9:
+			b.driver.Release(b.sda)
+			b.bits = 0
+			b.shift = 0
+			switch {
+			case !b.ack:
+				//ignore the rest of the transfer
+				b.state = i2cIdle
+			case b.read:
+				b.state = i2cRead
+				b.shift = b.active.Read()
+				b.sendBit()
+			default:
+				b.state = i2cWrite
+			}
+		}
+	case i2cRead:
+		switch {
+		case b.bits < 8:
+			b.sendBit()
+		case b.bits == 8:
+			b.driver.Release(b.sda)
+		case !b.ack:
+			b.state = i2cIdle
+		default:
+			b.bits = 0
+			b.shift = b.active.Read()
+			b.stretchClock()
+			b.sendBit()
+		}
+	}
+}
+
+//received handles a byte from the master and returns whether it is
+//acknowledged
+func (b *I2CBus) received(val uint8) bool {
+	if b.state == i2cWrite {
+		return b.active.Write(val)
+	}
+	addr, read := val>>1, val&1 != 0
+	for _, d := range b.devices {
+		if d.Start(addr, read) {
+			b.active = d
+			b.read = read
+			return true
+		}
+	}
+	return false
+}
+
+//sendBit drives SDA with the next bit of the byte being sent
+func (b *I2CBus) sendBit() {
+	bit := b.shift >> (7 - b.bits) & 1
+	b.driver.Set(b.sda, bit*b.sda)
+}
+
+//i2cWiring are the options of an I2C device in a machine config that wire
+//it to the pins of a port. Pins are numbered 0 to 7.
+type i2cWiring struct {
+	//Device is the device whose port the I2C bus is wired to
+	Device string `json:"device"`
+	Port   string `json:"port"`
+	SCL    *int   `json:"scl"`
+	SDA    *int   `json:"sda"`
+	//Stretch is the number of cycles the device stretches the clock for
+	Stretch uint64 `json:"stretch"`
+}
+
+//check returns an error if the wiring is incomplete
+func (w *i2cWiring) check() error {
+	if w.Device == "" {
+		return fmt.Errorf("an I2C device needs a device to be wired to")
+	}
+	pins := map[string]*int{"scl": w.SCL, "sda": w.SDA}
+	for name, pin := range pins {
+		if pin == nil {
+			return fmt.Errorf("the %s pin of the I2C bus is not set", name)
+		}
+		if *pin < 0 || *pin > 7 {
+			return fmt.Errorf("%s is wired to pin %d, ports have pins 0 to 7", name, *pin)
+		}
+	}
+	if *w.SCL == *w.SDA {
+		return fmt.Errorf("scl and sda are wired to the same pin")
+	}
+	return nil
+}
+
+//connect puts device on a bus of its own on the pins. Devices on the same
+//pins share the lines.
+func (w *i2cWiring) connect(m *machine.Machine, device I2CDevice) (*I2CBus, error) {
+	port, err := portOf(m, w.Device, w.Port)
+	if err != nil {
+		return nil, err
+	}
+	bus := NewI2CBus(port, 1<<*w.SCL, 1<<*w.SDA)
+	bus.SetStretch(m, w.Stretch)
+	bus.Add(device)
+	return bus, nil
+}
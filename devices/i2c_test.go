@@ -0,0 +1,207 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+//Pins of the I2C bus in the tests, on VIA port A
+const (
+	testSCL uint8 = 0x01
+	testSDA uint8 = 0x02
+)
+
+//i2cHost is an I2C master bit-banged through a VIA, like firmware does. It
+//pulls a line low by making it an output, with ORA left at 0.
+type i2cHost struct {
+	m         *machine.Machine
+	v         *VIA
+	bus       *I2CBus
+	stretched int //times a device held SCL low
+}
+
+//newI2CHost returns a machine with a VIA and an I2C bus on its port A
+func newI2CHost(t *testing.T) *i2cHost {
+	m := newTestMachine(t)
+	v := NewVIA()
+	addDevice(t, m, "via", v)
+	return &i2cHost{m: m, v: v, bus: NewI2CBus(v.PortA, testSCL, testSDA)}
+}
+
+//set releases or pulls low a line
+func (h *i2cHost) set(line uint8, high bool) {
+	ddra := h.v.Read(ViaDDRA)
+	if high {
+		ddra &^= line
+	} else {
+		ddra |= line
+	}
+	h.v.Write(ViaDDRA, ddra)
+	//wait for devices stretching the clock
+	for line == testSCL && high && h.v.Read(ViaORA)&testSCL == 0 {
+		h.stretched++
+		h.m.Run(10)
+	}
+}
+
+func (h *i2cHost) sda() bool {
+	return h.v.Read(ViaORA)&testSDA != 0
+}
+
+//start sends a start condition, or a repeated start during a transfer
+func (h *i2cHost) start() {
+	h.set(testSDA, true)
+	h.set(testSCL, true)
+	h.set(testSDA, false)
+	h.set(testSCL, false)
+}
+
+func (h *i2cHost) stop() {
+	h.set(testSDA, false)
+	h.set(testSCL, true)
+	h.set(testSDA, true)
+}
+
+//write sends a byte and returns whether it was acknowledged
+func (h *i2cHost) write(val uint8) bool {
+	for bit := 7; bit >= 0; bit-- {
+		h.set(testSDA, val>>bit&1 != 0)
+		h.set(testSCL, true)
+		h.set(testSCL, false)
+	}
+	h.set(testSDA, true)
+	h.set(testSCL, true)
+	ack := !h.sda()
+	h.set(testSCL, false)
+	return ack
+}
+
+//read receives a byte and acknowledges it if more are to follow
+func (h *i2cHost) read(more bool) uint8 {
+	h.set(testSDA, true)
+	var val uint8
+	for bit := 0; bit < 8; bit++ {
+		h.set(testSCL, true)
+		val = val<<1 | boolBit(h.sda(), 1)
+		h.set(testSCL, false)
+	}
+	h.set(testSDA, !more)
+	h.set(testSCL, true)
+	h.set(testSCL, false)
+	h.set(testSDA, true)
+	return val
+}
+
+//readRegisters sets the register pointer of the device at addr and reads n
+//bytes from there
+func (h *i2cHost) readRegisters(t *testing.T, addr uint8, pointer []uint8, n int) []uint8 {
+	h.start()
+	if !h.write(addr << 1) {
+		t.Fatalf("device %#02x did not acknowledge its address", addr)
+	}
+	for _, b := range pointer {
+		if !h.write(b) {
+			t.Fatalf("device %#02x did not acknowledge %#02x", addr, b)
+		}
+	}
+	h.start()
+	if !h.write(addr<<1 | 1) {
+		t.Fatalf("device %#02x did not acknowledge a read", addr)
+	}
+	data := make([]uint8, n)
+	for i := range data {
+		data[i] = h.read(i < n-1)
+	}
+	h.stop()
+	return data
+}
+
+//i2cRecorder is a device that records what happens on the bus
+type i2cRecorder struct {
+	addr    uint8
+	events  []string
+	written []uint8
+	next    uint8
+}
+
+func (r *i2cRecorder) Start(addr uint8, read bool) bool {
+	if addr != r.addr {
+		return false
+	}
+	if read {
+		r.events = append(r.events, "read")
+	} else {
+		r.events = append(r.events, "write")
+	}
+	return true
+}
+
+func (r *i2cRecorder) Write(val uint8) bool {
+	r.written = append(r.written, val)
+	//the device takes two bytes per transfer
+	return len(r.written) < 2
+}
+
+func (r *i2cRecorder) Read() uint8 {
+	r.next++
+	return r.next
+}
+
+func (r *i2cRecorder) Stop() {
+	r.events = append(r.events, "stop")
+}
+
+func TestI2CBus(t *testing.T) {
+	h := newI2CHost(t)
+	r := &i2cRecorder{addr: 0x42}
+	h.bus.Add(r)
+	//nobody answers to other addresses
+	h.start()
+	if h.write(0x43 << 1) {
+		t.Fatal("address 43 was acknowledged")
+	}
+	h.stop()
+	//the device acknowledges the first byte but not the second
+	h.start()
+	if !h.write(0x42 << 1) {
+		t.Fatal("address 42 was not acknowledged")
+	}
+	if !h.write(0x12) || h.write(0x34) {
+		t.Fatal("the device did not acknowledge exactly the first byte")
+	}
+	//a repeated start reads without a stop in between
+	h.start()
+	h.write(0x42<<1 | 1)
+	first, second := h.read(true), h.read(false)
+	h.stop()
+	if first != 1 || second != 2 {
+		t.Fatalf("read %d and %d, want 1 and 2", first, second)
+	}
+	if len(r.written) != 2 || r.written[0] != 0x12 || r.written[1] != 0x34 {
+		t.Fatalf("device received % x, want 12 34", r.written)
+	}
+	want := []string{"write", "read", "stop"}
+	if len(r.events) != len(want) {
+		t.Fatalf("device saw %v, want %v", r.events, want)
+	}
+	for i := range want {
+		if r.events[i] != want[i] {
+			t.Fatalf("device saw %v, want %v", r.events, want)
+		}
+	}
+}
+
+func TestI2CClockStretching(t *testing.T) {
+	h := newI2CHost(t)
+	r := &i2cRecorder{addr: 0x42}
+	h.bus.Add(r)
+	h.bus.SetStretch(h.m, 50)
+	h.start()
+	h.write(0x42<<1 | 1)
+	val := h.read(false)
+	h.stop()
+	if val != 1 || h.stretched == 0 {
+		t.Fatalf("read %d with the clock stretched %d times, want 1 and stretching", val, h.stretched)
+	}
+}
@@ -0,0 +1,293 @@
+package devices
+
+import (
+	"fmt"
+
+	"github.com/rdzhaafar/emu6502/core"
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+func init() {
+	machine.RegisterDevice("intc", newInterruptControllerDevice)
+}
+
+//intcOptions are the options of an interrupt controller in a machine config
+type intcOptions struct {
+	//Sources are the names of the devices wired to inputs 0, 1 and so on
+	Sources []string `json:"sources"`
+}
+
+func newInterruptControllerDevice(options machine.Options) (machine.Device, error) {
+	var opt intcOptions
+	err := options.Decode(&opt)
+	if err != nil {
+		return nil, err
+	}
+	c := NewInterruptController()
+	for source, device := range opt.Sources {
+		err = c.Connect(device, source)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+//Registers of the interrupt controller
+const (
+	IntcPending  = 0x00 //sources asserting their line, read only
+	IntcEnable   = 0x01 //1 enables a source
+	IntcActive   = 0x02 //highest priority pending source, $FF if none, read only
+	IntcControl  = 0x03 //IntcVectoring
+	IntcNMI      = 0x04 //1 sends a source to the NMI output instead of IRQ
+	IntcPriority = 0x08 //priority of source n at IntcPriority+n
+	IntcVector   = 0x10 //vector of source n at IntcVector+2n, low byte first
+)
+
+//IntcVectoring is the control bit that makes the controller supply the IRQ
+//vector of the active source
+const IntcVectoring uint8 = 0x01
+
+//IntcSources is the number of inputs of an interrupt controller
+const IntcSources = 8
+
+//noSource is the value of IntcActive when no enabled source is pending
+const noSource = 0xff
+
+//InterruptController collects the IRQ lines of up to eight devices and
+//drives the cpu IRQ line while an enabled source is asserted, or the NMI line
+//for sources selected in IntcNMI. With vectoring enabled, it watches the VP
+//signal and puts the vector of the highest priority pending source on the
+//bus when the cpu pulls the IRQ or NMI vector, so every source gets a handler
+//of its own. Sources with the same priority are ranked by their number,
+//lowest first. It takes up 32 addresses.
+//
+//Outside of a machine, sources are set with SetInput and Deliver interrupts a
+//cpu from the outputs.
+type InterruptController struct {
+	inputs   [IntcSources]int //asserted lines per source
+	external uint8            //sources set with SetInput
+	enable   uint8
+	control  uint8
+	nmi      uint8
+	priority [IntcSources]uint8
+	vectors  [2 * IntcSources]uint8
+	pulled   uint8 //source whose vector is being pulled
+
+	irqOut  bool //level of the IRQ output
+	nmiOut  bool //level of the NMI output
+	nmiEdge bool //the NMI output was asserted and not delivered yet
+
+	m       *machine.Machine
+	irq     *machine.Line
+	nmiLine *machine.Line
+	sources map[string]int
+}
+
+//NewInterruptController returns a controller with all sources disabled
+func NewInterruptController() *InterruptController {
+	return &InterruptController{pulled: noSource, sources: make(map[string]int)}
+}
+
+//Connect wires the IRQ lines of the named device to a source input. Devices
+//can be connected before or after they are added to the machine.
+func (c *InterruptController) Connect(device string, source int) error {
+	if source < 0 || source >= IntcSources {
+		return fmt.Errorf("interrupt controller has no source %d", source)
+	}
+	c.sources[device] = source
+	if c.m != nil {
+		c.m.RouteIRQ(device, c.input(source))
+	}
+	return nil
+}
+
+//SetInput asserts or releases a source input directly, for sources that are
+//not machine devices
+func (c *InterruptController) SetInput(source int, asserted bool) error {
+	if source < 0 || source >= IntcSources {
+		return fmt.Errorf("interrupt controller has no source %d", source)
+	}
+	if asserted {
+		c.external |= 1 << source
+	} else {
+		c.external &^= 1 << source
+	}
+	c.update()
+	return nil
+}
+
+//IRQAsserted reports whether the IRQ output is asserted
+func (c *InterruptController) IRQAsserted() bool {
+	return c.irqOut
+}
+
+//NMIAsserted reports whether the NMI output is asserted
+func (c *InterruptController) NMIAsserted() bool {
+	return c.nmiOut
+}
+
+//Deliver interrupts cpu from the outputs of a controller that is not part of
+//a machine, and is called before every instruction. It sends an NMI once
+//every time the NMI output is asserted, and an IRQ while the IRQ output is.
+func (c *InterruptController) Deliver(cpu *core.CPU) {
+	if c.nmiEdge {
+		c.nmiEdge = false
+		cpu.NMInterrupt()
+	}
+	if c.irqOut {
+		cpu.Interrupt()
+	}
+}
+
+//input returns the function a source line is routed to
+func (c *InterruptController) input(source int) func(bool) {
+	return func(asserted bool) {
+		if asserted {
+			c.inputs[source]++
+		} else {
+			c.inputs[source]--
+		}
+		c.update()
+	}
+}
+
+//Size returns the number of addresses the controller takes up
+func (c *InterruptController) Size() int {
+	return 0x20
+}
+
+func (c *InterruptController) Attach(m *machine.Machine) error {
+	c.m = m
+	c.irq = m.IRQ()
+	c.nmiLine = m.NMI()
+	for device, source := range c.sources {
+		m.RouteIRQ(device, c.input(source))
+	}
+	return nil
+}
+
+//Reset disables all sources and vectoring and clears the NMI selection, the
+//priorities and the vectors
+func (c *InterruptController) Reset() {
+	c.enable = 0
+	c.control = 0
+	c.nmi = 0
+	c.nmiEdge = false
+	c.priority = [IntcSources]uint8{}
+	c.vectors = [2 * IntcSources]uint8{}
+	c.pulled = noSource
+	c.update()
+}
+
+//pending returns the sources asserting their line
+func (c *InterruptController) pending() uint8 {
+	var bits uint8
+	for source, n := range c.inputs {
+		if n > 0 {
+			bits |= 1 << source
+		}
+	}
+	return bits | c.external
+}
+
+//active returns the highest priority pending source that is enabled among
+//sources
+func (c *InterruptController) active(sources uint8) uint8 {
+	requests := c.pending() & c.enable & sources
+	active := uint8(noSource)
+	for source := 0; source < IntcSources; source++ {
+		if requests&(1<<source) == 0 {
+			continue
+		}
+		if active == noSource || c.priority[source] > c.priority[active] {
+			active = uint8(source)
+		}
+	}
+	return active
+}
+
+//update drives the IRQ and NMI lines of the cpu
+func (c *InterruptController) update() {
+	requests := c.pending() & c.enable
+	c.irqOut = requests&^c.nmi != 0
+	nmi := requests&c.nmi != 0
+	if nmi && !c.nmiOut {
+		c.nmiEdge = true
+	}
+	c.nmiOut = nmi
+	if c.irq != nil {
+		c.irq.Set(c.irqOut)
+		c.nmiLine.Set(c.nmiOut)
+	}
+}
+
+func (c *InterruptController) Read(addr uint16) uint8 {
+	addr &= 0x1f
+	switch {
+	case addr == IntcPending:
+		return c.pending()
+	case addr == IntcEnable:
+		return c.enable
+	case addr == IntcActive:
+		return c.active(0xff)
+	case addr == IntcControl:
+		return c.control
+	case addr == IntcNMI:
+		return c.nmi
+	case addr >= IntcPriority && addr < IntcPriority+IntcSources:
+		return c.priority[addr-IntcPriority]
+	case addr >= IntcVector:
+		return c.vectors[addr-IntcVector]
+	}
+	return 0
+}
+
+func (c *InterruptController) Write(addr uint16, val uint8) error {
+	addr &= 0x1f
+	switch {
+	case addr == IntcEnable:
+		c.enable = val
+		c.update()
+	case addr == IntcControl:
+		c.control = val & IntcVectoring
+	case addr == IntcNMI:
+		c.nmi = val
+		c.update()
+	case addr >= IntcPriority && addr < IntcPriority+IntcSources:
+		c.priority[addr-IntcPriority] = val
+	case addr >= IntcVector:
+		c.vectors[addr-IntcVector] = val
+	}
+	return nil
+}
+
+//PullVector supplies the vector of the active source when vectoring is
+//enabled and the cpu pulls the IRQ or NMI vector. The source is picked among
+//the ones sent to that output when the low byte is pulled, so both bytes
+//belong to the same source.
+func (c *InterruptController) PullVector(addr uint16) (uint8, bool) {
+	if c.control&IntcVectoring == 0 {
+		return 0, false
+	}
+	switch addr {
+	case 0xfffa, 0xfffe:
+		sources := ^c.nmi
+		if addr == 0xfffa {
+			sources = c.nmi
+		}
+		c.pulled = c.active(sources)
+		if c.pulled == noSource {
+			return 0, false
+		}
+		return c.vectors[2*c.pulled], true
+	case 0xfffb, 0xffff:
+		source := c.pulled
+		c.pulled = noSource
+		if source == noSource {
+			return 0, false
+		}
+		return c.vectors[2*source+1], true
+	}
+	return 0, false
+}
@@ -0,0 +1,72 @@
+package devices
+
+import "testing"
+
+func TestInterruptControllerPriorities(t *testing.T) {
+	m := newTestMachine(t)
+	c := NewInterruptController()
+	addDevice(t, m, "intc", c)
+	slow, fast := NewIntervalTimer(), NewIntervalTimer()
+	addDevice(t, m, "slow", slow)
+	addDevice(t, m, "fast", fast)
+	c.Connect("slow", 2)
+	c.Connect("fast", 5)
+	c.Write(IntcPriority+2, 1)
+	startTimer(slow, 100, TimerIRQEnable)
+	startTimer(fast, 200, TimerIRQEnable)
+	m.Run(150)
+	if c.Read(IntcPending) != 1<<2 || m.IRQAsserted() {
+		t.Fatal("a disabled source interrupted")
+	}
+	c.Write(IntcEnable, 1<<2|1<<5)
+	if c.Read(IntcActive) != 2 || !m.IRQAsserted() {
+		t.Fatalf("active source is %d, want 2", c.Read(IntcActive))
+	}
+	m.Run(100)
+	//source 2 has the higher priority, though source 5 has the higher number
+	if c.Read(IntcPending) != 1<<2|1<<5 || c.Read(IntcActive) != 2 {
+		t.Fatalf("active source is %d with both pending, want 2", c.Read(IntcActive))
+	}
+	slow.Write(TimerStatus, TimerExpired)
+	if c.Read(IntcActive) != 5 || !m.IRQAsserted() {
+		t.Fatalf("active source is %d, want 5", c.Read(IntcActive))
+	}
+	fast.Write(TimerStatus, TimerExpired)
+	if c.Read(IntcActive) != noSource || m.IRQAsserted() {
+		t.Fatal("IRQ is asserted with no source pending")
+	}
+}
+
+func TestInterruptControllerVectoring(t *testing.T) {
+	c := NewInterruptController()
+	c.Write(IntcEnable, 0xff)
+	c.Write(IntcControl, IntcVectoring)
+	c.Write(IntcNMI, 1<<1)
+	for source := uint16(0); source < IntcSources; source++ {
+		c.Write(IntcVector+2*source, uint8(source))
+		c.Write(IntcVector+2*source+1, 0x80)
+	}
+	c.SetInput(1, true)
+	c.SetInput(3, true)
+	if !c.IRQAsserted() || !c.NMIAsserted() {
+		t.Fatal("sources did not reach their outputs")
+	}
+	pull := func(addr uint16) uint16 {
+		lo, ok := c.PullVector(addr)
+		hi, ok2 := c.PullVector(addr + 1)
+		if !ok || !ok2 {
+			t.Fatalf("no vector supplied at %#04x", addr)
+		}
+		return uint16(hi)<<8 | uint16(lo)
+	}
+	if vector := pull(0xfffe); vector != 0x8003 {
+		t.Fatalf("IRQ vector is %#04x, want 8003", vector)
+	}
+	if vector := pull(0xfffa); vector != 0x8001 {
+		t.Fatalf("NMI vector is %#04x, want 8001", vector)
+	}
+	//the reset vector comes from the bus
+	if _, ok := c.PullVector(0xfffc); ok {
+		t.Fatal("the reset vector was supplied")
+	}
+}
@@ -0,0 +1,477 @@
+package devices
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+func init() {
+	machine.RegisterDevice("lcd", newLCDDevice)
+}
+
+//lcdOptions are the options of an LCD in a machine config. Pins are numbered
+//0 to 7.
+type lcdOptions struct {
+	Rows int `json:"rows"`
+	Cols int `json:"cols"`
+	//Device is the device whose ports the LCD is wired to. The LCD is not
+	//wired to anything without it.
+	Device    string `json:"device"`
+	Data      string `json:"data"`       //port the data lines are wired to
+	DataPin   int    `json:"data_pin"`   //pin of D0, or of D4 with 4 data lines
+	DataLines int    `json:"data_lines"` //4 or 8
+	Control   string `json:"control"`    //port RS, RW and E are wired to
+	RS        int    `json:"rs"`
+	RW        int    `json:"rw"` //-1 if RW is tied low
+	E         int    `json:"e"`
+}
+
+func newLCDDevice(options machine.Options) (machine.Device, error) {
+	//the wiring of the 6502 breadboard computer
+	opt := lcdOptions{
+		Rows:      2,
+		Cols:      16,
+		Data:      "b",
+		DataLines: 8,
+		Control:   "a",
+		RS:        5,
+		RW:        6,
+		E:         7,
+	}
+	err := options.Decode(&opt)
+	if err != nil {
+		return nil, err
+	}
+	l, err := NewLCD(opt.Rows, opt.Cols)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Device == "" {
+		return l, nil
+	}
+	if opt.DataLines != 4 && opt.DataLines != 8 {
+		return nil, fmt.Errorf("an LCD has 4 or 8 data lines, not %d", opt.DataLines)
+	}
+	if opt.DataPin < 0 || opt.DataPin+opt.DataLines > 8 {
+		return nil, fmt.Errorf("%d data lines do not fit on a port from pin %d", opt.DataLines, opt.DataPin)
+	}
+	pins := map[string]int{"rs": opt.RS, "e": opt.E}
+	if opt.RW != -1 {
+		pins["rw"] = opt.RW
+	}
+	for name, pin := range pins {
+		if pin < 0 || pin > 7 {
+			return nil, fmt.Errorf("%s is wired to pin %d, ports have pins 0 to 7", name, pin)
+		}
+	}
+	l.wireTo = &opt
+	return l, nil
+}
+
+//LCDWiring describes how an LCD is wired to ports
+type LCDWiring struct {
+	Data *Port
+	//DataPin is the port pin of the lowest data line, D0 with 8 data lines
+	//and D4 with 4
+	DataPin   int
+	DataLines int //4 or 8
+	Control   *Port
+	//RS, RW and E are the pins of the control lines on the control port, as
+	//masks. RW is 0 if it is tied low, which makes the LCD write only.
+	RS, RW, E uint8
+}
+
+//Execution times of the LCD instructions
+const (
+	lcdLongInstruction  = 1520 * time.Microsecond //clear display and return home
+	lcdShortInstruction = 37 * time.Microsecond
+)
+
+//Sizes of the LCD memories
+const (
+	lcdLineLength = 40 //DDRAM addresses per line in two line mode
+	lcdDDRAMSize  = 80
+	lcdCGRAMSize  = 64
+)
+
+//LCD is an HD44780 character LCD controller with the A00 character ROM. It
+//is driven through its E, RS and RW lines and 4 or 8 data lines, which are
+//wired to ports with Connect. Data and instructions are latched on the
+//falling edge of E, and read back while E is high.
+//
+//Instructions take as long as on the real chip, 1.52ms to clear the display
+//or return home and 37us for the others. The busy flag is set meanwhile and
+//data and instructions written while it is set are ignored, so the LCD has
+//to be added to a machine to time them. The LCD keeps its contents when the
+//machine is reset, as it has no reset pin.
+type LCD struct {
+	rows, cols int
+
+	ddram   [0x80]uint8 //by address, 00-27 and 40-67 in two line mode
+	cgram   [lcdCGRAMSize]uint8
+	ac      uint8 //address counter
+	cgMode  bool  //the address counter points into CGRAM
+	inc     bool  //the address counter increments
+	shiftOn bool  //writes shift the display
+	shift   int   //display shift to the left
+	display bool
+	cursor  bool
+	blink   bool
+	bits8   bool //8-bit interface
+	lines2  bool //two line mode
+	font10  bool //5x10 dot font
+
+	busyUntil uint64
+	low       bool  //the low nibble is transferred next in 4-bit mode
+	latch     uint8 //high nibble of a write in 4-bit mode
+	readValue uint8 //byte being read in 4-bit mode
+
+	wiring LCDWiring
+	driver *PortDriver
+	wireTo *lcdOptions //wiring to set up when the LCD is attached
+
+	m *machine.Machine
+}
+
+//NewLCD returns an LCD with rows lines of cols characters each after its
+//power on reset. The display is cleared and off, and the interface is 8-bit.
+func NewLCD(rows, cols int) (*LCD, error) {
+	if rows < 1 || rows > 4 || cols < 1 || rows*cols > lcdDDRAMSize {
+		return nil, fmt.Errorf("an HD44780 can not drive %d lines of %d characters", rows, cols)
+	}
+	l := &LCD{rows: rows, cols: cols, bits8: true}
+	l.clear()
+	return l, nil
+}
+
+//Connect wires the LCD to ports
+func (l *LCD) Connect(wiring LCDWiring) error {
+	if wiring.DataLines != 4 && wiring.DataLines != 8 {
+		return fmt.Errorf("an LCD has 4 or 8 data lines, not %d", wiring.DataLines)
+	}
+	l.wiring = wiring
+	l.driver = wiring.Data.Driver()
+	wiring.Control.Watch(l.controlChanged)
+	return nil
+}
+
+func (l *LCD) Attach(m *machine.Machine) error {
+	l.m = m
+	opt := l.wireTo
+	if opt == nil {
+		return nil
+	}
+	l.wireTo = nil
+	data, err := portOf(m, opt.Device, opt.Data)
+	if err != nil {
+		return err
+	}
+	control, err := portOf(m, opt.Device, opt.Control)
+	if err != nil {
+		return err
+	}
+	wiring := LCDWiring{
+		Data:      data,
+		DataPin:   opt.DataPin,
+		DataLines: opt.DataLines,
+		Control:   control,
+		RS:        1 << opt.RS,
+		E:         1 << opt.E,
+	}
+	if opt.RW != -1 {
+		wiring.RW = 1 << opt.RW
+	}
+	return l.Connect(wiring)
+}
+
+//Reset does nothing, since the LCD has no reset pin
+func (l *LCD) Reset() {
+}
+
+//busy reports whether the LCD is executing an instruction
+func (l *LCD) busy() bool {
+	return l.m != nil && l.m.Now() < l.busyUntil
+}
+
+//execute makes the LCD busy for d
+func (l *LCD) execute(d time.Duration) {
+	if l.m != nil {
+		l.busyUntil = l.m.Now() + l.m.Cycles(d)
+	}
+}
+
+//dataMask returns the port pins of the data lines
+func (l *LCD) dataMask() uint8 {
+	return uint8((1<<l.wiring.DataLines - 1) << l.wiring.DataPin)
+}
+
+//dataBus returns the levels on the data lines of the LCD. Data lines that are
+//not wired are pulled up.
+func (l *LCD) dataBus() uint8 {
+	val := (l.wiring.Data.Pins() & l.dataMask()) >> l.wiring.DataPin
+	if l.wiring.DataLines == 4 {
+		return val<<4 | 0x0f
+	}
+	return val
+}
+
+//driveDataBus drives the wired data lines with val
+func (l *LCD) driveDataBus(val uint8) {
+	if l.wiring.DataLines == 4 {
+		val >>= 4
+	}
+	l.driver.Drive(l.dataMask(), val<<l.wiring.DataPin)
+}
+
+func (l *LCD) controlChanged(old, pins uint8) {
+	w := l.wiring
+	rs := pins&w.RS != 0
+	read := pins&w.RW != 0
+	rising := old&w.E == 0 && pins&w.E != 0
+	falling := old&w.E != 0 && pins&w.E == 0
+	switch {
+	case rising && read:
+		l.driveDataBus(l.readNibble(rs))
+	case falling && read:
+		l.driver.Release(0xff)
+		l.transferred(rs, true)
+	case falling:
+		l.writeNibble(rs, l.dataBus())
+	case !read && l.driver.mask != 0:
+		//RW went low while E is high
+		l.driver.Release(0xff)
+	}
+}
+
+//readNibble returns what the LCD puts on the data bus when E goes high for a
+//read, in the high nibble in 4-bit mode
+func (l *LCD) readNibble(rs bool) uint8 {
+	if !l.bits8 && l.low {
+		return l.readValue << 4
+	}
+	if rs {
+		l.readValue = l.readData()
+	} else {
+		l.readValue = l.ac & 0x7f
+		if l.busy() {
+			l.readValue |= 0x80
+		}
+	}
+	return l.readValue
+}
+
+//transferred finishes the transfer of a byte or a nibble and reports whether
+//the byte is complete
+func (l *LCD) transferred(rs, read bool) bool {
+	if !l.bits8 {
+		l.low = !l.low
+		if l.low {
+			return false
+		}
+	}
+	if read && rs {
+		//reading data moves the address counter
+		l.move(l.inc)
+	}
+	return true
+}
+
+func (l *LCD) writeNibble(rs bool, val uint8) {
+	if !l.bits8 && !l.low {
+		l.latch = val & 0xf0
+	} else if !l.bits8 {
+		val = l.latch | val>>4
+	}
+	if !l.transferred(rs, false) {
+		return
+	}
+	if l.busy() {
+		return
+	}
+	if rs {
+		l.writeData(val)
+	} else {
+		l.instruction(val)
+	}
+}
+
+//readData returns the byte the address counter points to
+func (l *LCD) readData() uint8 {
+	if l.cgMode {
+		return l.cgram[l.ac&0x3f]
+	}
+	return l.ddram[l.ac&0x7f]
+}
+
+func (l *LCD) writeData(val uint8) {
+	if l.cgMode {
+		l.cgram[l.ac&0x3f] = val
+	} else {
+		l.ddram[l.ac&0x7f] = val
+	}
+	l.move(l.inc)
+	if l.shiftOn && !l.cgMode {
+		l.shiftDisplay(l.inc)
+	}
+	l.execute(lcdShortInstruction)
+}
+
+//move increments or decrements the address counter. DDRAM addresses wrap
+//around from the end of a line to the start of the next one.
+func (l *LCD) move(inc bool) {
+	if l.cgMode {
+		if inc {
+			l.ac = (l.ac + 1) & 0x3f
+		} else {
+			l.ac = (l.ac - 1) & 0x3f
+		}
+		return
+	}
+	switch {
+	case !l.lines2 && inc:
+		l.ac = (l.ac + 1) % lcdDDRAMSize
+	case !l.lines2:
+		l.ac = (l.ac + lcdDDRAMSize - 1) % lcdDDRAMSize
+	case inc && l.ac == 0x27:
+		l.ac = 0x40
+	case inc && l.ac >= 0x67:
+		l.ac = 0
+	case inc:
+		l.ac++
+	case l.ac == 0x40:
+		l.ac = 0x27
+	case l.ac == 0:
+		l.ac = 0x67
+	default:
+		l.ac--
+	}
+}
+
+//shiftDisplay shifts the display left or right by a character
+func (l *LCD) shiftDisplay(left bool) {
+	n := l.lineLength()
+	if left {
+		l.shift = (l.shift + 1) % n
+	} else {
+		l.shift = (l.shift + n - 1) % n
+	}
+}
+
+//lineLength returns the number of DDRAM addresses per line
+func (l *LCD) lineLength() int {
+	if l.lines2 {
+		return lcdLineLength
+	}
+	return lcdDDRAMSize
+}
+
+func (l *LCD) clear() {
+	for i := range l.ddram {
+		l.ddram[i] = ' '
+	}
+	l.ac = 0
+	l.cgMode = false
+	l.inc = true
+	l.shift = 0
+}
+
+//instruction executes an instruction
+func (l *LCD) instruction(val uint8) {
+	switch {
+	case val&0x80 != 0: //set DDRAM address
+		l.ac = val & 0x7f
+		l.cgMode = false
+	case val&0x40 != 0: //set CGRAM address
+		l.ac = val & 0x3f
+		l.cgMode = true
+	case val&0x20 != 0: //function set
+		l.bits8 = val&0x10 != 0
+		l.lines2 = val&0x08 != 0
+		l.font10 = val&0x04 != 0
+		l.low = false
+		l.shift %= l.lineLength()
+	case val&0x10 != 0: //cursor or display shift
+		if val&0x08 != 0 {
+			l.shiftDisplay(val&0x04 == 0)
+		} else {
+			l.cgMode = false
+			l.move(val&0x04 != 0)
+		}
+	case val&0x08 != 0: //display on/off control
+		l.display = val&0x04 != 0
+		l.cursor = val&0x02 != 0
+		l.blink = val&0x01 != 0
+	case val&0x04 != 0: //entry mode set
+		l.inc = val&0x02 != 0
+		l.shiftOn = val&0x01 != 0
+	case val&0x02 != 0: //return home
+		l.ac = 0
+		l.cgMode = false
+		l.shift = 0
+		l.execute(lcdLongInstruction)
+		return
+	case val&0x01 != 0: //clear display
+		l.clear()
+		l.execute(lcdLongInstruction)
+		return
+	}
+	l.execute(lcdShortInstruction)
+}
+
+//address returns the DDRAM address shown at a row and column
+func (l *LCD) address(row, col int) uint8 {
+	if !l.lines2 {
+		return uint8((col + l.shift) % lcdDDRAMSize)
+	}
+	//four line displays continue lines 1 and 2 on rows 3 and 4
+	base := 0x40 * (row % 2)
+	col += row / 2 * l.cols
+	return uint8(base + (col+l.shift)%lcdLineLength)
+}
+
+//lcdRune returns the character of the A00 character ROM for a character code
+func lcdRune(c uint8) rune {
+	switch {
+	case c == 0x5c:
+		return '¥'
+	case c == 0x7e:
+		return '→'
+	case c == 0x7f:
+		return '←'
+	case c >= 0x20 && c < 0x7e:
+		return rune(c)
+	case c >= 0xa1 && c <= 0xdf:
+		//half width katakana
+		return rune(0xff61 + int(c) - 0xa1)
+	}
+	//custom characters and the rest of the ROM
+	return '?'
+}
+
+//Lines returns the text shown on every row of the display. Custom characters
+//and symbols that are not in Unicode show as '?'. A display that is off, and
+//the rows after the first in one line mode, show spaces.
+func (l *LCD) Lines() []string {
+	lines := make([]string, l.rows)
+	for row := range lines {
+		var b strings.Builder
+		for col := 0; col < l.cols; col++ {
+			//one line mode only drives the first row
+			if l.display && (l.lines2 || row == 0) {
+				b.WriteRune(lcdRune(l.ddram[l.address(row, col)]))
+			} else {
+				b.WriteByte(' ')
+			}
+		}
+		lines[row] = b.String()
+	}
+	return lines
+}
+
+//Text returns the rows of the display separated by newlines
+func (l *LCD) Text() string {
+	return strings.Join(l.Lines(), "\n")
+}
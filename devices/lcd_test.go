@@ -0,0 +1,132 @@
+package devices
+
+import (
+	"testing"
+	"time"
+)
+
+//Control lines of the LCD in the tests, on VIA port A
+const (
+	testLCDE  uint8 = 0x80
+	testLCDRW uint8 = 0x40
+	testLCDRS uint8 = 0x20
+)
+
+//newTestLCD returns a 2x16 LCD with D4-D7 on PB4-PB7 and its control lines
+//on port A of v
+func newTestLCD(t *testing.T) (*LCD, *VIA, func(d time.Duration)) {
+	m := newTestMachine(t)
+	v := NewVIA()
+	addDevice(t, m, "via", v)
+	l, err := NewLCD(2, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Connect(LCDWiring{
+		Data:      v.PortB,
+		DataPin:   4,
+		DataLines: 4,
+		Control:   v.PortA,
+		RS:        testLCDRS,
+		RW:        testLCDRW,
+		E:         testLCDE,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addDevice(t, m, "lcd", l)
+	v.Write(ViaDDRA, testLCDE|testLCDRW|testLCDRS)
+	v.Write(ViaDDRB, 0xf0)
+	wait := func(d time.Duration) {
+		m.Run(m.Cycles(d))
+	}
+	return l, v, wait
+}
+
+//lcdNibble writes the high nibble of val to the LCD
+func lcdNibble(v *VIA, rs uint8, val uint8) {
+	v.Write(ViaORB, val&0xf0)
+	v.Write(ViaORA, rs)
+	v.Write(ViaORA, rs|testLCDE)
+	v.Write(ViaORA, rs)
+}
+
+//lcdRead reads a byte from the LCD in 4-bit mode
+func lcdRead(v *VIA, rs uint8) uint8 {
+	v.Write(ViaDDRB, 0x00)
+	var val uint8
+	for i := 0; i < 2; i++ {
+		v.Write(ViaORA, rs|testLCDRW)
+		v.Write(ViaORA, rs|testLCDRW|testLCDE)
+		val = val<<4 | v.Read(ViaORB)>>4
+		v.Write(ViaORA, rs|testLCDRW)
+	}
+	v.Write(ViaDDRB, 0xf0)
+	return val
+}
+
+//lcdInit sets the LCD up like firmware does. The pins of the VIA float high
+//until it drives them, so the LCD may have latched garbage before.
+func lcdInit(v *VIA, wait func(d time.Duration)) {
+	//the reset sequence for 4-bit mode, then two lines, display on, the
+	//cursor moving right and a cleared display
+	for _, nibble := range []uint8{0x30, 0x30, 0x30, 0x20} {
+		lcdNibble(v, 0, nibble)
+		wait(5 * time.Millisecond)
+	}
+	for _, instruction := range []uint8{0x28, 0x0c, 0x06, 0x01} {
+		lcdNibble(v, 0, instruction)
+		lcdNibble(v, 0, instruction<<4)
+		wait(2 * time.Millisecond)
+	}
+}
+
+func TestLCD4BitMode(t *testing.T) {
+	l, v, wait := newTestLCD(t)
+	lcdInit(v, wait)
+	for _, c := range []uint8("Hello") {
+		lcdNibble(v, testLCDRS, c)
+		lcdNibble(v, testLCDRS, c<<4)
+		wait(50 * time.Microsecond)
+	}
+	if lines := l.Lines(); lines[0] != "Hello           " || lines[1] != "                " {
+		t.Fatalf("LCD shows %q, want \"Hello\"", lines)
+	}
+	if status := lcdRead(v, 0); status != 0x05 {
+		t.Fatalf("address counter is %#02x, want 05", status)
+	}
+	//return home takes 1.52ms
+	lcdNibble(v, 0, 0x00)
+	lcdNibble(v, 0, 0x20)
+	if status := lcdRead(v, 0); status != 0x80 {
+		t.Fatalf("status is %#02x while returning home, want busy at 00", status)
+	}
+	wait(time.Millisecond)
+	if status := lcdRead(v, 0); status&0x80 == 0 {
+		t.Fatalf("status is %#02x after 1ms, want busy", status)
+	}
+	wait(time.Millisecond)
+	if status := lcdRead(v, 0); status != 0x00 {
+		t.Fatalf("status is %#02x after returning home, want 00", status)
+	}
+	//reading data moves the address counter
+	if c := lcdRead(v, testLCDRS); c != 'H' {
+		t.Fatalf("read %q, want 'H'", c)
+	}
+	if c := lcdRead(v, testLCDRS); c != 'e' {
+		t.Fatalf("read %q, want 'e'", c)
+	}
+}
+
+func TestLCDIgnoresWritesWhileBusy(t *testing.T) {
+	l, v, wait := newTestLCD(t)
+	lcdInit(v, wait)
+	//the second character comes in before the first one is written
+	for _, c := range []uint8("ab") {
+		lcdNibble(v, testLCDRS, c)
+		lcdNibble(v, testLCDRS, c<<4)
+	}
+	if line := l.Lines()[0]; line != "a               " {
+		t.Fatalf("LCD shows %q, want \"a\"", line)
+	}
+}
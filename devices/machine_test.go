@@ -0,0 +1,32 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/rdzhaafar/emu6502/core"
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+//newTestMachine returns a machine with RAM below 8000 and a stopped cpu, so
+//that devices only see the accesses of the test while time passes in m.Run
+func newTestMachine(t *testing.T) *machine.Machine {
+	ram := core.NewRAM(0x8000)
+	ram.Load(0x0000, []uint8{0xdb}) //STP
+	bus := core.NewMappedBus()
+	bus.Map("ram", 0x0000, 0x7fff, ram)
+	m := machine.New(bus)
+	err := m.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+//addDevice adds device to m. Tests access device registers directly, at
+//addresses relative to the device.
+func addDevice(t *testing.T, m *machine.Machine, name string, device machine.Device) {
+	err := m.Add(name, device)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
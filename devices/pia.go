@@ -0,0 +1,217 @@
+package devices
+
+import "github.com/rdzhaafar/emu6502/machine"
+
+func init() {
+	machine.RegisterDevice("pia", func(options machine.Options) (machine.Device, error) {
+		return NewPIA(), nil
+	})
+}
+
+//Registers of the PIA
+const (
+	PiaDataA    = 0x0 //port A or DDRA, selected by CRA
+	PiaControlA = 0x1
+	PiaDataB    = 0x2 //port B or DDRB, selected by CRB
+	PiaControlB = 0x3
+)
+
+//Interrupt flags in the control registers, which are read only
+const (
+	PiaIRQ1 uint8 = 0x80 //active edge on C1
+	PiaIRQ2 uint8 = 0x40 //active edge on C2 while it is an input
+)
+
+//Bits of the control registers
+const (
+	crC1Enable   uint8 = 0x01
+	crC1Positive uint8 = 0x02
+	crData       uint8 = 0x04 //the data register is selected instead of the DDR
+	crC2Enable   uint8 = 0x08 //C2 input interrupt enable, or pulse mode
+	crC2Positive uint8 = 0x10 //C2 input active edge, or manual output mode
+	crC2Output   uint8 = 0x20
+	crC2Level    uint8 = 0x08 //level of C2 in manual output mode
+	crWritable   uint8 = 0x3f
+)
+
+//PIA is a Motorola 6821 or MOS 6520 peripheral interface adapter. It has two
+//8-bit ports with data direction registers and the CA1/CA2 and CB1/CB2
+//handshake lines, and drives separate IRQA and IRQB lines. It takes up 4
+//addresses.
+//
+//Peripherals are wired to PortA and PortB, and to the handshake lines on CA
+//and CB, where PinC1 and PinC2 are the C1 and C2 lines. Pulse outputs on C2
+//last one cycle, so the PIA has to be added to a machine for them to end.
+type PIA struct {
+	PortA *Port
+	PortB *Port
+	CA    *Port
+	CB    *Port
+
+	a, b *piaSide
+}
+
+//piaSide is one of the two halves of a PIA
+type piaSide struct {
+	port *Port
+	c    *Port
+
+	or, ddr uint8
+	cr      uint8
+	c2      bool //level driven on C2 in output modes
+	b       bool //the B side, whose C2 strobe is on writes
+	pulse   *machine.Event
+
+	m   *machine.Machine
+	irq *machine.Line
+}
+
+//NewPIA returns a PIA in its reset state
+func NewPIA() *PIA {
+	p := &PIA{a: newPIASide(false), b: newPIASide(true)}
+	p.PortA, p.CA = p.a.port, p.a.c
+	p.PortB, p.CB = p.b.port, p.b.c
+	p.reset()
+	return p
+}
+
+func newPIASide(b bool) *piaSide {
+	s := &piaSide{port: NewPort(), c: NewPort(), b: b}
+	s.c.Watch(s.cChanged)
+	return s
+}
+
+//Size returns the number of addresses the PIA takes up
+func (p *PIA) Size() int {
+	return 4
+}
+
+func (p *PIA) Attach(m *machine.Machine) error {
+	for _, s := range []*piaSide{p.a, p.b} {
+		s.m = m
+		s.irq = m.IRQ()
+	}
+	return nil
+}
+
+//Reset clears every register, like the RESET pin
+func (p *PIA) Reset() {
+	p.reset()
+}
+
+func (p *PIA) reset() {
+	for _, s := range []*piaSide{p.a, p.b} {
+		s.or, s.ddr, s.cr = 0, 0, 0
+		s.c2 = true
+		if s.pulse != nil {
+			s.pulse.Cancel()
+			s.pulse = nil
+		}
+		s.update()
+	}
+}
+
+//side returns the side an address belongs to
+func (p *PIA) side(addr uint16) *piaSide {
+	if addr&0x2 == 0 {
+		return p.a
+	}
+	return p.b
+}
+
+func (p *PIA) Read(addr uint16) uint8 {
+	s := p.side(addr)
+	if addr&0x1 != 0 {
+		return s.cr
+	}
+	if s.cr&crData == 0 {
+		return s.ddr
+	}
+	//reading the data register clears the interrupt flags
+	s.cr &^= PiaIRQ1 | PiaIRQ2
+	if !s.b {
+		s.strobe()
+		s.update()
+		return s.port.Pins()
+	}
+	s.update()
+	//port B output pins read back from the output register
+	return s.or&s.ddr | s.port.Pins()&^s.ddr
+}
+
+func (p *PIA) Write(addr uint16, val uint8) error {
+	s := p.side(addr)
+	switch {
+	case addr&0x1 != 0:
+		s.cr = s.cr&^crWritable | val&crWritable
+		if s.cr&crC2Output == 0 {
+			s.cr &^= PiaIRQ2
+		}
+		if s.cr&(crC2Output|crC2Positive) == crC2Output|crC2Positive {
+			s.c2 = s.cr&crC2Level != 0
+		}
+	case s.cr&crData == 0:
+		s.ddr = val
+	default:
+		s.or = val
+		if s.b {
+			s.strobe()
+		}
+	}
+	s.update()
+	return nil
+}
+
+//strobe pulls C2 low in the handshake and pulse output modes. It is called on
+//reads of port A and writes to port B.
+func (s *piaSide) strobe() {
+	if s.cr&(crC2Output|crC2Positive) != crC2Output {
+		return
+	}
+	s.c2 = false
+	if s.cr&crC2Enable != 0 && s.m != nil {
+		//pulse mode: C2 goes back high after a cycle
+		if s.pulse != nil {
+			s.pulse.Cancel()
+		}
+		s.pulse = s.m.After(1, func() {
+			s.pulse = nil
+			s.c2 = true
+			s.update()
+		})
+	}
+}
+
+//update drives the pins and the IRQ line from the registers
+func (s *piaSide) update() {
+	s.port.set(s.or, s.ddr)
+	var c, cdir uint8
+	if s.cr&crC2Output != 0 {
+		c, cdir = boolBit(s.c2, PinC2), PinC2
+	}
+	s.c.set(c, cdir)
+	if s.irq != nil {
+		irq1 := s.cr&PiaIRQ1 != 0 && s.cr&crC1Enable != 0
+		irq2 := s.cr&PiaIRQ2 != 0 && s.cr&crC2Enable != 0
+		s.irq.Set(irq1 || irq2)
+	}
+}
+
+func (s *piaSide) cChanged(old, pins uint8) {
+	changed := false
+	if edge(old, pins, PinC1, s.cr&crC1Positive != 0) {
+		s.cr |= PiaIRQ1
+		if s.cr&(crC2Output|crC2Positive|crC2Enable) == crC2Output {
+			//handshake mode: the peripheral answered the strobe
+			s.c2 = true
+		}
+		changed = true
+	}
+	if s.cr&crC2Output == 0 && edge(old, pins, PinC2, s.cr&crC2Positive != 0) {
+		s.cr |= PiaIRQ2
+		changed = true
+	}
+	if changed {
+		s.update()
+	}
+}
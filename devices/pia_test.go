@@ -0,0 +1,84 @@
+package devices
+
+import "testing"
+
+func TestPIAReadHandshake(t *testing.T) {
+	m := newTestMachine(t)
+	p := NewPIA()
+	addDevice(t, m, "pia", p)
+	peripheral := p.PortA.Driver()
+	strobe := p.CA.Driver()
+	//port A selected, CA1 interrupts on falling edges, CA2 handshake output
+	p.Write(PiaControlA, crData|crC2Output|crC1Enable)
+	if p.CA.Pins()&PinC2 == 0 {
+		t.Fatal("CA2 is low before the first read")
+	}
+	for _, val := range []uint8{0x12, 0x34} {
+		//the peripheral puts a byte on the port and signals it on CA1
+		peripheral.Drive(0xff, val)
+		strobe.Drive(PinC1, 0)
+		strobe.Release(PinC1)
+		if p.Read(PiaControlA)&PiaIRQ1 == 0 || !m.IRQAsserted() {
+			t.Fatalf("%#02x: CA1 did not interrupt", val)
+		}
+		if got := p.Read(PiaDataA); got != val {
+			t.Fatalf("read %#02x, want %#02x", got, val)
+		}
+		if p.Read(PiaControlA)&PiaIRQ1 != 0 || m.IRQAsserted() {
+			t.Fatalf("%#02x: reading the port did not clear the interrupt", val)
+		}
+		//CA2 tells the peripheral the byte was taken until the next one
+		//comes in
+		if p.CA.Pins()&PinC2 != 0 {
+			t.Fatalf("%#02x: CA2 is high after reading the port", val)
+		}
+	}
+	strobe.Drive(PinC1, 0)
+	if p.CA.Pins()&PinC2 == 0 {
+		t.Fatal("CA2 is still low after CA1 signaled the next byte")
+	}
+}
+
+func TestPIAWritePulse(t *testing.T) {
+	m := newTestMachine(t)
+	p := NewPIA()
+	addDevice(t, m, "pia", p)
+	p.Write(PiaDataB, 0xff)
+	//port B selected, CB2 pulses low after every write
+	p.Write(PiaControlB, crData|crC2Output|crC2Enable)
+	var pulses int
+	p.CB.Watch(func(old, pins uint8) {
+		if edge(old, pins, PinC2, false) {
+			pulses++
+		}
+	})
+	p.Write(PiaDataB, 0x5a)
+	if p.PortB.Pins() != 0x5a || pulses != 1 {
+		t.Fatalf("port B is %#02x after %d pulses, want 5a after 1", p.PortB.Pins(), pulses)
+	}
+	m.Run(2)
+	if p.CB.Pins()&PinC2 == 0 {
+		t.Fatal("CB2 is still low a cycle after the write")
+	}
+}
+
+func TestPIAC2Input(t *testing.T) {
+	m := newTestMachine(t)
+	p := NewPIA()
+	addDevice(t, m, "pia", p)
+	c := p.CB.Driver()
+	//CB2 interrupts on rising edges
+	p.Write(PiaControlB, crData|crC2Enable|crC2Positive)
+	c.Drive(PinC2, 0)
+	if m.IRQAsserted() {
+		t.Fatal("CB2 interrupted on a falling edge")
+	}
+	c.Release(PinC2)
+	if p.Read(PiaControlB)&PiaIRQ2 == 0 || !m.IRQAsserted() {
+		t.Fatal("CB2 did not interrupt on a rising edge")
+	}
+	p.Read(PiaDataB)
+	if m.IRQAsserted() {
+		t.Fatal("reading port B did not clear the interrupt")
+	}
+}
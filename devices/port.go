@@ -0,0 +1,128 @@
+package devices
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+//Port is a group of up to eight pins between a chip and the peripherals wired
+//to it. The chip drives the pins selected by its data direction register.
+//Every pin is pulled up, and the chip and the peripherals can pull it low, so
+//a pin reads 0 if anything drives it low. This covers push-pull outputs as
+//well as open drain buses like I2C.
+type Port struct {
+	output    uint8 //levels driven by the chip
+	ddr       uint8 //pins driven by the chip
+	pins      uint8 //levels on the pins
+	drivers   []*PortDriver
+	watchers  []func(old, pins uint8)
+	reported  uint8 //levels the watchers were last told about
+	notifying bool  //the watchers are being called
+}
+
+//NewPort returns a port with every pin released and pulled up
+func NewPort() *Port {
+	return &Port{pins: 0xff, reported: 0xff}
+}
+
+//Pins returns the levels on the pins
+func (p *Port) Pins() uint8 {
+	return p.pins
+}
+
+//Watch calls f every time the level of a pin changes, with the levels before
+//and after the change
+func (p *Port) Watch(f func(old, pins uint8)) {
+	p.watchers = append(p.watchers, f)
+}
+
+//Driver returns a new driver a peripheral pulls pins low with. Pins start out
+//released.
+func (p *Port) Driver() *PortDriver {
+	d := &PortDriver{port: p}
+	p.drivers = append(p.drivers, d)
+	return d
+}
+
+//set changes the levels driven by the chip. Pins set in ddr are driven to
+//the level in output, the rest are released.
+func (p *Port) set(output, ddr uint8) {
+	p.output = output
+	p.ddr = ddr
+	p.update()
+}
+
+//update recomputes the pin levels and tells the watchers if they changed.
+//Watchers that change the pins themselves do not call the other watchers
+//recursively. The change is reported once every watcher saw the one before.
+func (p *Port) update() {
+	pins := p.output | ^p.ddr
+	for _, d := range p.drivers {
+		pins &= d.level | ^d.mask
+	}
+	p.pins = pins
+	if p.notifying {
+		return
+	}
+	p.notifying = true
+	for p.reported != p.pins {
+		old, pins := p.reported, p.pins
+		p.reported = pins
+		for _, f := range p.watchers {
+			f(old, pins)
+		}
+	}
+	p.notifying = false
+}
+
+//PortDriver drives pins of a port on behalf of a peripheral
+type PortDriver struct {
+	port  *Port
+	mask  uint8 //pins driven
+	level uint8
+}
+
+//Drive drives the pins in mask to the levels in level and releases the
+//others. Driving a pin high is the same as releasing it.
+func (d *PortDriver) Drive(mask, level uint8) {
+	d.mask = mask
+	d.level = level
+	d.port.update()
+}
+
+//Set drives the pins in mask to the levels in level and leaves the others
+//as they are
+func (d *PortDriver) Set(mask, level uint8) {
+	d.Drive(d.mask|mask, d.level&^mask|level&mask)
+}
+
+//Release releases the pins in mask
+func (d *PortDriver) Release(mask uint8) {
+	d.Drive(d.mask&^mask, d.level)
+}
+
+//portOf returns a port of a device attached to m by its name, "a" or "b" for
+//the data ports and "ca" or "cb" for the control lines of a VIA or PIA. It
+//lets config options wire peripherals to the ports of other devices.
+func portOf(m *machine.Machine, device, port string) (*Port, error) {
+	var ports map[string]*Port
+	switch d := m.Device(device).(type) {
+	case nil:
+		return nil, fmt.Errorf("no device %q, it has to come before the devices wired to it", device)
+	case *VIA:
+		ports = map[string]*Port{"a": d.PortA, "b": d.PortB, "ca": d.CA, "cb": d.CB}
+	case *PIA:
+		ports = map[string]*Port{"a": d.PortA, "b": d.PortB, "ca": d.CA, "cb": d.CB}
+	case *RIOT:
+		ports = map[string]*Port{"a": d.PortA, "b": d.PortB}
+	default:
+		return nil, fmt.Errorf("device %q has no ports", device)
+	}
+	p, ok := ports[strings.ToLower(port)]
+	if !ok {
+		return nil, fmt.Errorf("device %q has no port %q", device, port)
+	}
+	return p, nil
+}
@@ -0,0 +1,278 @@
+package devices
+
+import (
+	"github.com/rdzhaafar/emu6502/core"
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+func init() {
+	machine.RegisterDevice("riot", newRIOTDevice)
+}
+
+//riotOptions are the options of a RIOT in a machine config
+type riotOptions struct {
+	//RAM is the first address of the RAM. The RAM is not mapped if it is
+	//not set.
+	RAM *machine.Address `json:"ram"`
+}
+
+func newRIOTDevice(options machine.Options) (machine.Device, error) {
+	var opt riotOptions
+	err := options.Decode(&opt)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRIOT()
+	if opt.RAM != nil {
+		r.RAMBase = uint16(*opt.RAM)
+		r.MapRAM = true
+	}
+	return r, nil
+}
+
+//Registers of the RIOT. The timer is written at RiotTimer1 through
+//RiotTimer1024, which select the prescaler. Adding RiotIntEnable to the
+//address of a timer read or write enables the timer interrupt, leaving it out
+//disables it.
+const (
+	RiotORA       = 0x00 //output register A on writes, the PA pins on reads
+	RiotDDRA      = 0x01
+	RiotORB       = 0x02
+	RiotDDRB      = 0x03
+	RiotTimer     = 0x04 //timer on reads
+	RiotFlags     = 0x05 //interrupt flags on reads
+	RiotEdge      = 0x04 //PA7 edge detect control on writes, RiotEdge* bits
+	RiotTimer1    = 0x14 //timer counting every cycle on writes
+	RiotTimer8    = 0x15
+	RiotTimer64   = 0x16
+	RiotTimer1024 = 0x17
+	RiotIntEnable = 0x08
+)
+
+//Bits of the edge detect control, which are taken from the address written
+const (
+	RiotEdgePositive = 0x01 //PA7 interrupts on rising instead of falling edges
+	RiotEdgeEnable   = 0x02 //enables the PA7 interrupt
+)
+
+//Interrupt flags of the RIOT, as read at RiotFlags
+const (
+	RiotIntTimer uint8 = 0x80
+	RiotIntPA7   uint8 = 0x40
+)
+
+//riotPrescalers are the cycles per timer count selected by the low address
+//bits of a timer write
+var riotPrescalers = [4]uint64{1, 8, 64, 1024}
+
+//RiotRAMSize is the size of the RIOT RAM
+const RiotRAMSize = 128
+
+//pa7 is the port A pin with edge detection
+const pa7 uint8 = 0x80
+
+//RIOT is a MOS 6532 RAM-I/O-timer. It has 128 bytes of RAM, two 8-bit ports
+//with data direction registers, an interval timer and an interrupt on edges of
+//PA7. The I/O registers take up 32 addresses.
+//
+//The chip selects its RAM and its registers with the RS pin, which boards
+//wire to an address line of their choice, so the RAM is a bus of its own.
+//With MapRAM set, it is mapped at RAMBase when the RIOT is added to a machine.
+//Peripherals are wired to PortA and PortB. The timer runs on cpu cycles, so the
+//RIOT has to be added to a machine for it to work.
+type RIOT struct {
+	PortA   *Port
+	PortB   *Port
+	RAM     *core.RAM
+	RAMBase uint16
+	MapRAM  bool
+
+	ora, orb   uint8
+	ddra, ddrb uint8
+	edge       uint8 //RiotEdge* bits
+	flags      uint8
+	timerIRQ   bool
+
+	count     uint8  //value the timer was loaded with
+	prescaler uint64 //cycles per count
+	start     uint64 //cycle the timer was loaded at
+	event     *machine.Event
+
+	m   *machine.Machine
+	irq *machine.Line
+}
+
+//NewRIOT returns a RIOT in its reset state with zeroed RAM
+func NewRIOT() *RIOT {
+	r := &RIOT{
+		PortA:     NewPort(),
+		PortB:     NewPort(),
+		RAM:       core.NewRAM(RiotRAMSize),
+		prescaler: 1024,
+	}
+	r.PortA.Watch(r.portAChanged)
+	r.reset()
+	return r
+}
+
+//Size returns the number of addresses the I/O registers take up
+func (r *RIOT) Size() int {
+	return 0x20
+}
+
+func (r *RIOT) Attach(m *machine.Machine) error {
+	r.m = m
+	r.irq = m.IRQ()
+	if r.MapRAM {
+		return m.Bus.Map("riot ram", r.RAMBase, r.RAMBase+RiotRAMSize-1, r.RAM)
+	}
+	return nil
+}
+
+//Reset clears the ports and disables the interrupts, like the RES pin. The
+//timer keeps counting.
+func (r *RIOT) Reset() {
+	r.reset()
+	if r.m != nil && r.flags&RiotIntTimer == 0 {
+		//the machine dropped the timeout event
+		r.schedule()
+	}
+}
+
+func (r *RIOT) reset() {
+	r.ora, r.orb, r.ddra, r.ddrb = 0, 0, 0, 0
+	r.edge = 0
+	r.flags &^= RiotIntPA7
+	r.timerIRQ = false
+	r.updatePorts()
+	r.updateIRQ()
+}
+
+//timeout returns the cycle the timer counts past zero at. The first count
+//happens on the cycle after the timer is loaded.
+func (r *RIOT) timeout() uint64 {
+	return r.start + uint64(r.count)*r.prescaler + 1
+}
+
+//timer returns the current value of the timer. After the timeout, the timer
+//keeps counting down once per cycle, so the cpu can tell how long ago it
+//timed out.
+func (r *RIOT) timer() uint8 {
+	var now uint64
+	if r.m != nil {
+		now = r.m.Now()
+	}
+	if now >= r.timeout() {
+		return 0xff - uint8(now-r.timeout())
+	}
+	if now == r.start {
+		return r.count
+	}
+	return r.count - 1 - uint8((now-r.start-1)/r.prescaler)
+}
+
+//load starts the timer counting down from count every prescaler cycles
+func (r *RIOT) load(count uint8, prescaler uint64) {
+	r.count = count
+	r.prescaler = prescaler
+	if r.m != nil {
+		r.start = r.m.Now()
+	}
+	r.schedule()
+}
+
+//schedule schedules the timeout of the timer
+func (r *RIOT) schedule() {
+	if r.event != nil {
+		r.event.Cancel()
+		r.event = nil
+	}
+	if r.m != nil {
+		r.event = r.m.Schedule(r.timeout(), r.timedOut)
+	}
+}
+
+func (r *RIOT) timedOut() {
+	r.event = nil
+	r.flags |= RiotIntTimer
+	r.updateIRQ()
+}
+
+//updateIRQ drives the IRQ line from the enabled interrupt flags
+func (r *RIOT) updateIRQ() {
+	if r.irq == nil {
+		return
+	}
+	timerIRQ := r.timerIRQ && r.flags&RiotIntTimer != 0
+	pa7IRQ := r.edge&RiotEdgeEnable != 0 && r.flags&RiotIntPA7 != 0
+	r.irq.Set(timerIRQ || pa7IRQ)
+}
+
+//updatePorts drives the pins from the registers
+func (r *RIOT) updatePorts() {
+	r.PortA.set(r.ora, r.ddra)
+	r.PortB.set(r.orb, r.ddrb)
+}
+
+func (r *RIOT) portAChanged(old, pins uint8) {
+	if edge(old, pins, pa7, r.edge&RiotEdgePositive != 0) {
+		r.flags |= RiotIntPA7
+		r.updateIRQ()
+	}
+}
+
+func (r *RIOT) Read(addr uint16) uint8 {
+	addr &= 0x1f
+	if addr&0x04 == 0 {
+		switch addr & 0x3 {
+		case RiotORA:
+			return r.PortA.Pins()
+		case RiotDDRA:
+			return r.ddra
+		case RiotORB:
+			//output pins read back from the output register
+			return r.orb&r.ddrb | r.PortB.Pins()&^r.ddrb
+		default: //RiotDDRB
+			return r.ddrb
+		}
+	}
+	if addr&0x01 == 0 {
+		r.timerIRQ = addr&RiotIntEnable != 0
+		value := r.timer()
+		r.flags &^= RiotIntTimer
+		r.updateIRQ()
+		return value
+	}
+	flags := r.flags
+	r.flags &^= RiotIntPA7
+	r.updateIRQ()
+	return flags
+}
+
+func (r *RIOT) Write(addr uint16, val uint8) error {
+	addr &= 0x1f
+	if addr&0x04 == 0 {
+		switch addr & 0x3 {
+		case RiotORA:
+			r.ora = val
+		case RiotDDRA:
+			r.ddra = val
+		case RiotORB:
+			r.orb = val
+		default: //RiotDDRB
+			r.ddrb = val
+		}
+		r.updatePorts()
+		return nil
+	}
+	if addr&0x10 == 0 {
+		r.edge = uint8(addr) & (RiotEdgePositive | RiotEdgeEnable)
+		r.updateIRQ()
+		return nil
+	}
+	r.timerIRQ = addr&RiotIntEnable != 0
+	r.flags &^= RiotIntTimer
+	r.load(val, riotPrescalers[addr&0x3])
+	r.updateIRQ()
+	return nil
+}
@@ -0,0 +1,48 @@
+package devices
+
+import "testing"
+
+func TestRIOTPrescalers(t *testing.T) {
+	for n, prescaler := range riotPrescalers {
+		m := newTestMachine(t)
+		r := NewRIOT()
+		addDevice(t, m, "riot", r)
+		r.Write(uint16(RiotTimer1+n)|RiotIntEnable, 4)
+		m.Run(prescaler)
+		if timer := r.Read(RiotTimer | RiotIntEnable); timer != 3 {
+			t.Fatalf("prescaler %d: timer is %d after one period, want 3", prescaler, timer)
+		}
+		m.Run(3 * prescaler)
+		if r.Read(RiotFlags)&RiotIntTimer != 0 || m.IRQAsserted() {
+			t.Fatalf("prescaler %d: timer interrupted before counting past 0", prescaler)
+		}
+		m.Run(2)
+		if r.Read(RiotFlags)&RiotIntTimer == 0 || !m.IRQAsserted() {
+			t.Fatalf("prescaler %d: timer did not interrupt", prescaler)
+		}
+		//after the timeout the timer counts every cycle, and reading it
+		//clears the interrupt
+		if timer := r.Read(RiotTimer); timer != 0xfe || m.IRQAsserted() {
+			t.Fatalf("prescaler %d: timer is %#02x a cycle after the timeout, want fe", prescaler, timer)
+		}
+	}
+}
+
+func TestRIOTEdgeDetect(t *testing.T) {
+	m := newTestMachine(t)
+	r := NewRIOT()
+	addDevice(t, m, "riot", r)
+	pins := r.PortA.Driver()
+	r.Write(RiotEdge|RiotEdgeEnable, 0)
+	pins.Drive(pa7, pa7)
+	if r.Read(RiotFlags)&RiotIntPA7 != 0 {
+		t.Fatal("PA7 interrupted on a rising edge")
+	}
+	pins.Drive(pa7, 0)
+	if !m.IRQAsserted() {
+		t.Fatal("PA7 did not interrupt on a falling edge")
+	}
+	if r.Read(RiotFlags)&RiotIntPA7 == 0 || m.IRQAsserted() {
+		t.Fatal("reading the flags did not clear the PA7 interrupt")
+	}
+}
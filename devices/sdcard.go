@@ -0,0 +1,465 @@
+package devices
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+func init() {
+	machine.RegisterDevice("sdcard", newSDCardDevice)
+}
+
+//sdCardOptions are the options of an SD card in a machine config. Pins are
+//numbered 0 to 7.
+type sdCardOptions struct {
+	Image    string `json:"image"`
+	ReadOnly bool   `json:"read_only"`
+	SDSC     bool   `json:"sdsc"`
+	//Device is the device whose port the SPI bus is wired to
+	Device string `json:"device"`
+	Port   string `json:"port"`
+	Mode   int    `json:"mode"`
+	SCK    *int   `json:"sck"`
+	MOSI   *int   `json:"mosi"`
+	MISO   *int   `json:"miso"`
+	CS     *int   `json:"cs"`
+}
+
+func newSDCardDevice(options machine.Options) (machine.Device, error) {
+	opt := sdCardOptions{Port: "a"}
+	err := options.Decode(&opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Image == "" {
+		return nil, fmt.Errorf("an SD card needs an image")
+	}
+	if opt.Device == "" {
+		return nil, fmt.Errorf("an SD card needs a device to be wired to")
+	}
+	if opt.Mode < 0 || opt.Mode > 3 {
+		return nil, fmt.Errorf("SPI mode %d is not between 0 and 3", opt.Mode)
+	}
+	pins := map[string]*int{"sck": opt.SCK, "mosi": opt.MOSI, "miso": opt.MISO, "cs": opt.CS}
+	for name, pin := range pins {
+		if pin == nil {
+			return nil, fmt.Errorf("the %s pin of the SD card is not set", name)
+		}
+		if *pin < 0 || *pin > 7 {
+			return nil, fmt.Errorf("%s is wired to pin %d, ports have pins 0 to 7", name, *pin)
+		}
+	}
+	card, err := OpenSDCard(options.Path(opt.Image), opt.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	card.SDSC = opt.SDSC
+	card.wireTo = &opt
+	return card, nil
+}
+
+//SDBlockSize is the size of SD card blocks
+const SDBlockSize = 512
+
+//Bits of the R1 response
+const (
+	sdIdle         uint8 = 0x01
+	sdIllegal      uint8 = 0x04
+	sdAddressError uint8 = 0x20
+	sdParamError   uint8 = 0x40
+)
+
+//Bits of the OCR register
+const (
+	sdOCRPowerUp      = 1 << 31
+	sdOCRHighCapacity = 1 << 30 //CCS in the OCR, HCS in ACMD41
+)
+
+//Data tokens
+const (
+	sdStartBlock     uint8 = 0xfe
+	sdStartMultiple  uint8 = 0xfc //starts a block of a multiple block write
+	sdStopTransfer   uint8 = 0xfd //ends a multiple block write
+	sdDataAccepted   uint8 = 0x05
+	sdDataWriteError uint8 = 0x0d
+	sdErrorToken     uint8 = 0x08 //out of range, sent instead of a block
+)
+
+//sdState is what an SD card does with the bytes it receives
+type sdState int
+
+const (
+	sdCommand    sdState = iota //waiting for a command
+	sdWriteToken                //waiting for the data token of a write
+	sdWriteData                 //receiving a block to write
+)
+
+//SDCard is an SD card in SPI mode, backed by a disk image. It is an
+//SPIDevice, wired to pins with SPIBus.
+//
+//The card answers CMD0, CMD8, CMD9, CMD10, CMD12, CMD13, CMD16, CMD17,
+//CMD18, CMD24, CMD25, CMD55, CMD58, CMD59 and ACMD41. It leaves the idle
+//state on the second ACMD41, so drivers have to retry like on real cards.
+//CRCs are not checked, but data blocks carry a valid CRC16. By default the
+//card is high capacity (SDHC), addressed in blocks, and only initializes if
+//the host sets HCS in ACMD41. With SDSC set, it is a standard capacity card
+//addressed in bytes.
+type SDCard struct {
+	SDSC bool
+
+	image  BlockImage
+	blocks uint32
+
+	state    sdState
+	frame    []uint8 //command being received
+	app      bool    //the previous command was CMD55
+	ready    bool    //the card left the idle state
+	inits    int     //ACMD41 received
+	out      []uint8 //bytes to send
+	block    []uint8 //block being received
+	addr     uint32  //block of the next read or write
+	multiple bool    //a multiple block read or write is in progress
+
+	wireTo *sdCardOptions
+}
+
+//NewSDCard returns an SD card on an image of size bytes
+func NewSDCard(image BlockImage, size int64) *SDCard {
+	return &SDCard{image: image, blocks: uint32(size / SDBlockSize)}
+}
+
+//OpenSDCard returns an SD card on an image file
+func OpenSDCard(path string, readOnly bool) (*SDCard, error) {
+	flag := os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return NewSDCard(file, info.Size()), nil
+}
+
+//Close closes the image if it implements io.Closer
+func (c *SDCard) Close() error {
+	if closer, ok := c.image.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *SDCard) Attach(m *machine.Machine) error {
+	opt := c.wireTo
+	if opt == nil {
+		return nil
+	}
+	c.wireTo = nil
+	port, err := portOf(m, opt.Device, opt.Port)
+	if err != nil {
+		return err
+	}
+	bus := NewSPIBus(port, 1<<*opt.SCK, 1<<*opt.MOSI, 1<<*opt.MISO)
+	bus.Mode = opt.Mode
+	return bus.Add(c, port, 1<<*opt.CS)
+}
+
+//Reset does nothing, the card is powered separately from the cpu
+func (c *SDCard) Reset() {
+}
+
+//Select is called when the card is selected or released. Releasing the card
+//drops a command that was not received completely.
+func (c *SDCard) Select(selected bool) {
+	c.frame = c.frame[:0]
+}
+
+//Transfer receives a byte and returns the next byte to send
+func (c *SDCard) Transfer(in uint8) uint8 {
+	switch c.state {
+	case sdCommand:
+		if len(c.frame) > 0 || in&0xc0 == 0x40 {
+			c.frame = append(c.frame, in)
+			if len(c.frame) == 6 {
+				c.command(c.frame[0]&0x3f, uint32(c.frame[1])<<24|uint32(c.frame[2])<<16|uint32(c.frame[3])<<8|uint32(c.frame[4]))
+				c.frame = c.frame[:0]
+			}
+		}
+	case sdWriteToken:
+		switch {
+		case in == sdStartBlock && !c.multiple, in == sdStartMultiple && c.multiple:
+			c.block = c.block[:0]
+			c.state = sdWriteData
+		case in == sdStopTransfer && c.multiple:
+			c.multiple = false
+			c.state = sdCommand
+			c.out = append(c.out, 0xff, 0x00, 0x00)
+		}
+	case sdWriteData:
+		c.block = append(c.block, in)
+		if len(c.block) == SDBlockSize+2 {
+			c.writeBlock()
+		}
+	}
+	if c.multiple && c.state == sdCommand && len(c.out) < 2 {
+		//keep a multiple block read going
+		c.readBlock()
+	}
+	if len(c.out) == 0 {
+		return 0xff
+	}
+	out := c.out[0]
+	c.out = c.out[1:]
+	return out
+}
+
+//r1 returns the R1 response with the idle bit
+func (c *SDCard) r1(bits uint8) uint8 {
+	if !c.ready {
+		bits |= sdIdle
+	}
+	return bits
+}
+
+//respond queues a response after a byte of command response time
+func (c *SDCard) respond(response ...uint8) {
+	c.out = append(c.out[:0], 0xff)
+	c.out = append(c.out, response...)
+}
+
+//command executes a command
+func (c *SDCard) command(cmd uint8, arg uint32) {
+	app := c.app
+	c.app = false
+	if cmd == 12 {
+		//stop a multiple block read, after a stuff byte
+		c.multiple = false
+		c.respond(0xff, c.r1(0), 0x00)
+		return
+	}
+	if c.multiple {
+		//a multiple block read ignores other commands
+		return
+	}
+	switch {
+	case cmd == 0:
+		c.ready = false
+		c.inits = 0
+		c.respond(sdIdle)
+	case cmd == 8:
+		c.respond(c.r1(0), 0x00, 0x00, uint8(arg>>8)&0x0f, uint8(arg))
+	case cmd == 55:
+		c.app = true
+		c.respond(c.r1(0))
+	case cmd == 41 && app:
+		c.inits++
+		if c.inits >= 2 && (c.SDSC || arg&sdOCRHighCapacity != 0) {
+			c.ready = true
+		}
+		c.respond(c.r1(0))
+	case cmd == 58:
+		ocr := uint32(0x00ff8000) //2.7V to 3.6V
+		if c.ready {
+			ocr |= sdOCRPowerUp
+			if !c.SDSC {
+				ocr |= sdOCRHighCapacity
+			}
+		}
+		c.respond(c.r1(0), uint8(ocr>>24), uint8(ocr>>16), uint8(ocr>>8), uint8(ocr))
+	case cmd == 59:
+		c.respond(c.r1(0))
+	case cmd == 9:
+		c.respond(c.r1(0))
+		c.sendData(c.csd())
+	case cmd == 10:
+		c.respond(c.r1(0))
+		c.sendData(c.cid())
+	case cmd == 13:
+		c.respond(c.r1(0), 0x00)
+	case !c.ready:
+		c.respond(c.r1(sdIllegal))
+	case cmd == 16:
+		if arg != SDBlockSize && c.SDSC {
+			c.respond(c.r1(sdParamError))
+			return
+		}
+		c.respond(c.r1(0))
+	case cmd == 17, cmd == 18:
+		if !c.address(arg) {
+			return
+		}
+		c.respond(c.r1(0))
+		c.multiple = cmd == 18
+		c.readBlock()
+	case cmd == 24, cmd == 25:
+		if !c.address(arg) {
+			return
+		}
+		c.respond(c.r1(0))
+		c.multiple = cmd == 25
+		c.state = sdWriteToken
+	default:
+		c.respond(c.r1(sdIllegal))
+	}
+}
+
+//address sets the block of a read or write command and responds with an
+//error if it is out of range
+func (c *SDCard) address(arg uint32) bool {
+	if c.SDSC {
+		if arg%SDBlockSize != 0 {
+			c.respond(c.r1(sdAddressError))
+			return false
+		}
+		arg /= SDBlockSize
+	}
+	if arg >= c.blocks {
+		c.respond(c.r1(sdParamError))
+		return false
+	}
+	c.addr = arg
+	return true
+}
+
+//readBlock queues the next block to read
+func (c *SDCard) readBlock() {
+	if c.addr >= c.blocks {
+		c.out = append(c.out, 0xff, sdErrorToken)
+		c.multiple = false
+		return
+	}
+	block := make([]uint8, SDBlockSize)
+	_, err := c.image.ReadAt(block, int64(c.addr)*SDBlockSize)
+	if err != nil {
+		c.out = append(c.out, 0xff, sdErrorToken)
+		c.multiple = false
+		return
+	}
+	c.addr++
+	c.out = append(c.out, 0xff)
+	c.sendData(block)
+}
+
+//sendData queues a data block with its token and CRC
+func (c *SDCard) sendData(data []uint8) {
+	crc := crc16(data)
+	c.out = append(c.out, sdStartBlock)
+	c.out = append(c.out, data...)
+	c.out = append(c.out, uint8(crc>>8), uint8(crc))
+}
+
+//writeBlock writes a received block and queues the data response and busy
+//bytes
+func (c *SDCard) writeBlock() {
+	c.state = sdCommand
+	if c.multiple {
+		c.state = sdWriteToken
+	}
+	var err error
+	if c.addr < c.blocks {
+		_, err = c.image.WriteAt(c.block[:SDBlockSize], int64(c.addr)*SDBlockSize)
+	}
+	if err != nil || c.addr >= c.blocks {
+		c.multiple = false
+		c.state = sdCommand
+		c.out = append(c.out, sdDataWriteError, 0x00, 0x00)
+		return
+	}
+	c.addr++
+	c.out = append(c.out, sdDataAccepted, 0x00, 0x00)
+}
+
+//csd returns the card specific data register
+func (c *SDCard) csd() []uint8 {
+	csd := make([]uint8, 16)
+	setBits(csd, 112, 8, 0x0e)  //TAAC
+	setBits(csd, 96, 8, 0x32)   //TRAN_SPEED, 25MHz
+	setBits(csd, 84, 12, 0x5b5) //CCC
+	setBits(csd, 80, 4, 9)      //READ_BL_LEN, 512 bytes
+	setBits(csd, 22, 4, 9)      //WRITE_BL_LEN, 512 bytes
+	if c.SDSC {
+		//capacity is (C_SIZE+1) * 2^(C_SIZE_MULT+2) blocks
+		size := c.blocks / 512
+		if size > 0 {
+			size--
+		}
+		if size > 0xfff {
+			size = 0xfff
+		}
+		setBits(csd, 62, 12, size)
+		setBits(csd, 47, 3, 7) //C_SIZE_MULT
+	} else {
+		setBits(csd, 126, 2, 1) //CSD version 2
+		//capacity is (C_SIZE+1) * 512KiB
+		size := c.blocks / 1024
+		if size > 0 {
+			size--
+		}
+		setBits(csd, 48, 22, size)
+	}
+	setBits(csd, 46, 1, 1) //ERASE_BLK_EN
+	setBits(csd, 39, 7, 0x7f)
+	csd[15] = crc7(csd[:15])<<1 | 1
+	return csd
+}
+
+//cid returns the card identification register
+func (c *SDCard) cid() []uint8 {
+	cid := []uint8{0x00, 'E', 'M', 'E', 'M', 'U', '6', '5', 0x10, 0x00, 0x00, 0x00, 0x01, 0x01, 0x8a, 0}
+	cid[15] = crc7(cid[:15])<<1 | 1
+	return cid
+}
+
+//setBits sets width bits of a big endian register, starting at bit lsb
+//counted from the least significant end
+func setBits(reg []uint8, lsb, width int, val uint32) {
+	for i := 0; i < width; i++ {
+		bit := lsb + i
+		byteIndex := len(reg) - 1 - bit/8
+		mask := uint8(1) << (bit % 8)
+		if val>>i&1 != 0 {
+			reg[byteIndex] |= mask
+		} else {
+			reg[byteIndex] &^= mask
+		}
+	}
+}
+
+//crc7 returns the CRC7 of SD commands and registers
+func crc7(data []uint8) uint8 {
+	var crc uint8
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bit := b>>i&1 ^ crc>>6&1
+			crc = crc << 1 & 0x7f
+			if bit != 0 {
+				crc ^= 0x09
+			}
+		}
+	}
+	return crc
+}
+
+//crc16 returns the CRC16-CCITT of SD data blocks
+func crc16(data []uint8) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,166 @@
+package devices
+
+import "testing"
+
+//Pins of the SPI bus in the tests, on VIA port A
+const (
+	testSCK  uint8 = 0x01
+	testMOSI uint8 = 0x02
+	testMISO uint8 = 0x40
+	testCS   uint8 = 0x80
+)
+
+//sdHost drives an SD card through a VIA, like firmware does
+type sdHost struct {
+	t *testing.T
+	v *VIA
+}
+
+//newTestSDCard returns an SDHC card with 4 blocks wired to port A of a VIA
+func newTestSDCard(t *testing.T) (*SDCard, memoryImage, *sdHost) {
+	v := NewVIA()
+	img := make(memoryImage, 4*SDBlockSize)
+	card := NewSDCard(img, int64(len(img)))
+	bus := NewSPIBus(v.PortA, testSCK, testMOSI, testMISO)
+	err := bus.Add(card, v.PortA, testCS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Write(ViaORA, testCS)
+	v.Write(ViaDDRA, testSCK|testMOSI|testCS)
+	return card, img, &sdHost{t, v}
+}
+
+//transfer sends a byte in SPI mode 0 and returns the byte received
+func (h *sdHost) transfer(out uint8) uint8 {
+	var in uint8
+	for bit := 7; bit >= 0; bit-- {
+		mosi := boolBit(out>>bit&1 != 0, testMOSI)
+		h.v.Write(ViaORA, mosi)
+		in = in<<1 | boolBit(h.v.Read(ViaORA)&testMISO != 0, 1)
+		h.v.Write(ViaORA, mosi|testSCK)
+	}
+	h.v.Write(ViaORA, 0)
+	return in
+}
+
+//wait returns the first byte received that is not ff
+func (h *sdHost) wait() uint8 {
+	for i := 0; i < 16; i++ {
+		if in := h.transfer(0xff); in != 0xff {
+			return in
+		}
+	}
+	h.t.Fatal("the card did not respond")
+	return 0
+}
+
+//command sends a command and returns the R1 response
+func (h *sdHost) command(cmd uint8, arg uint32) uint8 {
+	frame := []uint8{0x40 | cmd, uint8(arg >> 24), uint8(arg >> 16), uint8(arg >> 8), uint8(arg)}
+	for _, b := range append(frame, crc7(frame)<<1|1) {
+		h.transfer(b)
+	}
+	return h.wait()
+}
+
+//read reads n bytes
+func (h *sdHost) read(n int) []uint8 {
+	data := make([]uint8, n)
+	for i := range data {
+		data[i] = h.transfer(0xff)
+	}
+	return data
+}
+
+//init takes the card out of the idle state
+func (h *sdHost) init() {
+	if r1 := h.command(0, 0); r1 != sdIdle {
+		h.t.Fatalf("CMD0 responded %#02x, want 01", r1)
+	}
+	if r1 := h.command(8, 0x1aa); r1 != sdIdle {
+		h.t.Fatalf("CMD8 responded %#02x, want 01", r1)
+	}
+	if r7 := h.read(4); r7[2] != 0x01 || r7[3] != 0xaa {
+		h.t.Fatalf("CMD8 echoed % x, want 01 aa", r7[2:])
+	}
+	//the card stays idle on the first ACMD41
+	for _, want := range []uint8{sdIdle, 0x00} {
+		h.command(55, 0)
+		if r1 := h.command(41, sdOCRHighCapacity); r1 != want {
+			h.t.Fatalf("ACMD41 responded %#02x, want %#02x", r1, want)
+		}
+	}
+}
+
+func TestSDCardInit(t *testing.T) {
+	_, _, host := newTestSDCard(t)
+	//select the card
+	host.v.Write(ViaORA, 0)
+	if r1 := host.command(17, 0); r1 != sdIdle|sdIllegal {
+		t.Fatalf("CMD17 before initialization responded %#02x, want 05", r1)
+	}
+	host.init()
+	if r1 := host.command(58, 0); r1 != 0x00 {
+		t.Fatalf("CMD58 responded %#02x, want 00", r1)
+	}
+	if ocr := host.read(4); ocr[0]&0xc0 != 0xc0 {
+		t.Fatalf("OCR is % x, want powered up and high capacity", ocr)
+	}
+	//the CID is sent as a data block
+	host.command(10, 0)
+	if token := host.wait(); token != sdStartBlock {
+		t.Fatalf("CID token is %#02x, want fe", token)
+	}
+	if cid := host.read(16); string(cid[1:3]) != "EM" || cid[15] != crc7(cid[:15])<<1|1 {
+		t.Fatalf("CID is % x", cid)
+	}
+}
+
+func TestSDCardReadWrite(t *testing.T) {
+	_, img, host := newTestSDCard(t)
+	for i := 0; i < SDBlockSize; i++ {
+		img[SDBlockSize+i] = uint8(i)
+	}
+	//select the card
+	host.v.Write(ViaORA, 0)
+	host.init()
+	//SDHC cards are addressed in blocks
+	if r1 := host.command(17, 1); r1 != 0x00 {
+		t.Fatalf("CMD17 responded %#02x, want 00", r1)
+	}
+	if token := host.wait(); token != sdStartBlock {
+		t.Fatalf("read token is %#02x, want fe", token)
+	}
+	block := host.read(SDBlockSize + 2)
+	for i := 0; i < SDBlockSize; i++ {
+		if block[i] != uint8(i) {
+			t.Fatalf("byte %d of block 1 is %#02x", i, block[i])
+		}
+	}
+	if crc := uint16(block[SDBlockSize])<<8 | uint16(block[SDBlockSize+1]); crc != crc16(block[:SDBlockSize]) {
+		t.Fatalf("block CRC is %#04x, want %#04x", crc, crc16(block[:SDBlockSize]))
+	}
+
+	if r1 := host.command(24, 2); r1 != 0x00 {
+		t.Fatalf("CMD24 responded %#02x, want 00", r1)
+	}
+	host.transfer(0xff)
+	host.transfer(sdStartBlock)
+	for i := 0; i < SDBlockSize; i++ {
+		host.transfer(0xaa)
+	}
+	host.transfer(0x00)
+	host.transfer(0x00)
+	if response := host.wait(); response&0x1f != sdDataAccepted {
+		t.Fatalf("data response is %#02x, want 05", response)
+	}
+	if img[2*SDBlockSize] != 0xaa || img[3*SDBlockSize-1] != 0xaa {
+		t.Fatal("block 2 was not written")
+	}
+
+	//blocks past the end are out of range
+	if r1 := host.command(17, 4); r1 != sdParamError {
+		t.Fatalf("CMD17 past the end responded %#02x, want 40", r1)
+	}
+}
@@ -0,0 +1,146 @@
+package devices
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+//DefaultSerialAddress is the address serial devices listen on for TCP
+//connections by default
+const DefaultSerialAddress = "127.0.0.1:6551"
+
+//readHost sends what is read from host to a device through in, until
+//reading fails
+func readHost(host io.Reader, in *machine.Input) {
+	buf := make([]uint8, 256)
+	for {
+		n, err := host.Read(buf)
+		if n > 0 {
+			in.Send(buf[:n]...)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+//TCPSerial is the host side of a serial line that a TCP client on the local
+//machine connects to, for example with telnet or netcat. One client is served
+//at a time. Bytes sent while no client is connected are dropped.
+type TCPSerial struct {
+	listener net.Listener
+	data     chan []uint8 //data received from clients
+	pending  []uint8      //received data not read yet
+	closed   chan struct{}
+
+	mu   sync.Mutex
+	conn net.Conn //current client
+}
+
+//ListenTCP listens for a client on addr, which has to be a loopback address
+//so the emulated serial port is not reachable from other machines
+func ListenTCP(addr string) (*TCPSerial, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("%s is not a loopback address", addr)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	t := &TCPSerial{
+		listener: listener,
+		data:     make(chan []uint8),
+		closed:   make(chan struct{}),
+	}
+	go t.accept()
+	return t, nil
+}
+
+//Addr returns the address the serial line listens on
+func (t *TCPSerial) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+//accept serves clients one after another
+func (t *TCPSerial) accept() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		t.mu.Lock()
+		t.conn = conn
+		t.mu.Unlock()
+		buf := make([]uint8, 256)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				data := append([]uint8(nil), buf[:n]...)
+				select {
+				case t.data <- data:
+				case <-t.closed:
+					conn.Close()
+					return
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		t.mu.Lock()
+		t.conn = nil
+		t.mu.Unlock()
+		conn.Close()
+	}
+}
+
+//Read reads data sent by clients. It blocks until a client sends something.
+func (t *TCPSerial) Read(p []uint8) (int, error) {
+	if len(t.pending) == 0 {
+		select {
+		case data := <-t.data:
+			t.pending = data
+		case <-t.closed:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+//Write sends data to the connected client
+func (t *TCPSerial) Write(p []uint8) (int, error) {
+	t.mu.Lock()
+	conn := t.conn
+	t.mu.Unlock()
+	if conn == nil {
+		return len(p), nil
+	}
+	_, err := conn.Write(p)
+	if err != nil {
+		//the client went away, carry on like with no client
+		conn.Close()
+	}
+	return len(p), nil
+}
+
+//Close stops listening and disconnects the client
+func (t *TCPSerial) Close() error {
+	close(t.closed)
+	t.mu.Lock()
+	if t.conn != nil {
+		t.conn.Close()
+	}
+	t.mu.Unlock()
+	return t.listener.Close()
+}
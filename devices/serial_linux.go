@@ -0,0 +1,127 @@
+package devices
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+//ioctl calls the ioctl system call with a pointer argument
+func ioctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+//makeRaw puts the terminal fd into raw mode, like cfmakeraw, and returns the
+//settings it had before
+func makeRaw(fd uintptr) (*syscall.Termios, error) {
+	var old syscall.Termios
+	err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&old))
+	if err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	err = ioctl(fd, syscall.TCSETS, unsafe.Pointer(&raw))
+	if err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+//Terminal is the host side of a serial line connected to the terminal the
+//emulator runs in. The terminal is in raw mode, so every key, including
+//Ctrl-C, goes to the emulated machine.
+type Terminal struct {
+	old *syscall.Termios
+}
+
+//OpenTerminal puts the terminal on standard input into raw mode. Close
+//restores it.
+func OpenTerminal() (*Terminal, error) {
+	old, err := makeRaw(os.Stdin.Fd())
+	if err != nil {
+		return nil, fmt.Errorf("standard input is not a terminal: %w", err)
+	}
+	return &Terminal{old: old}, nil
+}
+
+func (t *Terminal) Read(p []uint8) (int, error) {
+	return os.Stdin.Read(p)
+}
+
+func (t *Terminal) Write(p []uint8) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+//Close restores the terminal settings
+func (t *Terminal) Close() error {
+	return ioctl(os.Stdin.Fd(), syscall.TCSETS, unsafe.Pointer(t.old))
+}
+
+//PTY is the host side of a serial line connected to a pseudo terminal.
+//Terminal programs like screen or minicom attach to the other side, which is
+//named by Name.
+type PTY struct {
+	master *os.File
+	slave  *os.File //kept open so reads do not fail while nothing is attached
+	name   string
+}
+
+//OpenPTY creates a pseudo terminal in raw mode
+func OpenPTY() (*PTY, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	var unlock int32
+	err = ioctl(master.Fd(), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock))
+	if err == nil {
+		var n uint32
+		err = ioctl(master.Fd(), syscall.TIOCGPTN, unsafe.Pointer(&n))
+		if err == nil {
+			name := fmt.Sprintf("/dev/pts/%d", n)
+			var slave *os.File
+			slave, err = os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+			if err == nil {
+				_, err = makeRaw(slave.Fd())
+				if err == nil {
+					return &PTY{master: master, slave: slave, name: name}, nil
+				}
+				slave.Close()
+			}
+		}
+	}
+	master.Close()
+	return nil, err
+}
+
+//Name returns the path terminal programs open to attach to the pseudo
+//terminal
+func (p *PTY) Name() string {
+	return p.name
+}
+
+func (p *PTY) Read(b []uint8) (int, error) {
+	return p.master.Read(b)
+}
+
+func (p *PTY) Write(b []uint8) (int, error) {
+	return p.master.Write(b)
+}
+
+//Close closes both sides of the pseudo terminal
+func (p *PTY) Close() error {
+	p.slave.Close()
+	return p.master.Close()
+}
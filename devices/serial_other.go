@@ -0,0 +1,52 @@
+//go:build !linux
+// +build !linux
+
+package devices
+
+import "fmt"
+
+//Terminal is the host side of a serial line connected to the terminal the
+//emulator runs in. It is only supported on Linux.
+type Terminal struct{}
+
+//OpenTerminal fails on systems other than Linux
+func OpenTerminal() (*Terminal, error) {
+	return nil, fmt.Errorf("terminal serial lines are only supported on Linux")
+}
+
+func (t *Terminal) Read(p []uint8) (int, error) {
+	return 0, fmt.Errorf("terminal serial lines are only supported on Linux")
+}
+
+func (t *Terminal) Write(p []uint8) (int, error) {
+	return 0, fmt.Errorf("terminal serial lines are only supported on Linux")
+}
+
+func (t *Terminal) Close() error {
+	return nil
+}
+
+//PTY is the host side of a serial line connected to a pseudo terminal. It is
+//only supported on Linux.
+type PTY struct{}
+
+//OpenPTY fails on systems other than Linux
+func OpenPTY() (*PTY, error) {
+	return nil, fmt.Errorf("pseudo terminals are only supported on Linux")
+}
+
+func (p *PTY) Name() string {
+	return ""
+}
+
+func (p *PTY) Read(b []uint8) (int, error) {
+	return 0, fmt.Errorf("pseudo terminals are only supported on Linux")
+}
+
+func (p *PTY) Write(b []uint8) (int, error) {
+	return 0, fmt.Errorf("pseudo terminals are only supported on Linux")
+}
+
+func (p *PTY) Close() error {
+	return nil
+}
@@ -0,0 +1,288 @@
+package devices
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rdzhaafar/emu6502/machine"
+)
+
+func init() {
+	machine.RegisterDevice("24lc", newSerialEEPROMDevice)
+}
+
+//serialEEPROMOptions are the options of a 24LC EEPROM in a machine config
+type serialEEPROMOptions struct {
+	i2cWiring
+	Model  string `json:"model"`
+	Image  string `json:"image"`
+	Save   bool   `json:"save"`
+	Select uint8  `json:"select"`
+}
+
+func newSerialEEPROMDevice(options machine.Options) (machine.Device, error) {
+	opt := serialEEPROMOptions{i2cWiring: i2cWiring{Port: "a"}, Model: "24LC256"}
+	err := options.Decode(&opt)
+	if err != nil {
+		return nil, err
+	}
+	err = opt.check()
+	if err != nil {
+		return nil, err
+	}
+	if opt.Image == "" {
+		return nil, fmt.Errorf("a 24LC EEPROM needs an image")
+	}
+	if opt.Select > 7 {
+		return nil, fmt.Errorf("select %d is not between 0 and 7", opt.Select)
+	}
+	size, ok := serialEEPROMModels[strings.ToUpper(opt.Model)]
+	if !ok {
+		return nil, fmt.Errorf("unknown 24LC EEPROM model %q", opt.Model)
+	}
+	e, err := LoadSerialEEPROM(options.Path(opt.Image), size, opt.Save)
+	if err != nil {
+		return nil, err
+	}
+	e.Select = opt.Select
+	e.wireTo = &opt.i2cWiring
+	return e, nil
+}
+
+//serialEEPROMModels are the sizes of the 24LC EEPROMs in bytes
+var serialEEPROMModels = map[string]int{
+	"24LC01":  128,
+	"24LC02":  256,
+	"24LC04":  512,
+	"24LC08":  1024,
+	"24LC16":  2048,
+	"24LC32":  4096,
+	"24LC64":  8192,
+	"24LC128": 16384,
+	"24LC256": 32768,
+	"24LC512": 65536,
+}
+
+//Timing and addressing of the 24LC EEPROMs
+const (
+	//SerialEEPROMWriteTime is the duration of the internal write cycle
+	SerialEEPROMWriteTime = 5 * time.Millisecond
+	//SerialEEPROMAddress is the I2C address of the EEPROMs without the
+	//block or select bits
+	SerialEEPROMAddress uint8 = 0x50
+)
+
+//serialEEPROMWrite is a byte written to the page buffer
+type serialEEPROMWrite struct {
+	addr int
+	val  uint8
+}
+
+//SerialEEPROM is a 24LC series I2C EEPROM, from the 128 byte 24LC01 to the
+//64KB 24LC512. Parts of up to 2KB take one address byte and use the low bits
+//of the I2C address as the upper address bits, so they answer to 0x50 to
+//0x57. Bigger parts take two address bytes and answer to 0x50 plus the level
+//of their A2, A1 and A0 pins.
+//
+//Reads go on from the address, wrapping at the end of the memory. Writes go
+//into a page buffer, wrapping at the end of the page, and are written when
+//the master sends a stop. During the write cycle the EEPROM does not
+//acknowledge its address, so firmware can poll it to see when the write is
+//done. Without a machine, writes complete immediately.
+type SerialEEPROM struct {
+	//Select is the level of the A2, A1 and A0 pins
+	Select uint8
+	//Err is the first error that occurred while saving writes back to the
+	//image file
+	Err error
+
+	data     []uint8
+	pageSize int
+	wide     bool   //the EEPROM takes two address bytes
+	path     string //file written back to, if any
+
+	pointer  int //address of the next read or write
+	block    int //upper address bits from the I2C address
+	received int //address bytes received
+	writes   []serialEEPROMWrite
+	busy     uint64 //cycle the write cycle ends at
+
+	m      *machine.Machine
+	bus    *I2CBus
+	wireTo *i2cWiring
+}
+
+//NewSerialEEPROM returns a 24LC EEPROM holding a copy of image, whose size
+//is the size of one of the parts
+func NewSerialEEPROM(image []uint8) (*SerialEEPROM, error) {
+	size := len(image)
+	pageSize := 0
+	switch {
+	case size == 128 || size == 256:
+		pageSize = 8
+	case size >= 512 && size <= 2048 && size&(size-1) == 0:
+		pageSize = 16
+	case size == 4096 || size == 8192:
+		pageSize = 32
+	case size == 16384 || size == 32768:
+		pageSize = 64
+	case size == 65536:
+		pageSize = 128
+	default:
+		return nil, fmt.Errorf("there is no 24LC EEPROM of %d bytes", size)
+	}
+	return &SerialEEPROM{
+		data:     append([]uint8(nil), image...),
+		pageSize: pageSize,
+		wide:     size > 2048,
+	}, nil
+}
+
+//LoadSerialEEPROM returns a 24LC EEPROM of size bytes holding the contents of
+//the file at path. If the file does not exist, the EEPROM starts out erased.
+//If saveBack is set, every completed write is written back to the file,
+//which is created if it does not exist.
+func LoadSerialEEPROM(path string, size int, saveBack bool) (*SerialEEPROM, error) {
+	image, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		image = make([]uint8, size)
+		for i := range image {
+			image[i] = 0xff
+		}
+		if saveBack {
+			err = os.WriteFile(path, image, 0666)
+		} else {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(image) != size {
+		return nil, fmt.Errorf("%s: image is %d bytes, the EEPROM %d", path, len(image), size)
+	}
+	e, err := NewSerialEEPROM(image)
+	if err != nil {
+		return nil, err
+	}
+	if saveBack {
+		e.path = path
+	}
+	return e, nil
+}
+
+//Size returns the size of the EEPROM in bytes
+func (e *SerialEEPROM) Size() int {
+	return len(e.data)
+}
+
+//Bytes returns the contents of the EEPROM
+func (e *SerialEEPROM) Bytes() []uint8 {
+	return e.data
+}
+
+//Busy reports whether a write cycle is in progress
+func (e *SerialEEPROM) Busy() bool {
+	return e.m != nil && e.m.Now() < e.busy
+}
+
+func (e *SerialEEPROM) Attach(m *machine.Machine) error {
+	e.m = m
+	opt := e.wireTo
+	if opt == nil {
+		return nil
+	}
+	e.wireTo = nil
+	var err error
+	e.bus, err = opt.connect(m, e)
+	return err
+}
+
+//Reset releases the bus and drops a write that was not stopped
+func (e *SerialEEPROM) Reset() {
+	e.writes = nil
+	if e.bus != nil {
+		e.bus.Reset()
+	}
+}
+
+//Start acknowledges the addresses of the EEPROM unless a write cycle is in
+//progress
+func (e *SerialEEPROM) Start(addr uint8, read bool) bool {
+	if addr&^0x07 != SerialEEPROMAddress || e.Busy() {
+		return false
+	}
+	if e.wide {
+		if addr&0x07 != e.Select {
+			return false
+		}
+	} else {
+		e.block = int(addr&0x07) << 8 & (len(e.data) - 1)
+	}
+	e.received = 0
+	e.writes = e.writes[:0]
+	return true
+}
+
+//Write takes the address bytes and then the bytes to write
+func (e *SerialEEPROM) Write(val uint8) bool {
+	switch {
+	case !e.wide && e.received == 0:
+		e.pointer = e.block | int(val)&(len(e.data)-1)
+	case e.wide && e.received == 0:
+		e.pointer = int(val) << 8 & (len(e.data) - 1)
+	case e.wide && e.received == 1:
+		e.pointer |= int(val)
+	default:
+		e.writes = append(e.writes, serialEEPROMWrite{e.pointer, val})
+		page := e.pointer &^ (e.pageSize - 1)
+		e.pointer = page | (e.pointer+1)&(e.pageSize-1)
+		return true
+	}
+	e.received++
+	return true
+}
+
+//Read returns the byte at the address and moves on to the next one
+func (e *SerialEEPROM) Read() uint8 {
+	val := e.data[e.pointer]
+	e.pointer = (e.pointer + 1) % len(e.data)
+	return val
+}
+
+//Stop starts the write cycle of the bytes written during the transfer
+func (e *SerialEEPROM) Stop() {
+	if len(e.writes) == 0 {
+		return
+	}
+	for _, w := range e.writes {
+		e.data[w.addr] = w.val
+	}
+	page := e.writes[0].addr &^ (e.pageSize - 1)
+	e.writes = e.writes[:0]
+	if e.m != nil {
+		e.busy = e.m.Now() + e.m.Cycles(SerialEEPROMWriteTime)
+	}
+	if e.path != "" {
+		e.save(page)
+	}
+}
+
+//save writes a page back to the image file
+func (e *SerialEEPROM) save(page int) {
+	f, err := os.OpenFile(e.path, os.O_WRONLY, 0)
+	if err == nil {
+		_, err = f.WriteAt(e.data[page:page+e.pageSize], int64(page))
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil && e.Err == nil {
+		e.Err = err
+	}
+}
@@ -0,0 +1,37 @@
+package devices
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSerialEEPROM(t *testing.T) {
+	h := newI2CHost(t)
+	//a 24LC02 with one address byte
+	e, err := NewSerialEEPROM(make([]uint8, 256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addDevice(t, h.m, "eeprom", e)
+	h.bus.Add(e)
+	h.start()
+	if !h.write(SerialEEPROMAddress << 1) {
+		t.Fatal("the EEPROM did not acknowledge its address")
+	}
+	for _, val := range []uint8{0x10, 'a', 'b', 'c'} {
+		if !h.write(val) {
+			t.Fatalf("the EEPROM did not acknowledge %#02x", val)
+		}
+	}
+	h.stop()
+	//the EEPROM ignores its address during the write cycle
+	h.start()
+	if h.write(SerialEEPROMAddress << 1) {
+		t.Fatal("the EEPROM acknowledged its address during the write cycle")
+	}
+	h.stop()
+	h.m.Run(h.m.Cycles(5 * time.Millisecond))
+	if data := h.readRegisters(t, SerialEEPROMAddress, []uint8{0x10}, 3); string(data) != "abc" {
+		t.Fatalf("read %q, want \"abc\"", data)
+	}
+}
@@ -0,0 +1,118 @@
+package devices
+
+import "fmt"
+
+//SPIDevice is a peripheral on an SPI bus. It works a byte at a time.
+type SPIDevice interface {
+	//Select is called when the chip select line of the device is asserted,
+	//with true, or released, with false
+	Select(selected bool)
+	//Transfer is called with every byte the master sends while the device is
+	//selected and returns the byte the device sends during the next one
+	Transfer(in uint8) uint8
+}
+
+//SPIBus is an SPI bus that firmware drives by toggling port pins. The master
+//drives SCK and MOSI, and the selected device drives MISO. Bytes are sent most
+//significant bit first. Every device has a chip select pin of its own, which
+//is active low.
+type SPIBus struct {
+	//Mode is the SPI mode, 0 to 3. Bit 1 is the clock polarity and bit 0 the
+	//clock phase.
+	Mode int
+
+	port            *Port
+	sck, mosi, miso uint8
+	driver          *PortDriver
+	devices         []*spiSlave
+}
+
+//spiSlave is a device on the bus with its chip select pin
+type spiSlave struct {
+	device   SPIDevice
+	cs       *Port
+	pin      uint8
+	selected bool
+	in       uint8 //bits received so far
+	out      uint8 //byte being sent
+	bits     int   //bits received of the current byte
+}
+
+//NewSPIBus returns a mode 0 SPI bus on the SCK, MOSI and MISO pins of a port,
+//given as masks
+func NewSPIBus(port *Port, sck, mosi, miso uint8) *SPIBus {
+	b := &SPIBus{
+		port:   port,
+		sck:    sck,
+		mosi:   mosi,
+		miso:   miso,
+		driver: port.Driver(),
+	}
+	port.Watch(b.clockChanged)
+	return b
+}
+
+//Add adds a device whose chip select is wired to a pin of a port, given as a
+//mask
+func (b *SPIBus) Add(device SPIDevice, cs *Port, pin uint8) error {
+	if pin == 0 {
+		return fmt.Errorf("SPI device has no chip select pin")
+	}
+	s := &spiSlave{device: device, cs: cs, pin: pin}
+	b.devices = append(b.devices, s)
+	cs.Watch(func(old, pins uint8) {
+		if old&pin != pins&pin {
+			b.selectChanged(s, pins&pin == 0)
+		}
+	})
+	if cs.Pins()&pin == 0 {
+		b.selectChanged(s, true)
+	}
+	return nil
+}
+
+func (b *SPIBus) selectChanged(s *spiSlave, selected bool) {
+	s.selected = selected
+	s.bits = 0
+	s.device.Select(selected)
+	if !selected {
+		b.driver.Release(b.miso)
+		return
+	}
+	s.out = 0xff
+	if b.Mode&1 == 0 {
+		//the first bit is on MISO before the first clock edge
+		b.shiftOut(s)
+	}
+}
+
+//shiftOut drives MISO with the next bit a device sends
+func (b *SPIBus) shiftOut(s *spiSlave) {
+	bit := s.out >> (7 - s.bits) & 1
+	b.driver.Set(b.miso, bit*b.miso)
+}
+
+func (b *SPIBus) clockChanged(old, pins uint8) {
+	if old&b.sck == pins&b.sck {
+		return
+	}
+	idle := b.Mode&2 != 0
+	leading := (pins&b.sck != 0) != idle
+	//mode 0 and 2 sample on the leading edge, 1 and 3 on the trailing one
+	sample := leading == (b.Mode&1 == 0)
+	for _, s := range b.devices {
+		if !s.selected {
+			continue
+		}
+		if !sample {
+			b.shiftOut(s)
+			continue
+		}
+		s.in = s.in<<1 | boolBit(pins&b.mosi != 0, 1)
+		s.bits++
+		if s.bits == 8 {
+			s.bits = 0
+			s.out = s.device.Transfer(s.in)
+		}
+	}
+}
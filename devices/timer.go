@@ -0,0 +1,175 @@
+package devices
+
+import "github.com/rdzhaafar/emu6502/machine"
+
+func init() {
+	machine.RegisterDevice("timer", func(options machine.Options) (machine.Device, error) {
+		return NewIntervalTimer(), nil
+	})
+}
+
+//Registers of the interval timer. The period and the counter are 32-bit,
+//low byte first.
+const (
+	TimerPeriod  = 0x0 //cycles between timeouts, 4 bytes
+	TimerCounter = 0x4 //cycles left until the timeout, 4 bytes, read only
+	TimerControl = 0x8 //TimerEnable, TimerPeriodic and TimerIRQEnable
+	TimerStatus  = 0x9 //TimerExpired, written 1 to clear it
+)
+
+//Bits of the control register
+const (
+	TimerEnable    uint8 = 0x01 //setting it starts the timer from the period
+	TimerPeriodic  uint8 = 0x02 //the timer restarts itself after a timeout
+	TimerIRQEnable uint8 = 0x04
+)
+
+//TimerExpired is the status bit set on every timeout
+const TimerExpired uint8 = 0x01
+
+//IntervalTimer is a programmable timer counting cpu cycles. Setting
+//TimerEnable starts it counting down from the period. In one-shot mode it
+//stops at the timeout, and in periodic mode it starts over, so timeouts are
+//exactly a period apart however late the cpu handles them. Every timeout sets
+//TimerExpired, which drives the IRQ line while TimerIRQEnable is set. The
+//timer takes up 16 addresses and has to be added to a machine to run.
+type IntervalTimer struct {
+	period  uint32
+	control uint8
+	status  uint8
+	start   uint64 //cycle the timer was started at
+	stopped uint32 //counter while the timer does not run
+	latch   uint32 //counter latched when its low byte is read
+	event   *machine.Event
+
+	m   *machine.Machine
+	irq *machine.Line
+}
+
+//NewIntervalTimer returns a stopped timer with a period of 0
+func NewIntervalTimer() *IntervalTimer {
+	return &IntervalTimer{}
+}
+
+//Size returns the number of addresses the timer takes up
+func (t *IntervalTimer) Size() int {
+	return 16
+}
+
+func (t *IntervalTimer) Attach(m *machine.Machine) error {
+	t.m = m
+	t.irq = m.IRQ()
+	return nil
+}
+
+//Reset stops the timer and clears its registers
+func (t *IntervalTimer) Reset() {
+	t.period = 0
+	t.control = 0
+	t.status = 0
+	t.stopped = 0
+	t.latch = 0
+	t.cancel()
+	t.update()
+}
+
+func (t *IntervalTimer) cancel() {
+	if t.event != nil {
+		t.event.Cancel()
+		t.event = nil
+	}
+}
+
+//running reports whether the timer counts down
+func (t *IntervalTimer) running() bool {
+	return t.event != nil
+}
+
+//counter returns the cycles left until the timeout
+func (t *IntervalTimer) counter() uint32 {
+	if !t.running() {
+		return t.stopped
+	}
+	return t.period - uint32(t.m.Now()-t.start)
+}
+
+//run starts counting down a period at cycle at. A period of 0 does not
+//count.
+func (t *IntervalTimer) run(at uint64) {
+	t.cancel()
+	t.stopped = 0
+	if t.m == nil || t.period == 0 {
+		return
+	}
+	t.start = at
+	t.event = t.m.Schedule(at+uint64(t.period), t.timeout)
+}
+
+func (t *IntervalTimer) timeout() {
+	t.event = nil
+	t.status |= TimerExpired
+	if t.control&TimerPeriodic != 0 {
+		//count from when the timeout was due, not from when it ran
+		t.run(t.start + uint64(t.period))
+	} else {
+		t.control &^= TimerEnable
+	}
+	t.update()
+}
+
+//update drives the IRQ line
+func (t *IntervalTimer) update() {
+	if t.irq != nil {
+		t.irq.Set(t.status&TimerExpired != 0 && t.control&TimerIRQEnable != 0)
+	}
+}
+
+func (t *IntervalTimer) Read(addr uint16) uint8 {
+	addr &= 0xf
+	switch {
+	case addr < TimerCounter:
+		return uint8(t.period >> (8 * addr))
+	case addr == TimerCounter:
+		//latch the upper bytes, so the counter reads consistently
+		t.latch = t.counter()
+		return uint8(t.latch)
+	case addr < TimerControl:
+		return uint8(t.latch >> (8 * (addr - TimerCounter)))
+	case addr == TimerControl:
+		return t.control
+	case addr == TimerStatus:
+		return t.status
+	}
+	return 0
+}
+
+func (t *IntervalTimer) Write(addr uint16, val uint8) error {
+	addr &= 0xf
+	switch {
+	case addr < TimerCounter:
+		shift := 8 * addr
+		t.period = t.period&^(0xff<<shift) | uint32(val)<<shift
+	case addr == TimerControl:
+		start := val&TimerEnable != 0 && t.control&TimerEnable == 0
+		if val&TimerEnable == 0 && t.running() {
+			t.stopped = t.counter()
+			t.cancel()
+		}
+		t.control = val & (TimerEnable | TimerPeriodic | TimerIRQEnable)
+		if start {
+			t.run(t.now())
+		}
+	case addr == TimerStatus:
+		t.status &^= val
+	}
+	t.update()
+	return nil
+}
+
+//now returns the current cycle
+func (t *IntervalTimer) now() uint64 {
+	if t.m == nil {
+		return 0
+	}
+	return t.m.Now()
+}
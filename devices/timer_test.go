@@ -0,0 +1,58 @@
+package devices
+
+import "testing"
+
+//startTimer sets the period of t and starts it with control
+func startTimer(t *IntervalTimer, period uint32, control uint8) {
+	for i := uint16(0); i < 4; i++ {
+		t.Write(TimerPeriod+i, uint8(period>>(8*i)))
+	}
+	t.Write(TimerControl, TimerEnable|control)
+}
+
+func TestIntervalTimerPeriodic(t *testing.T) {
+	m := newTestMachine(t)
+	timer := NewIntervalTimer()
+	addDevice(t, m, "timer", timer)
+	startTimer(timer, 1000, TimerPeriodic|TimerIRQEnable)
+	m.Run(400)
+	//the counter is latched when its low byte is read
+	if counter := uint16(timer.Read(TimerCounter)) | uint16(timer.Read(TimerCounter+1))<<8; counter != 600 {
+		t.Fatalf("counter is %d, want 600", counter)
+	}
+	for period := 1; period <= 3; period++ {
+		m.Run(599)
+		if timer.Read(TimerStatus) != 0 || m.IRQAsserted() {
+			t.Fatalf("period %d: timer expired early", period)
+		}
+		m.Run(2)
+		if timer.Read(TimerStatus) != TimerExpired || !m.IRQAsserted() {
+			t.Fatalf("period %d: timer did not expire", period)
+		}
+		timer.Write(TimerStatus, TimerExpired)
+		if m.IRQAsserted() {
+			t.Fatalf("period %d: clearing the status did not release IRQ", period)
+		}
+		//the next timeout is a period after the last one was due
+		m.Run(399)
+	}
+}
+
+func TestIntervalTimerOneShot(t *testing.T) {
+	m := newTestMachine(t)
+	timer := NewIntervalTimer()
+	addDevice(t, m, "timer", timer)
+	startTimer(timer, 100, 0)
+	m.Run(101)
+	if timer.Read(TimerStatus) != TimerExpired || timer.Read(TimerControl)&TimerEnable != 0 {
+		t.Fatal("one-shot timer did not expire and stop")
+	}
+	if m.IRQAsserted() {
+		t.Fatal("timer interrupted with IRQs disabled")
+	}
+	timer.Write(TimerStatus, TimerExpired)
+	m.Run(1000)
+	if timer.Read(TimerStatus) != 0 {
+		t.Fatal("one-shot timer expired twice")
+	}
+}
@@ -0,0 +1,630 @@
+package devices
+
+import "github.com/rdzhaafar/emu6502/machine"
+
+func init() {
+	machine.RegisterDevice("via", func(options machine.Options) (machine.Device, error) {
+		return NewVIA(), nil
+	})
+}
+
+//Registers of the VIA
+const (
+	ViaORB   = 0x0 //output/input register B
+	ViaORA   = 0x1 //output/input register A, with handshake
+	ViaDDRB  = 0x2
+	ViaDDRA  = 0x3
+	ViaT1CL  = 0x4 //timer 1 counter low byte, writes go to the latch
+	ViaT1CH  = 0x5 //timer 1 counter high byte, writing it starts the timer
+	ViaT1LL  = 0x6 //timer 1 latch low byte
+	ViaT1LH  = 0x7 //timer 1 latch high byte
+	ViaT2CL  = 0x8 //timer 2 counter low byte, writes go to the latch
+	ViaT2CH  = 0x9 //timer 2 counter high byte, writing it starts the timer
+	ViaSR    = 0xa //shift register
+	ViaACR   = 0xb //auxiliary control register
+	ViaPCR   = 0xc //peripheral control register
+	ViaIFR   = 0xd //interrupt flag register
+	ViaIER   = 0xe //interrupt enable register
+	ViaORANH = 0xf //output/input register A, without handshake
+)
+
+//Interrupt flags of the VIA, as in IFR and IER
+const (
+	ViaIntCA2 uint8 = 0x01
+	ViaIntCA1 uint8 = 0x02
+	ViaIntSR  uint8 = 0x04
+	ViaIntCB2 uint8 = 0x08
+	ViaIntCB1 uint8 = 0x10
+	ViaIntT2  uint8 = 0x20
+	ViaIntT1  uint8 = 0x40
+	ViaIntAny uint8 = 0x80
+)
+
+//Pins of the CA and CB control ports
+const (
+	PinC1 uint8 = 0x01
+	PinC2 uint8 = 0x02
+)
+
+//Bits of the auxiliary control register
+const (
+	acrLatchA    uint8 = 0x01
+	acrLatchB    uint8 = 0x02
+	acrShift     uint8 = 0x1c
+	acrPulses    uint8 = 0x20 //timer 2 counts pulses on PB6
+	acrFreeRun   uint8 = 0x40 //timer 1 reloads itself
+	acrPB7       uint8 = 0x80 //timer 1 drives PB7
+	pb6          uint8 = 0x40
+	pb7          uint8 = 0x80
+	shiftModeOff uint8 = 0
+)
+
+//VIA is a W65C22 versatile interface adapter. It has two 8-bit ports with
+//data direction registers, the CA1/CA2 and CB1/CB2 handshake lines, two 16-bit
+//timers and a shift register, and drives an IRQ line through its interrupt
+//flag and enable registers. It takes up 16 addresses.
+//
+//Peripherals are wired to PortA and PortB, and to the handshake lines on CA
+//and CB, where PinC1 and PinC2 are the C1 and C2 lines. The timers and the
+//shift register run on cpu cycles, so the VIA has to be added to a machine
+//for them to work.
+type VIA struct {
+	PortA *Port
+	PortB *Port
+	CA    *Port
+	CB    *Port
+
+	ora, orb   uint8
+	ddra, ddrb uint8
+	ira, irb   uint8 //inputs latched on the active CA1/CB1 edge
+	acr, pcr   uint8
+	ifr, ier   uint8
+	ca2, cb2   bool //levels driven on CA2 and CB2 in output modes
+
+	t1latch uint16
+	t1start uint64 //cycle timer 1 was loaded at
+	t1count uint16 //value timer 1 was loaded with
+	t1armed bool   //a one-shot timeout interrupts
+	t1event *machine.Event
+	pb7     bool //level timer 1 drives on PB7
+
+	t2latch uint8 //low byte, the high byte is written straight to the counter
+	t2start uint64
+	t2count uint16
+	t2armed bool
+	t2event *machine.Event
+
+	sr      uint8
+	srBits  int    //bits shifted since the shift register was accessed
+	srClock bool   //level driven on CB1 while shifting with an internal clock
+	srAt    uint64 //cycle of the next CB1 edge
+	srEvent *machine.Event
+
+	m   *machine.Machine
+	irq *machine.Line
+}
+
+//NewVIA returns a VIA in its reset state
+func NewVIA() *VIA {
+	v := &VIA{
+		PortA: NewPort(),
+		PortB: NewPort(),
+		CA:    NewPort(),
+		CB:    NewPort(),
+	}
+	v.PortB.Watch(v.portBChanged)
+	v.CA.Watch(v.caChanged)
+	v.CB.Watch(v.cbChanged)
+	v.reset()
+	return v
+}
+
+//Size returns the number of addresses the VIA takes up
+func (v *VIA) Size() int {
+	return 16
+}
+
+func (v *VIA) Attach(m *machine.Machine) error {
+	v.m = m
+	v.irq = m.IRQ()
+	return nil
+}
+
+//Reset clears every register but the timers and the shift register, like
+//the RESB pin
+func (v *VIA) Reset() {
+	v.reset()
+}
+
+func (v *VIA) reset() {
+	v.ora, v.orb, v.ddra, v.ddrb = 0, 0, 0, 0
+	v.acr, v.pcr, v.ifr, v.ier = 0, 0, 0, 0
+	v.ca2, v.cb2 = true, true
+	v.t1armed, v.t2armed = false, false
+	v.pb7 = true
+	v.srBits = 8
+	v.srClock = true
+	v.cancel(&v.t1event)
+	v.cancel(&v.t2event)
+	v.cancel(&v.srEvent)
+	v.updatePorts()
+	v.updateIRQ()
+}
+
+//now returns the current cycle
+func (v *VIA) now() uint64 {
+	if v.m == nil {
+		return 0
+	}
+	return v.m.Now()
+}
+
+//schedule runs f at cycle at, if the VIA is attached to a machine
+func (v *VIA) schedule(event **machine.Event, at uint64, f func()) {
+	v.cancel(event)
+	if v.m != nil {
+		*event = v.m.Schedule(at, f)
+	}
+}
+
+func (v *VIA) cancel(event **machine.Event) {
+	if *event != nil {
+		(*event).Cancel()
+		*event = nil
+	}
+}
+
+//interrupt sets interrupt flags
+func (v *VIA) interrupt(flags uint8) {
+	v.ifr |= flags
+	v.updateIRQ()
+}
+
+//clear clears interrupt flags
+func (v *VIA) clear(flags uint8) {
+	v.ifr &^= flags
+	v.updateIRQ()
+}
+
+//updateIRQ drives the IRQ line from the enabled interrupt flags
+func (v *VIA) updateIRQ() {
+	if v.irq != nil {
+		v.irq.Set(v.ifr&v.ier&0x7f != 0)
+	}
+}
+
+//updatePorts drives the pins from the registers
+func (v *VIA) updatePorts() {
+	v.PortA.set(v.ora, v.ddra)
+	orb, ddrb := v.orb, v.ddrb
+	if v.acr&acrPB7 != 0 {
+		orb = orb&^pb7 | boolBit(v.pb7, pb7)
+		ddrb |= pb7
+	}
+	v.PortB.set(orb, ddrb)
+	var ca, cadir uint8
+	if v.pcr&0x08 != 0 {
+		ca, cadir = boolBit(v.ca2, PinC2), PinC2
+	}
+	v.CA.set(ca, cadir)
+	var cb, cbdir uint8
+	mode := v.shiftMode()
+	switch {
+	case mode != shiftModeOff && mode&0x04 != 0:
+		//shifting out drives CB2 with the data
+		cb, cbdir = boolBit(v.cb2, PinC2), PinC2
+	case mode == shiftModeOff && v.pcr&0x80 != 0:
+		cb, cbdir = boolBit(v.cb2, PinC2), PinC2
+	}
+	if v.internalShiftClock() {
+		cb |= boolBit(v.srClock, PinC1)
+		cbdir |= PinC1
+	}
+	v.CB.set(cb, cbdir)
+}
+
+//boolBit returns bit if b is set and 0 otherwise
+func boolBit(b bool, bit uint8) uint8 {
+	if b {
+		return bit
+	}
+	return 0
+}
+
+func (v *VIA) Read(addr uint16) uint8 {
+	switch addr & 0xf {
+	case ViaORB:
+		v.portBAccessed()
+		irb := v.PortB.Pins()
+		if v.acr&acrLatchB != 0 {
+			irb = v.irb
+		}
+		return v.orb&v.ddrb | irb&^v.ddrb
+	case ViaORA:
+		v.portAAccessed()
+		return v.inputA()
+	case ViaDDRB:
+		return v.ddrb
+	case ViaDDRA:
+		return v.ddra
+	case ViaT1CL:
+		v.clear(ViaIntT1)
+		return uint8(v.t1())
+	case ViaT1CH:
+		return uint8(v.t1() >> 8)
+	case ViaT1LL:
+		return uint8(v.t1latch)
+	case ViaT1LH:
+		return uint8(v.t1latch >> 8)
+	case ViaT2CL:
+		v.clear(ViaIntT2)
+		return uint8(v.t2())
+	case ViaT2CH:
+		return uint8(v.t2() >> 8)
+	case ViaSR:
+		v.startShift()
+		return v.sr
+	case ViaACR:
+		return v.acr
+	case ViaPCR:
+		return v.pcr
+	case ViaIFR:
+		if v.ifr&v.ier&0x7f != 0 {
+			return v.ifr | ViaIntAny
+		}
+		return v.ifr
+	case ViaIER:
+		return v.ier | 0x80
+	default: //ViaORANH
+		return v.inputA()
+	}
+}
+
+//inputA returns the levels on port A, or the levels latched on the last CA1
+//edge if latching is enabled
+func (v *VIA) inputA() uint8 {
+	if v.acr&acrLatchA != 0 {
+		return v.ira
+	}
+	return v.PortA.Pins()
+}
+
+func (v *VIA) Write(addr uint16, val uint8) error {
+	switch addr & 0xf {
+	case ViaORB:
+		v.orb = val
+		v.portBAccessed()
+		if (v.pcr>>5)&0x6 == 0x4 {
+			//handshake and pulse output modes only react to writes on port B
+			v.handshake(&v.cb2, v.pcr>>5)
+		}
+		v.updatePorts()
+	case ViaORA:
+		v.ora = val
+		v.portAAccessed()
+		v.updatePorts()
+	case ViaDDRB:
+		v.ddrb = val
+		v.updatePorts()
+	case ViaDDRA:
+		v.ddra = val
+		v.updatePorts()
+	case ViaT1CL, ViaT1LL:
+		v.t1latch = v.t1latch&0xff00 | uint16(val)
+	case ViaT1CH:
+		v.t1latch = v.t1latch&0x00ff | uint16(val)<<8
+		v.clear(ViaIntT1)
+		v.t1armed = true
+		v.loadT1(v.now(), v.t1latch)
+		if v.acr&acrPB7 != 0 {
+			v.pb7 = false
+			v.updatePorts()
+		}
+	case ViaT1LH:
+		v.t1latch = v.t1latch&0x00ff | uint16(val)<<8
+		v.clear(ViaIntT1)
+	case ViaT2CL:
+		v.t2latch = val
+	case ViaT2CH:
+		v.clear(ViaIntT2)
+		v.t2start = v.now()
+		v.t2count = uint16(val)<<8 | uint16(v.t2latch)
+		v.t2armed = true
+		v.scheduleT2()
+	case ViaSR:
+		v.sr = val
+		v.startShift()
+	case ViaACR:
+		v.writeACR(val)
+	case ViaPCR:
+		v.pcr = val
+		//manual output modes drive the level in their low bit
+		if v.pcr&0x0c == 0x0c {
+			v.ca2 = v.pcr&0x02 != 0
+		}
+		if v.pcr&0xc0 == 0xc0 {
+			v.cb2 = v.pcr&0x20 != 0
+		}
+		v.updatePorts()
+	case ViaIFR:
+		v.clear(val & 0x7f)
+	case ViaIER:
+		if val&0x80 != 0 {
+			v.ier |= val & 0x7f
+		} else {
+			v.ier &^= val & 0x7f
+		}
+		v.updateIRQ()
+	default: //ViaORANH
+		v.ora = val
+		v.updatePorts()
+	}
+	return nil
+}
+
+//portAAccessed clears the CA interrupts and runs the CA2 handshake after a
+//read or write of ORA
+func (v *VIA) portAAccessed() {
+	flags := ViaIntCA1
+	if v.pcr&0x0a != 0x02 {
+		//independent interrupt input modes keep their flag
+		flags |= ViaIntCA2
+	}
+	v.clear(flags)
+	v.handshake(&v.ca2, v.pcr>>1)
+}
+
+//portBAccessed clears the CB interrupts after a read or write of ORB
+func (v *VIA) portBAccessed() {
+	flags := ViaIntCB1
+	if v.pcr&0xa0 != 0x20 {
+		flags |= ViaIntCB2
+	}
+	v.clear(flags)
+}
+
+//handshake pulls C2 low in the handshake and pulse output modes. mode is the
+//3-bit C2 control field. In pulse mode C2 goes back high after a cycle.
+func (v *VIA) handshake(c2 *bool, mode uint8) {
+	switch mode & 0x7 {
+	case 0x4:
+		*c2 = false
+		v.updatePorts()
+	case 0x5:
+		*c2 = false
+		v.updatePorts()
+		if v.m != nil {
+			v.m.After(1, func() {
+				*c2 = true
+				v.updatePorts()
+			})
+		}
+	}
+}
+
+func (v *VIA) writeACR(val uint8) {
+	now := v.now()
+	//keep the timers counting from where they are
+	t1 := v.t1()
+	t2 := v.t2()
+	oldShift := v.shiftMode()
+	v.acr = val
+	v.loadT1(now, t1)
+	v.t2start, v.t2count = now, t2
+	v.scheduleT2()
+	if v.shiftMode() != oldShift {
+		v.cancel(&v.srEvent)
+		v.srBits = 8
+		v.srClock = true
+		if v.shiftMode() == 0x4 {
+			//free running shift out starts right away
+			v.startShift()
+		}
+	}
+	v.updatePorts()
+}
+
+//count returns the value of a timer loaded with count at cycle start
+func (v *VIA) count(start uint64, count uint16) uint16 {
+	now := v.now()
+	if now < start {
+		//between the roll over and the reload of a free running timer 1
+		return 0xffff
+	}
+	return count - uint16(now-start)
+}
+
+//t1 returns the current value of timer 1
+func (v *VIA) t1() uint16 {
+	return v.count(v.t1start, v.t1count)
+}
+
+//loadT1 starts timer 1 counting down from count at cycle at. The timer times
+//out when it rolls over from 0 to FFFF.
+func (v *VIA) loadT1(at uint64, count uint16) {
+	v.t1start = at
+	v.t1count = count
+	if v.t1armed || v.acr&acrFreeRun != 0 {
+		v.schedule(&v.t1event, at+uint64(count)+1, v.timeoutT1)
+	} else {
+		v.cancel(&v.t1event)
+	}
+}
+
+func (v *VIA) timeoutT1() {
+	v.t1event = nil
+	//events run between instructions, so count from when the timeout was due
+	timeout := v.t1start + uint64(v.t1count) + 1
+	if v.acr&acrFreeRun != 0 {
+		v.interrupt(ViaIntT1)
+		v.pb7 = !v.pb7
+		v.updatePorts()
+		//the latch is reloaded a cycle after the roll over
+		v.loadT1(timeout+1, v.t1latch)
+		return
+	}
+	if v.t1armed {
+		v.t1armed = false
+		v.interrupt(ViaIntT1)
+		v.pb7 = true
+		v.updatePorts()
+	}
+}
+
+//t2 returns the current value of timer 2
+func (v *VIA) t2() uint16 {
+	if v.acr&acrPulses != 0 {
+		return v.t2count
+	}
+	return v.count(v.t2start, v.t2count)
+}
+
+//scheduleT2 schedules the timeout of timer 2 when it counts cycles
+func (v *VIA) scheduleT2() {
+	v.cancel(&v.t2event)
+	if !v.t2armed || v.acr&acrPulses != 0 {
+		return
+	}
+	v.schedule(&v.t2event, v.t2start+uint64(v.t2count)+1, v.timeoutT2)
+}
+
+func (v *VIA) timeoutT2() {
+	v.t2event = nil
+	if v.t2armed {
+		v.t2armed = false
+		v.interrupt(ViaIntT2)
+	}
+}
+
+//shiftMode returns the shift register mode from ACR
+func (v *VIA) shiftMode() uint8 {
+	return (v.acr & acrShift) >> 2
+}
+
+//internalShiftClock reports whether the shift register drives CB1
+func (v *VIA) internalShiftClock() bool {
+	mode := v.shiftMode()
+	return mode != shiftModeOff && mode&0x3 != 0x3
+}
+
+//shiftHalfPeriod returns the number of cycles between two CB1 edges
+func (v *VIA) shiftHalfPeriod() uint64 {
+	if v.shiftMode()&0x3 == 0x2 {
+		//shifting at the cpu clock rate
+		return 1
+	}
+	return uint64(v.t2latch) + 2
+}
+
+//startShift starts a shift of 8 bits after the shift register was read or
+//written
+func (v *VIA) startShift() {
+	v.clear(ViaIntSR)
+	mode := v.shiftMode()
+	if mode == shiftModeOff {
+		return
+	}
+	v.srBits = 0
+	if mode&0x04 != 0 {
+		//the first bit goes out right away
+		v.cb2 = v.sr&0x80 != 0
+		v.updatePorts()
+	}
+	if v.internalShiftClock() {
+		v.srAt = v.now() + v.shiftHalfPeriod()
+		v.schedule(&v.srEvent, v.srAt, v.shiftClock)
+	}
+}
+
+//shiftClock toggles CB1 when the shift register runs on an internal clock
+func (v *VIA) shiftClock() {
+	v.srEvent = nil
+	v.srClock = !v.srClock
+	v.updatePorts()
+	v.shiftEdge(v.srClock)
+	if v.srBits < 8 || v.shiftMode() == 0x4 {
+		v.srAt += v.shiftHalfPeriod()
+		v.schedule(&v.srEvent, v.srAt, v.shiftClock)
+	}
+}
+
+//shiftEdge shifts a bit on an edge of CB1. Data is shifted in on the rising
+//edge and the next bit is put out on the falling edge.
+func (v *VIA) shiftEdge(rising bool) {
+	mode := v.shiftMode()
+	if mode == shiftModeOff || v.srBits >= 8 && mode != 0x4 {
+		return
+	}
+	out := mode&0x04 != 0
+	if !rising {
+		if out {
+			v.cb2 = v.sr&0x80 != 0
+			v.updatePorts()
+		}
+		return
+	}
+	if out {
+		//shifting out rotates, so the register is unchanged after 8 bits
+		v.sr = v.sr<<1 | v.sr>>7
+	} else {
+		v.sr = v.sr<<1 | boolBit(v.CB.Pins()&PinC2 != 0, 1)
+	}
+	v.srBits++
+	if v.srBits == 8 && mode != 0x4 {
+		v.interrupt(ViaIntSR)
+	}
+	if mode == 0x4 && v.srBits == 8 {
+		v.srBits = 0
+	}
+}
+
+//edge reports whether a pin saw the edge selected by positive
+func edge(old, pins, pin uint8, positive bool) bool {
+	if old&pin == pins&pin {
+		return false
+	}
+	return (pins&pin != 0) == positive
+}
+
+func (v *VIA) portBChanged(old, pins uint8) {
+	if v.acr&acrPulses != 0 && edge(old, pins, pb6, false) {
+		v.t2count--
+		if v.t2count == 0 && v.t2armed {
+			v.t2armed = false
+			v.interrupt(ViaIntT2)
+		}
+	}
+}
+
+func (v *VIA) caChanged(old, pins uint8) {
+	if edge(old, pins, PinC1, v.pcr&0x01 != 0) {
+		v.ira = v.PortA.Pins()
+		v.interrupt(ViaIntCA1)
+		if v.pcr&0x0e == 0x08 {
+			//handshake mode releases CA2 on the active CA1 edge
+			v.ca2 = true
+			v.updatePorts()
+		}
+	}
+	if v.pcr&0x08 == 0 && edge(old, pins, PinC2, v.pcr&0x04 != 0) {
+		v.interrupt(ViaIntCA2)
+	}
+}
+
+func (v *VIA) cbChanged(old, pins uint8) {
+	if !v.internalShiftClock() && edge(old, pins, PinC1, v.pcr&0x10 != 0) {
+		v.irb = v.PortB.Pins()
+		v.interrupt(ViaIntCB1)
+		if v.pcr&0xe0 == 0x80 {
+			v.cb2 = true
+			v.updatePorts()
+		}
+	}
+	if v.shiftMode()&0x3 == 0x3 && old&PinC1 != pins&PinC1 {
+		//shifting on an external clock
+		v.shiftEdge(pins&PinC1 != 0)
+	}
+	if v.shiftMode() == shiftModeOff && v.pcr&0x80 == 0 && edge(old, pins, PinC2, v.pcr&0x40 != 0) {
+		v.interrupt(ViaIntCB2)
+	}
+}
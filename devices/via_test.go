@@ -0,0 +1,125 @@
+package devices
+
+import "testing"
+
+func TestVIATimer1(t *testing.T) {
+	m := newTestMachine(t)
+	v := NewVIA()
+	addDevice(t, m, "via", v)
+	v.Write(ViaIER, 0x80|ViaIntT1)
+	v.Write(ViaT1CL, 0x20)
+	v.Write(ViaT1CH, 0x00)
+	m.Run(0x10)
+	if t1 := v.Read(ViaT1CL); t1 != 0x10 {
+		t.Fatalf("timer 1 is %#02x after 10 cycles, want 10", t1)
+	}
+	m.Run(0x10)
+	if v.Read(ViaIFR)&ViaIntT1 != 0 {
+		t.Fatal("timer 1 timed out before rolling over")
+	}
+	m.Run(2)
+	if v.Read(ViaIFR) != ViaIntAny|ViaIntT1 || !m.IRQAsserted() {
+		t.Fatal("timer 1 did not interrupt")
+	}
+	//reading the low byte of the counter clears the interrupt
+	v.Read(ViaT1CL)
+	if v.Read(ViaIFR)&ViaIntT1 != 0 || m.IRQAsserted() {
+		t.Fatal("reading T1CL did not clear the interrupt")
+	}
+	//a one-shot timer interrupts once
+	m.Run(0x10000)
+	if v.Read(ViaIFR)&ViaIntT1 != 0 {
+		t.Fatal("one-shot timer 1 interrupted twice")
+	}
+}
+
+func TestVIATimer1FreeRunning(t *testing.T) {
+	m := newTestMachine(t)
+	v := NewVIA()
+	addDevice(t, m, "via", v)
+	//free running with square wave output on PB7
+	v.Write(ViaACR, acrFreeRun|acrPB7)
+	v.Write(ViaT1CL, 0x0e)
+	v.Write(ViaT1CH, 0x00)
+	if v.PortB.Pins()&pb7 != 0 {
+		t.Fatal("PB7 is not low after starting timer 1")
+	}
+	//the timer reloads every latch + 2 cycles
+	for period := 0; period < 4; period++ {
+		m.Run(0x10)
+		if v.Read(ViaIFR)&ViaIntT1 == 0 {
+			t.Fatalf("period %d: timer 1 did not interrupt", period)
+		}
+		v.Write(ViaIFR, ViaIntT1)
+		want := period%2 == 0
+		if high := v.PortB.Pins()&pb7 != 0; high != want {
+			t.Fatalf("period %d: PB7 is high %v, want %v", period, high, want)
+		}
+	}
+}
+
+func TestVIATimer2CountsPulses(t *testing.T) {
+	m := newTestMachine(t)
+	v := NewVIA()
+	addDevice(t, m, "via", v)
+	pins := v.PortB.Driver()
+	v.Write(ViaACR, acrPulses)
+	v.Write(ViaT2CL, 0x03)
+	v.Write(ViaT2CH, 0x00)
+	for pulse := 0; pulse < 3; pulse++ {
+		if v.Read(ViaIFR)&ViaIntT2 != 0 {
+			t.Fatalf("timer 2 interrupted after %d pulses", pulse)
+		}
+		pins.Drive(pb6, 0)
+		pins.Release(pb6)
+	}
+	if v.Read(ViaIFR)&ViaIntT2 == 0 {
+		t.Fatal("timer 2 did not interrupt after 3 pulses")
+	}
+}
+
+func TestVIAShiftOut(t *testing.T) {
+	m := newTestMachine(t)
+	v := NewVIA()
+	addDevice(t, m, "via", v)
+	//collect CB2 on every rising edge of CB1, like a shift register would
+	var shifted uint8
+	var bits int
+	v.CB.Watch(func(old, pins uint8) {
+		if edge(old, pins, PinC1, true) {
+			shifted = shifted<<1 | pins&PinC2>>1
+			bits++
+		}
+	})
+	//shift out at the cpu clock rate
+	v.Write(ViaACR, 0x18)
+	v.Write(ViaSR, 0xa5)
+	m.Run(0x20)
+	if bits != 8 || shifted != 0xa5 {
+		t.Fatalf("shifted out %d bits %#02x, want 8 bits a5", bits, shifted)
+	}
+	if v.Read(ViaIFR)&ViaIntSR == 0 {
+		t.Fatal("the shift register did not interrupt")
+	}
+}
+
+func TestVIAShiftInExternalClock(t *testing.T) {
+	m := newTestMachine(t)
+	v := NewVIA()
+	addDevice(t, m, "via", v)
+	cb := v.CB.Driver()
+	//shift in on CB1
+	v.Write(ViaACR, 0x0c)
+	v.Read(ViaSR)
+	for i := 0; i < 8; i++ {
+		bit := uint8(0x5a) >> (7 - i) & 1
+		cb.Drive(PinC1|PinC2, bit<<1)
+		cb.Drive(PinC2, bit<<1)
+	}
+	if v.Read(ViaIFR)&ViaIntSR == 0 {
+		t.Fatal("the shift register did not interrupt")
+	}
+	if sr := v.Read(ViaSR); sr != 0x5a {
+		t.Fatalf("shifted in %#02x, want 5a", sr)
+	}
+}
@@ -0,0 +1,314 @@
+package machine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rdzhaafar/emu6502/core"
+)
+
+//Address is a bus address in a machine config. It is written either as a
+//number or as a hexadecimal string like "0x8000" or "$8000".
+type Address uint16
+
+func (a *Address) UnmarshalJSON(data []byte) error {
+	var val uint64
+	var err error
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		err = json.Unmarshal(data, &s)
+		if err != nil {
+			return err
+		}
+		s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(s, "$"), "0x"), "0X")
+		val, err = strconv.ParseUint(s, 16, 16)
+	} else {
+		val, err = strconv.ParseUint(string(data), 10, 16)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid address %s", data)
+	}
+	*a = Address(val)
+	return nil
+}
+
+func (a Address) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("0x%04X", uint16(a)))
+}
+
+//Supported cpu variants
+const (
+	CPU65C02 = "65c02"
+)
+
+//Config describes a machine. See the machine README for the file format.
+type Config struct {
+	//CPU is the cpu variant, only "65c02" is supported
+	CPU string `json:"cpu,omitempty"`
+	//Clock is the clock speed in Hz
+	Clock  uint64         `json:"clock,omitempty"`
+	Memory []MemoryConfig `json:"memory"`
+	//Plugins are Go plugins loaded before the devices are created, so that
+	//devices can use the types they register
+	Plugins []string       `json:"plugins,omitempty"`
+	Devices []DeviceConfig `json:"devices,omitempty"`
+	//PC is the initial program counter. If it is not set, the machine is
+	//reset and starts at the reset vector.
+	PC *Address `json:"pc,omitempty"`
+
+	dir string //directory file names are relative to
+}
+
+//Memory types
+const (
+	MemoryRAM = "ram"
+	MemoryROM = "rom"
+)
+
+//MemoryConfig describes a RAM or ROM region
+type MemoryConfig struct {
+	Name  string  `json:"name"`
+	Type  string  `json:"type"`
+	Start Address `json:"start"`
+	//End is the last address of the region. It defaults to the end of the
+	//memory.
+	End *Address `json:"end,omitempty"`
+	//Size is the size of the memory. If the region is larger, the memory is
+	//mirrored throughout it. RAM defaults to the size of the region and ROM
+	//to the size of its image.
+	Size *Address `json:"size,omitempty"`
+	//Image is the file the memory is loaded from. It is required for ROM.
+	Image string `json:"image,omitempty"`
+	//Writes is what happens on writes to ROM, either "error" or "ignore"
+	Writes     string `json:"writes,omitempty"`
+	WaitStates int    `json:"wait_states,omitempty"`
+}
+
+//DeviceConfig describes a device
+type DeviceConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	//Base is the first address of the device. Devices without a base are not
+	//mapped to the bus.
+	Base *Address `json:"base,omitempty"`
+	//End is the last address of the device. It defaults to the end of the
+	//device if the device reports its size, and to Base otherwise.
+	End        *Address        `json:"end,omitempty"`
+	WaitStates int             `json:"wait_states,omitempty"`
+	Options    json.RawMessage `json:"options,omitempty"`
+}
+
+//Sizer is implemented by devices that know how many addresses they take up
+type Sizer interface {
+	Size() int
+}
+
+//ParseConfig reads a JSON machine config. Relative file names in the config
+//are relative to dir.
+func ParseConfig(r io.Reader, dir string) (*Config, error) {
+	config := &Config{dir: dir}
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(config)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+//LoadConfig reads the JSON machine config at path
+func LoadConfig(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	config, err := ParseConfig(file, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+//Load builds the machine described by the JSON config at path
+func Load(path string) (*Machine, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := config.Build()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+//Build builds the machine described by the config, then either resets it or
+//sets the program counter to PC
+func (config *Config) Build() (*Machine, error) {
+	switch strings.ToLower(config.CPU) {
+	case "", CPU65C02, "w65c02s", "wdc65c02":
+	default:
+		return nil, fmt.Errorf("unsupported cpu %q", config.CPU)
+	}
+	for _, name := range config.Plugins {
+		//the errors of LoadPlugin name the plugin
+		_, err := LoadPlugin(config.path(name))
+		if err != nil {
+			return nil, err
+		}
+	}
+	m := New(core.NewMappedBus())
+	if config.Clock != 0 {
+		m.Clock = config.Clock
+	}
+	for _, mem := range config.Memory {
+		err := config.mapMemory(m, mem)
+		if err != nil {
+			return nil, fmt.Errorf("memory %s: %w", mem.Name, err)
+		}
+	}
+	for _, dev := range config.Devices {
+		err := config.addDevice(m, dev)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("device %s: %w", dev.Name, err)
+		}
+	}
+	m.Reset()
+	if config.PC != nil {
+		m.CPU.Registers.ProgramCounter = uint16(*config.PC)
+	}
+	return m, nil
+}
+
+func (config *Config) mapMemory(m *Machine, mem MemoryConfig) error {
+	var device core.SystemBus
+	var size int
+	switch mem.Type {
+	case MemoryRAM:
+		size = regionSize(mem.Start, mem.End)
+		if mem.Size != nil {
+			size = int(*mem.Size)
+		}
+		ram := core.NewRAM(size)
+		if mem.Image != "" {
+			image, err := os.ReadFile(config.path(mem.Image))
+			if err != nil {
+				return err
+			}
+			ram.Load(0, image)
+		}
+		device = ram
+	case MemoryROM:
+		if mem.Image == "" {
+			return fmt.Errorf("rom needs an image")
+		}
+		image, err := os.ReadFile(config.path(mem.Image))
+		if err != nil {
+			return err
+		}
+		size = len(image)
+		if mem.Size != nil {
+			size = int(*mem.Size)
+			padded := make([]uint8, size)
+			copy(padded, image)
+			image = padded
+		}
+		policy := core.ROMWriteError
+		switch mem.Writes {
+		case "", "error":
+		case "ignore":
+			policy = core.ROMWriteIgnore
+		default:
+			return fmt.Errorf("invalid rom write policy %q", mem.Writes)
+		}
+		device = core.NewROM(image, policy)
+	default:
+		return fmt.Errorf("unknown memory type %q", mem.Type)
+	}
+	if size <= 0 {
+		return fmt.Errorf("memory size is zero")
+	}
+	return m.Bus.MapRegion(config.region(mem.Name, mem.Start, mem.End, size, mem.WaitStates, device))
+}
+
+func (config *Config) addDevice(m *Machine, dev DeviceConfig) error {
+	device, err := newDevice(dev.Type, Options{raw: dev.Options, dir: config.dir})
+	if err != nil {
+		return err
+	}
+	err = m.Add(dev.Name, device)
+	if err != nil {
+		//the machine only closes the devices that were added to it
+		if closer, ok := device.(io.Closer); ok {
+			closer.Close()
+		}
+		return err
+	}
+	if dev.Base == nil {
+		return nil
+	}
+	bus, ok := device.(core.SystemBus)
+	if !ok {
+		return fmt.Errorf("device type %s can not be mapped to the bus", dev.Type)
+	}
+	size := 1
+	if sizer, ok := device.(Sizer); ok {
+		size = sizer.Size()
+	}
+	return m.Bus.MapRegion(config.region(dev.Name, *dev.Base, dev.End, size, dev.WaitStates, bus))
+}
+
+//region returns the region of a memory or device of size bytes at start. If
+//the region ends after start+size-1, the device is mirrored.
+func (config *Config) region(name string, start Address, end *Address, size int, waits int, device core.SystemBus) core.Region {
+	last := int(start) + size - 1
+	if end != nil {
+		last = int(*end)
+	}
+	if last > 0xffff {
+		last = 0xffff
+	}
+	region := core.Region{
+		Name:       name,
+		Start:      uint16(start),
+		End:        uint16(last),
+		Mask:       0xffff,
+		Device:     device,
+		WaitStates: waits,
+	}
+	if last-int(start)+1 > size {
+		region.Mask = uint16(mirrorSize(size) - 1)
+	}
+	return region
+}
+
+//regionSize returns the size of the region from start to end, or to the end
+//of the address space if end is nil
+func regionSize(start Address, end *Address) int {
+	if end == nil {
+		return core.MaxBusSize - int(start)
+	}
+	return int(*end) - int(start) + 1
+}
+
+//mirrorSize rounds size up to a power of two, since address decoders can only
+//mirror by ignoring address lines
+func mirrorSize(size int) int {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	return n
+}
+
+//path resolves a file name relative to the directory of the config file
+func (config *Config) path(name string) string {
+	return Options{dir: config.dir}.Path(name)
+}
@@ -0,0 +1,283 @@
+package machine
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rdzhaafar/emu6502/core"
+)
+
+//testDevice is a device with 4 registers that holds on to a host resource
+type testDevice struct {
+	regs   [4]uint8
+	closed bool
+}
+
+func (d *testDevice) Attach(m *Machine) error {
+	return nil
+}
+
+func (d *testDevice) Reset() {
+}
+
+func (d *testDevice) Size() int {
+	return len(d.regs)
+}
+
+func (d *testDevice) Read(addr uint16) uint8 {
+	return d.regs[addr]
+}
+
+func (d *testDevice) Write(addr uint16, val uint8) error {
+	d.regs[addr] = val
+	return nil
+}
+
+func (d *testDevice) Close() error {
+	d.closed = true
+	return nil
+}
+
+//testDevices are the devices built by the "test" device type
+var testDevices []*testDevice
+
+func init() {
+	RegisterDevice("test", func(options Options) (Device, error) {
+		d := &testDevice{}
+		testDevices = append(testDevices, d)
+		return d, nil
+	})
+	RegisterDevice("test-broken", func(options Options) (Device, error) {
+		return nil, errors.New("broken")
+	})
+}
+
+//buildConfig parses and builds a config, with file names relative to dir
+func buildConfig(dir, config string) (*Machine, error) {
+	c, err := ParseConfig(strings.NewReader(config), dir)
+	if err != nil {
+		return nil, err
+	}
+	return c.Build()
+}
+
+//writeImage writes a ROM image to dir
+func writeImage(t *testing.T, dir, name string, image []uint8) {
+	err := os.WriteFile(filepath.Join(dir, name), image, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddressUnmarshal(t *testing.T) {
+	tests := []struct {
+		json string
+		want Address
+		ok   bool
+	}{
+		{`"0x8000"`, 0x8000, true},
+		{`"0XABCD"`, 0xabcd, true},
+		{`"$ff"`, 0x00ff, true},
+		{`"ffff"`, 0xffff, true},
+		{`4096`, 4096, true},
+		{`65535`, 0xffff, true},
+		{`65536`, 0, false},
+		{`"0x10000"`, 0, false},
+		{`"0xzz"`, 0, false},
+		{`-1`, 0, false},
+		{`1.5`, 0, false},
+		{`true`, 0, false},
+	}
+	for _, test := range tests {
+		var a Address
+		err := json.Unmarshal([]byte(test.json), &a)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: error %v, want ok %v", test.json, err, test.ok)
+			continue
+		}
+		if test.ok && a != test.want {
+			t.Errorf("%s is %#04x, want %#04x", test.json, uint16(a), uint16(test.want))
+		}
+	}
+}
+
+func TestMemoryRegions(t *testing.T) {
+	tests := []struct {
+		memory string
+		end    uint16
+		mask   uint16
+	}{
+		{`{"name": "ram", "type": "ram", "start": "0x0000", "end": "0x1fff"}`, 0x1fff, 0xffff},
+		{`{"name": "ram", "type": "ram", "start": "0x0000", "end": "0x1fff", "size": "0x800"}`, 0x1fff, 0x07ff},
+		//mirrors repeat at the next power of two
+		{`{"name": "ram", "type": "ram", "start": "0x0000", "end": "0x1fff", "size": "0x600"}`, 0x1fff, 0x07ff},
+		{`{"name": "ram", "type": "ram", "start": "0xc000"}`, 0xffff, 0xffff},
+		//regions are cut off at the end of the address space
+		{`{"name": "ram", "type": "ram", "start": "0xf000", "size": "0x2000"}`, 0xffff, 0xffff},
+	}
+	for _, test := range tests {
+		m, err := buildConfig("", `{"memory": [`+test.memory+`]}`)
+		if err != nil {
+			t.Errorf("%s: %v", test.memory, err)
+			continue
+		}
+		region := m.Bus.Regions()[0]
+		if region.End != test.end || region.Mask != test.mask {
+			t.Errorf("%s: region ends at %#04x with mask %#04x, want %#04x and %#04x",
+				test.memory, region.End, region.Mask, test.end, test.mask)
+		}
+	}
+	m, err := buildConfig("", `{"memory": [{"name": "ram", "type": "ram", "start": "0x0000", "end": "0x1fff", "size": "0x800"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Bus.Write(0x0001, 0x42)
+	if m.Bus.Read(0x0801) != 0x42 || m.Bus.Read(0x1801) != 0x42 {
+		t.Fatal("mirrored RAM does not repeat every 0x800 bytes")
+	}
+}
+
+func TestROMImages(t *testing.T) {
+	dir := t.TempDir()
+	writeImage(t, dir, "rom.bin", []uint8{0x11, 0x22})
+	m, err := buildConfig(dir, `{"memory": [
+		{"name": "padded", "type": "rom", "start": "0x1000", "image": "rom.bin", "size": 4},
+		{"name": "ignored", "type": "rom", "start": "0x2000", "image": "rom.bin", "writes": "ignore"}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[uint16]uint8{0x1000: 0x11, 0x1001: 0x22, 0x1002: 0x00, 0x1003: 0x00} {
+		if val := m.Bus.Read(addr); val != want {
+			t.Errorf("%#04x is %#02x, want %#02x", addr, val, want)
+		}
+	}
+	if region := m.Bus.Lookup(0x2002); region != nil {
+		t.Errorf("ROM without a size takes up %#04x past its image", 0x2002)
+	}
+	if err := m.Bus.Write(0x1000, 0); !errors.Is(err, core.ErrReadOnly) {
+		t.Errorf("write to ROM returned %v, want ErrReadOnly", err)
+	}
+	if err := m.Bus.Write(0x2000, 0); err != nil || m.Bus.Read(0x2000) != 0x11 {
+		t.Errorf("write to ROM ignoring writes returned %v and changed it", err)
+	}
+
+	for _, memory := range []string{
+		`{"name": "rom", "type": "rom", "start": "0x1000", "image": "rom.bin", "writes": "sometimes"}`,
+		`{"name": "rom", "type": "rom", "start": "0x1000"}`,
+		`{"name": "rom", "type": "rom", "start": "0x1000", "image": "missing.bin"}`,
+		`{"name": "eprom", "type": "eprom", "start": "0x1000", "image": "rom.bin"}`,
+	} {
+		_, err := buildConfig(dir, `{"memory": [`+memory+`]}`)
+		if err == nil {
+			t.Errorf("%s: built a machine", memory)
+		}
+	}
+}
+
+func TestStartPC(t *testing.T) {
+	dir := t.TempDir()
+	writeImage(t, dir, "vectors.bin", []uint8{0x00, 0x00, 0x00, 0x00, 0x34, 0x12, 0x00, 0x00})
+	memory := `"memory": [{"name": "rom", "type": "rom", "start": "0xfff8", "image": "vectors.bin"}]`
+	tests := []struct {
+		config string
+		pc     uint16
+	}{
+		{`{` + memory + `}`, 0x1234},
+		{`{` + memory + `, "pc": "0xc000"}`, 0xc000},
+	}
+	for _, test := range tests {
+		m, err := buildConfig(dir, test.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pc := m.CPU.Registers.ProgramCounter; pc != test.pc {
+			t.Errorf("%s: machine starts at %#04x, want %#04x", test.config, pc, test.pc)
+		}
+	}
+}
+
+func TestConfigRejectsUnknownFields(t *testing.T) {
+	for _, config := range []string{
+		`{"memory": [], "clok": 1000000}`,
+		`{"memory": [{"name": "ram", "type": "ram", "start": 0, "mirror": true}]}`,
+		`{"memory": [], "devices": [{"name": "test", "type": "test", "address": "0x8000"}]}`,
+	} {
+		_, err := ParseConfig(strings.NewReader(config), "")
+		if err == nil {
+			t.Errorf("%s: parsed a config with an unknown field", config)
+		}
+	}
+}
+
+func TestCPUVariants(t *testing.T) {
+	tests := []struct {
+		cpu string
+		ok  bool
+	}{
+		{"", true},
+		{"65c02", true},
+		{"W65C02S", true},
+		{"wdc65c02", true},
+		{"6502", false},
+		{"65816", false},
+	}
+	for _, test := range tests {
+		_, err := buildConfig("", `{"cpu": "`+test.cpu+`", "memory": []}`)
+		if (err == nil) != test.ok {
+			t.Errorf("cpu %q: error %v, want ok %v", test.cpu, err, test.ok)
+		}
+	}
+}
+
+func TestBuildClosesDevicesOnError(t *testing.T) {
+	tests := []struct {
+		name    string
+		devices string
+		built   int //number of test devices built before the failure
+	}{
+		{"failing device", `{"name": "a", "type": "test"}, {"name": "b", "type": "test"}, {"name": "c", "type": "test-broken"}`, 2},
+		{"unmappable device", `{"name": "a", "type": "test"}, {"name": "b", "type": "test", "base": "0x8000", "wait_states": -1}`, 2},
+		{"duplicate name", `{"name": "a", "type": "test"}, {"name": "a", "type": "test"}`, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testDevices = nil
+			_, err := buildConfig("", `{"memory": [], "devices": [`+test.devices+`]}`)
+			if err == nil {
+				t.Fatal("built a machine")
+			}
+			if len(testDevices) != test.built {
+				t.Fatalf("built %d devices, want %d", len(testDevices), test.built)
+			}
+			for i, d := range testDevices {
+				if !d.closed {
+					t.Errorf("device %d was not closed", i)
+				}
+			}
+		})
+	}
+}
+
+func TestDevicesAreMapped(t *testing.T) {
+	testDevices = nil
+	m, err := buildConfig("", `{"memory": [], "devices": [
+		{"name": "sized", "type": "test", "base": "0x8000"},
+		{"name": "mirrored", "type": "test", "base": "0x9000", "end": "0x9fff"}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Bus.Write(0x8003, 0x42)
+	m.Bus.Write(0x9ff1, 0x24)
+	if testDevices[0].regs[3] != 0x42 || testDevices[1].regs[1] != 0x24 {
+		t.Fatalf("devices have registers % x and % x", testDevices[0].regs, testDevices[1].regs)
+	}
+	if m.Bus.Lookup(0x8004) != nil {
+		t.Fatal("device without an end takes up more than its size")
+	}
+}
@@ -0,0 +1,103 @@
+package machine
+
+import "container/heap"
+
+//Event is a callback scheduled to run at a cpu cycle
+type Event struct {
+	at       uint64
+	seq      uint64 //events due at the same cycle run in the order they were scheduled
+	index    int    //position in the queue, -1 once the event ran or was canceled
+	callback func()
+	queue    *eventQueue
+}
+
+//At returns the cycle the event is scheduled for
+func (e *Event) At() uint64 {
+	return e.at
+}
+
+//Pending reports whether the event is still waiting to run
+func (e *Event) Pending() bool {
+	return e.index >= 0
+}
+
+//Cancel removes the event from the queue. Canceling an event that already
+//ran does nothing.
+func (e *Event) Cancel() {
+	if e.index >= 0 {
+		heap.Remove(e.queue, e.index)
+	}
+}
+
+//eventQueue is a priority queue of events ordered by cycle. It implements
+//heap.Interface.
+type eventQueue struct {
+	events []*Event
+	seq    uint64
+}
+
+func (q *eventQueue) Len() int {
+	return len(q.events)
+}
+
+func (q *eventQueue) Less(i, j int) bool {
+	a, b := q.events[i], q.events[j]
+	if a.at != b.at {
+		return a.at < b.at
+	}
+	return a.seq < b.seq
+}
+
+func (q *eventQueue) Swap(i, j int) {
+	q.events[i], q.events[j] = q.events[j], q.events[i]
+	q.events[i].index = i
+	q.events[j].index = j
+}
+
+func (q *eventQueue) Push(x interface{}) {
+	e := x.(*Event)
+	e.index = len(q.events)
+	q.events = append(q.events, e)
+}
+
+func (q *eventQueue) Pop() interface{} {
+	n := len(q.events) - 1
+	e := q.events[n]
+	q.events[n] = nil
+	q.events = q.events[:n]
+	e.index = -1
+	return e
+}
+
+//schedule adds a callback running at cycle at
+func (q *eventQueue) schedule(at uint64, callback func()) *Event {
+	e := &Event{at: at, seq: q.seq, callback: callback, queue: q}
+	q.seq++
+	heap.Push(q, e)
+	return e
+}
+
+//next returns the earliest event, or nil if the queue is empty
+func (q *eventQueue) next() *Event {
+	if len(q.events) == 0 {
+		return nil
+	}
+	return q.events[0]
+}
+
+//runUntil runs every event due at or before now, including events scheduled
+//by the callbacks
+func (q *eventQueue) runUntil(now uint64) {
+	for len(q.events) > 0 && q.events[0].at <= now {
+		e := heap.Pop(q).(*Event)
+		e.callback()
+	}
+}
+
+//clear drops all events
+func (q *eventQueue) clear() {
+	for _, e := range q.events {
+		e.index = -1
+	}
+	q.events = nil
+}
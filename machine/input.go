@@ -0,0 +1,125 @@
+package machine
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/rdzhaafar/emu6502/core"
+)
+
+//Input carries host input, like keys typed on a terminal or bytes from a
+//network connection, to a device. Host input arrives on other goroutines at
+//any time, so devices do not take it directly. They send it through an Input
+//instead, and the machine delivers it between instructions, where it can be
+//recorded and replayed.
+type Input struct {
+	m       *Machine
+	device  string
+	deliver func(uint8)
+}
+
+//sentInput is a byte sent through an Input that was not delivered yet
+type sentInput struct {
+	input *Input
+	val   uint8
+}
+
+//hostInputs are the inputs of the devices of a machine
+type hostInputs struct {
+	byDevice map[string]*Input
+	recorder *core.Recorder //set while the machine is recorded
+	replayer *core.Replayer //set while the machine is replayed
+
+	queued int32 //number of bytes in sent, read without taking mu
+	mu     sync.Mutex
+	sent   []sentInput
+}
+
+//Input returns the host input of the device being attached. It must be
+//called from Attach. deliver is called with every byte sent through the
+//input, between instructions on the goroutine running the machine.
+func (m *Machine) Input(deliver func(uint8)) *Input {
+	in := &Input{m: m, device: m.adding, deliver: deliver}
+	m.inputs.byDevice[in.device] = in
+	if m.inputs.recorder != nil {
+		m.inputs.recorder.HandleInput(in.device, deliver)
+	}
+	if m.inputs.replayer != nil {
+		m.inputs.replayer.HandleInput(in.device, deliver)
+	}
+	return in
+}
+
+//Send queues bytes for the device. They are delivered before the next
+//instruction. It can be called from any goroutine.
+func (in *Input) Send(data ...uint8) {
+	inputs := &in.m.inputs
+	inputs.mu.Lock()
+	for _, val := range data {
+		inputs.sent = append(inputs.sent, sentInput{input: in, val: val})
+	}
+	atomic.StoreInt32(&inputs.queued, int32(len(inputs.sent)))
+	inputs.mu.Unlock()
+}
+
+//Record resets the machine and starts recording it, see
+//core.NewResetRecorder. Host input is delivered through the recorder from
+//then on, so it ends up in the recording. The machine should be freshly built,
+//since memory keeps its contents over resets.
+func (m *Machine) Record() *core.Recorder {
+	r := core.NewResetRecorder(m.CPU, m.Reset)
+	for name, in := range m.inputs.byDevice {
+		r.HandleInput(name, in.deliver)
+	}
+	m.inputs.recorder = r
+	m.inputs.replayer = nil
+	return r
+}
+
+//Replay prepares replaying rec on the machine, see core.NewReplayer. The
+//replayer steps and resets the whole machine and delivers the recorded host
+//input to the devices. Live host input is dropped until Live is called.
+func (m *Machine) Replay(rec *core.Recording) (*core.Replayer, error) {
+	p, err := core.NewReplayer(m.CPU, rec)
+	if err != nil {
+		return nil, err
+	}
+	p.Step = m.Step
+	p.ResetMachine = m.Reset
+	for name, in := range m.inputs.byDevice {
+		p.HandleInput(name, in.deliver)
+	}
+	m.inputs.recorder = nil
+	m.inputs.replayer = p
+	return p, nil
+}
+
+//Live ends recording or replaying. Host input is delivered directly again.
+func (m *Machine) Live() {
+	m.inputs.recorder = nil
+	m.inputs.replayer = nil
+}
+
+//deliverInput delivers the host input sent since the last instruction
+func (m *Machine) deliverInput() {
+	inputs := &m.inputs
+	if atomic.LoadInt32(&inputs.queued) == 0 {
+		return
+	}
+	inputs.mu.Lock()
+	sent := inputs.sent
+	inputs.sent = nil
+	atomic.StoreInt32(&inputs.queued, 0)
+	inputs.mu.Unlock()
+	for _, s := range sent {
+		switch {
+		case inputs.replayer != nil:
+			//the replayer delivers the recorded input instead
+		case inputs.recorder != nil:
+			//the handlers of all inputs are registered with the recorder
+			inputs.recorder.Input(s.input.device, s.val)
+		default:
+			s.input.deliver(s.val)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package machine
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/rdzhaafar/emu6502/core"
+)
+
+//DefaultClock is the clock speed of a new machine in Hz
+const DefaultClock uint64 = 1000000
+
+//Device is a peripheral attached to a machine. Devices that are accessed
+//through the bus implement core.SystemBus too.
+type Device interface {
+	//Attach is called once when the device is added to a machine. Devices
+	//keep m to schedule events and to get their interrupt lines from it.
+	Attach(m *Machine) error
+	//Reset puts the device into its power-on state. It is called every time
+	//the machine is reset, after all pending events were dropped.
+	Reset()
+}
+
+//Machine owns a cpu, its bus and the devices attached to them, and runs them
+//together. Devices do not run every cycle. Instead, they schedule events at
+//the cpu cycles they need to act at, and the machine runs those events
+//between instructions.
+type Machine struct {
+	CPU *core.CPU
+	Bus *core.MappedBus
+	//Clock is the clock speed in Hz. Devices use it to convert time to cpu
+	//cycles.
+	Clock uint64
+
+	devices []Device
+	names   []string
+	byName  map[string]Device
+	events  eventQueue
+	irqs    []*Line               //IRQ lines of all devices
+	routes  map[string]func(bool) //IRQ lines of devices routed away from the cpu
+	adding  string                //name of the device being attached
+	irq     int                   //number of asserted IRQ lines
+	nmi     int                   //number of asserted NMI lines
+	nmiEdge bool                  //an NMI line was asserted while no other one was
+	inputs  hostInputs
+}
+
+//New returns a machine running a cpu on bus
+func New(bus *core.MappedBus) *Machine {
+	return &Machine{
+		CPU:    core.NewCPU(bus, core.NewCPURegisters()),
+		Bus:    bus,
+		Clock:  DefaultClock,
+		byName: make(map[string]Device),
+		routes: make(map[string]func(bool)),
+		inputs: hostInputs{byDevice: make(map[string]*Input)},
+	}
+}
+
+//Add attaches device to the machine under name
+func (m *Machine) Add(name string, device Device) error {
+	if _, ok := m.byName[name]; ok {
+		return fmt.Errorf("device %q already exists", name)
+	}
+	m.adding = name
+	err := device.Attach(m)
+	m.adding = ""
+	if err != nil {
+		return fmt.Errorf("attach %s: %w", name, err)
+	}
+	m.devices = append(m.devices, device)
+	m.names = append(m.names, name)
+	m.byName[name] = device
+	return nil
+}
+
+//Map maps device to addresses start through end of the bus. If the device
+//implements Device, it is attached to the machine too.
+func (m *Machine) Map(name string, start, end uint16, device core.SystemBus) error {
+	if d, ok := device.(Device); ok {
+		err := m.Add(name, d)
+		if err != nil {
+			return err
+		}
+	}
+	return m.Bus.Map(name, start, end, device)
+}
+
+//Device returns the device attached under name, or nil if there is none
+func (m *Machine) Device(name string) Device {
+	return m.byName[name]
+}
+
+//Devices returns the names of the attached devices in the order they were
+//added
+func (m *Machine) Devices() []string {
+	return append([]string(nil), m.names...)
+}
+
+//Now returns the current time in cpu cycles
+func (m *Machine) Now() uint64 {
+	return m.CPU.Cycles()
+}
+
+//Cycles converts a duration to cpu cycles at the machine clock speed
+func (m *Machine) Cycles(d time.Duration) uint64 {
+	return uint64(d) * m.Clock / uint64(time.Second)
+}
+
+//Schedule runs callback at cpu cycle at. Events that are due run between
+//instructions, in the order of their cycles. Events for the same cycle run
+//in the order they were scheduled. An event scheduled in the past runs
+//before the next instruction.
+func (m *Machine) Schedule(at uint64, callback func()) *Event {
+	return m.events.schedule(at, callback)
+}
+
+//After runs callback after the cpu has run for the given number of cycles
+func (m *Machine) After(cycles uint64, callback func()) *Event {
+	return m.Schedule(m.Now()+cycles, callback)
+}
+
+//Line is an interrupt output of a device. The lines of all devices are
+//wired together, so the cpu sees an interrupt as long as any of them is
+//asserted. IRQ lines can be routed to an interrupt controller instead.
+type Line struct {
+	m        *Machine
+	nmi      bool
+	asserted bool
+	owner    string     //device the line belongs to
+	route    func(bool) //input the line is routed to, nil for the cpu
+}
+
+//IRQ returns a new maskable interrupt line. The cpu takes the interrupt
+//before every instruction while the line is asserted and interrupts are not
+//disabled.
+func (m *Machine) IRQ() *Line {
+	l := &Line{m: m, owner: m.adding, route: m.routes[m.adding]}
+	m.irqs = append(m.irqs, l)
+	return l
+}
+
+//NMI returns a new non-maskable interrupt line. The cpu takes the interrupt
+//when the combined NMI line goes from released to asserted.
+func (m *Machine) NMI() *Line {
+	return &Line{m: m, nmi: true, owner: m.adding}
+}
+
+//RouteIRQ disconnects the IRQ lines of the named device from the cpu and
+//connects them to input, which is how interrupt controllers collect their
+//sources. input is called with true whenever one of the lines is asserted
+//and with false whenever one is released, so a device with several lines
+//needs counting. Lines that are asserted at the time are moved over.
+//Devices added later under the same name are routed too.
+func (m *Machine) RouteIRQ(device string, input func(asserted bool)) {
+	m.routes[device] = input
+	for _, l := range m.irqs {
+		if l.owner != device {
+			continue
+		}
+		if l.asserted {
+			if l.route == nil {
+				m.irq--
+			} else {
+				l.route(false)
+			}
+			input(true)
+		}
+		l.route = input
+	}
+}
+
+//Set asserts or releases the line
+func (l *Line) Set(asserted bool) {
+	if l.asserted == asserted {
+		return
+	}
+	l.asserted = asserted
+	if l.route != nil {
+		l.route(asserted)
+		return
+	}
+	count := &l.m.irq
+	if l.nmi {
+		count = &l.m.nmi
+	}
+	if asserted {
+		*count++
+		if l.nmi && *count == 1 {
+			l.m.nmiEdge = true
+		}
+	} else {
+		*count--
+	}
+}
+
+//Asserted reports whether the line is asserted
+func (l *Line) Asserted() bool {
+	return l.asserted
+}
+
+//IRQAsserted reports whether any IRQ line is asserted
+func (m *Machine) IRQAsserted() bool {
+	return m.irq > 0
+}
+
+//Reset drops all pending events, resets every device and then the cpu
+func (m *Machine) Reset() {
+	m.events.clear()
+	m.nmiEdge = false
+	for _, d := range m.devices {
+		d.Reset()
+	}
+	m.CPU.Reset()
+}
+
+//Close closes the devices that hold on to host resources, like files or
+//network connections, in the reverse order they were added. The first error
+//is returned.
+func (m *Machine) Close() error {
+	var err error
+	for i := len(m.devices) - 1; i >= 0; i-- {
+		closer, ok := m.devices[i].(io.Closer)
+		if !ok {
+			continue
+		}
+		cerr := closer.Close()
+		if err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+//Step delivers host input, runs the events that are due, delivers pending
+//interrupts and executes one instruction. If the cpu is waiting in WAI or STP, time skips ahead to the
+//next event first. The error returned by a failed bus write is returned.
+func (m *Machine) Step() (err error) {
+	defer func() {
+		//cpu.write panics when the bus returns an error
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if _, isRuntime := r.(runtime.Error); !ok || isRuntime {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	m.deliverInput()
+	if m.Idle() {
+		if next := m.events.next(); next != nil {
+			m.skipTo(next.at)
+		}
+	}
+	m.events.runUntil(m.Now())
+	m.interrupt()
+	m.CPU.Execute()
+	return nil
+}
+
+//Idle reports whether the cpu is waiting in WAI or STP with no interrupt
+//pending to wake it up
+func (m *Machine) Idle() bool {
+	if m.CPU.Stopped() {
+		return true
+	}
+	return m.CPU.Waiting() && m.irq == 0 && !m.nmiEdge
+}
+
+//skipTo advances the cpu to cycle at, if it is not past it already
+func (m *Machine) skipTo(at uint64) {
+	if now := m.Now(); at > now {
+		m.CPU.Idle(at - now)
+	}
+}
+
+//interrupt delivers pending interrupts to the cpu
+func (m *Machine) interrupt() {
+	if m.nmiEdge {
+		m.nmiEdge = false
+		m.CPU.NMInterrupt()
+	}
+	if m.irq > 0 {
+		m.CPU.Interrupt()
+	}
+}
+
+//Run runs the machine for at least the given number of cycles. While the cpu
+//waits in WAI or STP, time skips ahead to the next event instead of running
+//the cpu, and to the end of the run if no event is due before it. Run
+//returns early when a bus write fails.
+func (m *Machine) Run(cycles uint64) error {
+	end := m.Now() + cycles
+	for m.Now() < end {
+		if m.Idle() {
+			at := end
+			if next := m.events.next(); next != nil && next.at < end {
+				at = next.at
+			}
+			m.skipTo(at)
+			if m.Now() >= end {
+				break
+			}
+		}
+		err := m.Step()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package machine
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/rdzhaafar/emu6502/core"
+)
+
+//newNOPMachine returns a machine with 64K of RAM filled with NOPs, which take
+//2 cycles each. The vectors point into the NOPs as well.
+func newNOPMachine() *Machine {
+	ram := core.NewRAM(core.MaxBusSize)
+	ram.Load(0x0000, bytes.Repeat([]uint8{0xea}, core.MaxBusSize))
+	bus := core.NewMappedBus()
+	bus.Map("ram", 0x0000, 0xffff, ram)
+	return New(bus)
+}
+
+//lineDevice is a device with an IRQ and an NMI line
+type lineDevice struct {
+	irq    *Line
+	nmi    *Line
+	resets int
+}
+
+func (d *lineDevice) Attach(m *Machine) error {
+	d.irq = m.IRQ()
+	d.nmi = m.NMI()
+	return nil
+}
+
+func (d *lineDevice) Reset() {
+	d.resets++
+}
+
+func addLineDevice(t *testing.T, m *Machine, name string) *lineDevice {
+	d := &lineDevice{}
+	err := m.Add(name, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+//step runs m for one instruction
+func step(t *testing.T, m *Machine) {
+	err := m.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+//interrupts returns the number of interrupts the cpu took from the stack it
+//pushed their return addresses and status to
+func interrupts(m *Machine) int {
+	return int(0xfd-m.CPU.Registers.StackPointer) / 3
+}
+
+func TestEventOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		at    []uint64 //cycles the events are scheduled at, in order
+		order []int    //indexes of the events in the order they should run
+	}{
+		{"in cycle order", []uint64{30, 10, 20}, []int{1, 2, 0}},
+		{"same cycle in scheduling order", []uint64{10, 10, 5, 10}, []int{2, 0, 1, 3}},
+		{"past events first", []uint64{8, 0, 4}, []int{1, 2, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newNOPMachine()
+			var ran []int
+			for i, at := range test.at {
+				i := i
+				m.Schedule(at, func() { ran = append(ran, i) })
+			}
+			err := m.Run(40)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ran, test.order) {
+				t.Fatalf("events ran in order %v, want %v", ran, test.order)
+			}
+		})
+	}
+}
+
+func TestEventRunsBetweenInstructions(t *testing.T) {
+	m := newNOPMachine()
+	var at uint64
+	//NOPs take 2 cycles, so the event runs before the instruction at 6
+	m.Schedule(5, func() { at = m.Now() })
+	m.Run(10)
+	if at != 6 {
+		t.Fatalf("event due at cycle 5 ran at %d, want 6", at)
+	}
+	//events scheduled by events run as soon as they are due
+	var chained []uint64
+	m.After(2, func() {
+		chained = append(chained, m.Now())
+		m.After(0, func() { chained = append(chained, m.Now()) })
+	})
+	m.Run(4)
+	if !reflect.DeepEqual(chained, []uint64{12, 12}) {
+		t.Fatalf("chained events ran at %v, want [12 12]", chained)
+	}
+}
+
+func TestEventCancel(t *testing.T) {
+	m := newNOPMachine()
+	ran := false
+	e := m.After(4, func() { ran = true })
+	if !e.Pending() || e.At() != 4 {
+		t.Fatalf("new event is pending %v at %d, want pending at 4", e.Pending(), e.At())
+	}
+	other := m.After(4, func() {})
+	e.Cancel()
+	m.Run(10)
+	if ran || e.Pending() {
+		t.Fatal("canceled event ran")
+	}
+	if other.Pending() {
+		t.Fatal("canceling an event dropped another one")
+	}
+	//canceling an event that ran does nothing
+	other.Cancel()
+	e.Cancel()
+}
+
+func TestResetClearsEvents(t *testing.T) {
+	m := newNOPMachine()
+	d := addLineDevice(t, m, "device")
+	ran := false
+	e := m.After(4, func() { ran = true })
+	d.nmi.Set(true)
+	m.Reset()
+	if e.Pending() || d.resets != 1 {
+		t.Fatalf("after a reset the event is pending %v and the device was reset %d times", e.Pending(), d.resets)
+	}
+	m.Run(10)
+	if ran {
+		t.Fatal("event scheduled before the reset ran")
+	}
+	//the NMI edge from before the reset is dropped too
+	if n := interrupts(m); n != 0 {
+		t.Fatalf("cpu took %d interrupts after the reset, want 0", n)
+	}
+}
+
+func TestLinesCountAsserters(t *testing.T) {
+	m := newNOPMachine()
+	a := addLineDevice(t, m, "a")
+	b := addLineDevice(t, m, "b")
+	steps := []struct {
+		line     *Line
+		asserted bool
+		want     bool
+	}{
+		{a.irq, true, true},
+		{b.irq, true, true},
+		{a.irq, true, true}, //asserting twice counts once
+		{a.irq, false, true},
+		{a.irq, false, true},
+		{b.irq, false, false},
+	}
+	for i, s := range steps {
+		s.line.Set(s.asserted)
+		if m.IRQAsserted() != s.want {
+			t.Fatalf("step %d: IRQ asserted is %v, want %v", i, m.IRQAsserted(), s.want)
+		}
+	}
+}
+
+func TestNMIOnEdge(t *testing.T) {
+	m := newNOPMachine()
+	a := addLineDevice(t, m, "a")
+	b := addLineDevice(t, m, "b")
+	steps := []struct {
+		line     *Line
+		asserted bool
+		want     int //interrupts taken after the next instruction
+	}{
+		{a.nmi, true, 1},
+		{nil, false, 1}, //a held line does not interrupt again
+		{b.nmi, true, 1},
+		{a.nmi, false, 1},
+		{b.nmi, false, 1},
+		{b.nmi, true, 2},
+	}
+	for i, s := range steps {
+		if s.line != nil {
+			s.line.Set(s.asserted)
+		}
+		step(t, m)
+		if n := interrupts(m); n != s.want {
+			t.Fatalf("step %d: cpu took %d NMIs, want %d", i, n, s.want)
+		}
+	}
+}
+
+func TestRouteIRQ(t *testing.T) {
+	m := newNOPMachine()
+	d := addLineDevice(t, m, "device")
+	d.irq.Set(true)
+	var routed []bool
+	m.RouteIRQ("device", func(asserted bool) { routed = append(routed, asserted) })
+	if m.IRQAsserted() {
+		t.Fatal("the cpu still sees a routed line")
+	}
+	d.irq.Set(false)
+	if !reflect.DeepEqual(routed, []bool{true, false}) {
+		t.Fatalf("route saw %v, want the asserted line and its release", routed)
+	}
+	//lines of devices added later are routed too
+	var later int
+	m.RouteIRQ("later", func(asserted bool) { later++ })
+	l := addLineDevice(t, m, "later")
+	l.irq.Set(true)
+	if later != 1 || m.IRQAsserted() {
+		t.Fatal("line of a device added after its route went to the cpu")
+	}
+}
+
+func TestCycleAccounting(t *testing.T) {
+	tests := []struct {
+		name   string
+		run    func(m *Machine) error
+		cycles uint64
+	}{
+		{"step", (*Machine).Step, 2},
+		{"run", func(m *Machine) error { return m.Run(10) }, 10},
+		{"run rounds up to whole instructions", func(m *Machine) error { return m.Run(11) }, 12},
+		{"run nothing", func(m *Machine) error { return m.Run(0) }, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newNOPMachine()
+			err := test.run(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Now() != test.cycles {
+				t.Fatalf("machine is at cycle %d, want %d", m.Now(), test.cycles)
+			}
+			if got := m.CPU.Instructions(); got != test.cycles/2 {
+				t.Fatalf("cpu executed %d instructions, want %d", got, test.cycles/2)
+			}
+		})
+	}
+}
+
+//newIdleMachine returns a NOP machine that executed the given WAI or STP
+//opcode at 0000
+func newIdleMachine(t *testing.T, opcode uint8) *Machine {
+	m := newNOPMachine()
+	m.Bus.Write(0x0000, opcode)
+	step(t, m)
+	if !m.Idle() {
+		t.Fatalf("cpu is not idle after opcode %#02x", opcode)
+	}
+	return m
+}
+
+func TestIdleSkipsToEvents(t *testing.T) {
+	for _, opcode := range []uint8{0xcb, 0xdb} { //WAI, STP
+		m := newIdleMachine(t, opcode)
+		start, instructions := m.Now(), m.CPU.Instructions()
+		var at []uint64
+		m.Schedule(start+1000, func() { at = append(at, m.Now()) })
+		m.Schedule(start+3000, func() { at = append(at, m.Now()) })
+		//a step skips to the next event
+		step(t, m)
+		if !reflect.DeepEqual(at, []uint64{start + 1000}) {
+			t.Fatalf("opcode %#02x: event due at %d ran at %v", opcode, start+1000, at)
+		}
+		//a run skips from event to event and then to its end
+		err := m.Run(5000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(at, []uint64{start + 1000, start + 3000}) {
+			t.Fatalf("opcode %#02x: events ran at %v", opcode, at)
+		}
+		if m.Now() != start+6000 || m.CPU.Cycles() != m.Now() {
+			t.Fatalf("opcode %#02x: machine is at cycle %d, want %d", opcode, m.Now(), start+6000)
+		}
+		if m.CPU.Instructions() != instructions {
+			t.Fatalf("opcode %#02x: idle cpu executed %d instructions", opcode, m.CPU.Instructions()-instructions)
+		}
+	}
+}
+
+func TestIRQEndsIdleSkip(t *testing.T) {
+	m := newIdleMachine(t, 0xcb)
+	d := addLineDevice(t, m, "device")
+	start := m.Now()
+	m.Schedule(start+1000, func() { d.irq.Set(true) })
+	m.Schedule(start+5000, func() {})
+	step(t, m)
+	if m.Now() >= start+5000 || interrupts(m) != 1 {
+		t.Fatalf("cpu is at cycle %d after %d interrupts, want the IRQ at %d to wake it",
+			m.Now(), interrupts(m), start+1000)
+	}
+	//an IRQ that is asserted already keeps the cpu from skipping
+	m = newIdleMachine(t, 0xcb)
+	d = addLineDevice(t, m, "device")
+	m.Schedule(m.Now()+5000, func() {})
+	d.irq.Set(true)
+	if m.Idle() {
+		t.Fatal("cpu with a pending IRQ is idle")
+	}
+	start = m.Now()
+	err := m.Run(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Now() >= start+5000 || interrupts(m) == 0 {
+		t.Fatalf("cpu skipped to cycle %d with an IRQ pending", m.Now())
+	}
+}
@@ -0,0 +1,71 @@
+package machine
+
+import (
+	"fmt"
+	"path/filepath"
+	"plugin"
+	"sync"
+)
+
+//loadedPlugin is the outcome of loading a plugin
+type loadedPlugin struct {
+	types []string
+	err   error
+}
+
+var (
+	pluginsMu sync.Mutex
+	plugins   = make(map[string]loadedPlugin) //loaded plugins by absolute path
+)
+
+//LoadPlugin loads the Go plugin at path and returns the device types it
+//registered. A plugin is a package main built with -buildmode=plugin that
+//calls RegisterDevice from its init function. It has to be built with the
+//same Go version and the same versions of this module as the program that
+//loads it. A plugin registering a type that already exists is an error, and
+//none of its types are kept. Loading a plugin again returns what loading it
+//the first time did, since Go runs the init functions of a plugin only once.
+func LoadPlugin(path string) ([]string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if loaded, ok := plugins[abs]; ok {
+		return loaded.types, loaded.err
+	}
+	types, err := registerPlugin(path, func() error {
+		_, err := plugin.Open(abs)
+		return err
+	})
+	plugins[abs] = loadedPlugin{types: types, err: err}
+	return types, err
+}
+
+//registerPlugin collects the device types registered while open runs. If
+//one of them can not be registered, the others are dropped again. pluginsMu
+//must be held.
+func registerPlugin(path string, open func() error) ([]string, error) {
+	registryMu.Lock()
+	loading = &pluginTypes{}
+	registryMu.Unlock()
+	err := open()
+	registryMu.Lock()
+	loaded := loading
+	loading = nil
+	if err == nil && loaded.err != nil {
+		for _, typ := range loaded.types {
+			delete(registry, typ)
+		}
+		err = fmt.Errorf("plugin %s: %w", path, loaded.err)
+	}
+	registryMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if len(loaded.types) == 0 {
+		return nil, fmt.Errorf("plugin %s registers no device types", path)
+	}
+	return loaded.types, nil
+}
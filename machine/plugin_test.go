@@ -0,0 +1,108 @@
+package machine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//newPluginDevice is the factory of the device types registered by the fake
+//plugins in the tests
+func newPluginDevice(options Options) (Device, error) {
+	return &testDevice{}, nil
+}
+
+//unregister removes device types registered by a test
+func unregister(t *testing.T, types ...string) {
+	t.Cleanup(func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		for _, typ := range types {
+			delete(registry, typ)
+		}
+	})
+}
+
+//registered reports whether a device type is registered
+func registered(typ string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	_, ok := registry[typ]
+	return ok
+}
+
+func TestRegisterPlugin(t *testing.T) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	unregister(t, "plugin-a", "plugin-b")
+	types, err := registerPlugin("good.so", func() error {
+		RegisterDevice("plugin-a", newPluginDevice)
+		RegisterDevice("plugin-b", newPluginDevice)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(types, []string{"plugin-a", "plugin-b"}) {
+		t.Fatalf("plugin registered %v, want [plugin-a plugin-b]", types)
+	}
+	if !registered("plugin-a") || !registered("plugin-b") {
+		t.Fatal("plugin types are not registered")
+	}
+
+	_, err = registerPlugin("empty.so", func() error {
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "empty.so") {
+		t.Fatalf("plugin registering nothing returned %v", err)
+	}
+}
+
+func TestRegisterPluginDuplicate(t *testing.T) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	tests := []struct {
+		name     string
+		register func()
+		dup      string
+	}{
+		{"type of the program", func() {
+			RegisterDevice("plugin-c", newPluginDevice)
+			RegisterDevice("test", newPluginDevice)
+		}, "test"},
+		{"type registered twice", func() {
+			RegisterDevice("plugin-d", newPluginDevice)
+			RegisterDevice("plugin-d", newPluginDevice)
+		}, "plugin-d"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			//a panic in RegisterDevice fails the test
+			_, err := registerPlugin("dup.so", func() error {
+				test.register()
+				return nil
+			})
+			if err == nil {
+				t.Fatal("plugin with a duplicate type was loaded")
+			}
+			if msg := err.Error(); !strings.Contains(msg, "dup.so") || !strings.Contains(msg, test.dup) {
+				t.Fatalf("error %q does not name the plugin and %s", msg, test.dup)
+			}
+			for _, typ := range []string{"plugin-c", "plugin-d"} {
+				if registered(typ) {
+					t.Fatalf("type %s of the failed plugin is still registered", typ)
+				}
+			}
+		})
+	}
+	if !registered("test") {
+		t.Fatal("plugin unregistered a type of the program")
+	}
+	//outside of plugins duplicates still panic
+	defer func() {
+		if recover() == nil {
+			t.Fatal("RegisterDevice did not panic on a duplicate type")
+		}
+	}()
+	RegisterDevice("test", newPluginDevice)
+}
@@ -0,0 +1,99 @@
+package machine
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+//Options are the options of a device in a machine config
+type Options struct {
+	raw json.RawMessage
+	dir string //directory of the config file
+}
+
+//Decode decodes the options into v, usually a pointer to a struct. Missing
+//options leave v untouched, so fill it with defaults first.
+func (o Options) Decode(v interface{}) error {
+	if len(o.raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(o.raw, v)
+}
+
+//Path resolves a file name given in the options relative to the directory of
+//the config file
+func (o Options) Path(name string) string {
+	if name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(o.dir, name)
+}
+
+//DeviceFactory creates a device from its options in a machine config.
+//Devices that are mapped to the bus must implement core.SystemBus too.
+type DeviceFactory func(options Options) (Device, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]DeviceFactory)
+	loading    *pluginTypes //set while a plugin registers its device types
+)
+
+//pluginTypes are the device types registered by a plugin while it is loaded
+type pluginTypes struct {
+	types []string
+	err   error //first type the plugin could not register
+}
+
+//RegisterDevice makes a device type available to machine configs under
+//name. Packages providing devices usually register them in their init
+//function. RegisterDevice panics if a type is registered twice, except
+//while a plugin is loaded, where LoadPlugin returns the error instead.
+func RegisterDevice(name string, factory DeviceFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	var err error
+	if factory == nil {
+		err = fmt.Errorf("device type %s has no factory", name)
+	} else if _, dup := registry[name]; dup {
+		err = fmt.Errorf("device type %s is already registered", name)
+	}
+	if loading != nil {
+		if err != nil {
+			if loading.err == nil {
+				loading.err = err
+			}
+			return
+		}
+		loading.types = append(loading.types, name)
+	} else if err != nil {
+		panic("machine: RegisterDevice: " + err.Error())
+	}
+	registry[name] = factory
+}
+
+//DeviceTypes returns the sorted names of the registered device types
+func DeviceTypes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//newDevice creates a device of a registered type
+func newDevice(typ string, options Options) (Device, error) {
+	registryMu.RLock()
+	factory, ok := registry[typ]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown device type %q", typ)
+	}
+	return factory(options)
+}